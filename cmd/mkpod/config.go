@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// The settings that differ between machines and deployment targets
+// (baseURL, AWS profile, region, endpoint, buckets, localStorageDir and
+// the paths of lame and ffmpeg) are layered on top of the spec: the spec
+// (over the workspace, see show.go), then the environment of the spec
+// selected with --env, then the untracked podspec.local.yaml next to it,
+// then MKPOD_* environment variables and last the global command line
+// flags. Layers after the spec are never written back into it. mkpod
+// config prints the effective value of each setting and the layer it
+// came from.
+
+const (
+	localSpecSuffix string = ".local"
+	sourceDefault   string = "default"
+)
+
+// configSetting is a setting that can be overridden after the spec.
+type configSetting struct {
+	// Key in the spec.
+	Key  string
+	Env  string
+	Flag string
+	// Field of the setting in the atom.
+	field func(a *Atom) *string
+}
+
+var configSettings = []configSetting{
+	{"config.baseURL", "MKPOD_BASE_URL", "base-url", func(a *Atom) *string { return &a.Config.BaseURL }},
+	{"config.aws.profile", "MKPOD_AWS_PROFILE", "aws-profile", func(a *Atom) *string { return &a.Config.Aws.Profile }},
+	{"config.aws.region", "MKPOD_AWS_REGION", "aws-region", func(a *Atom) *string { return &a.Config.Aws.Region }},
+	{"config.aws.buckets.input", "MKPOD_INPUT_BUCKET", "input-bucket", func(a *Atom) *string { return &a.Config.Aws.Buckets.Input }},
+	{"config.aws.buckets.output", "MKPOD_OUTPUT_BUCKET", "output-bucket", func(a *Atom) *string { return &a.Config.Aws.Buckets.Output }},
+	{"config.aws.endpoint", "MKPOD_AWS_ENDPOINT", "aws-endpoint", func(a *Atom) *string { return &a.Config.Aws.Endpoint }},
+	{"config.localStorageDir", "MKPOD_LOCAL_STORAGE_DIR", "local-storage-dir", func(a *Atom) *string { return &a.Config.LocalStorageDir }},
+	{"encoding.lamepath", "MKPOD_LAMEPATH", "lamepath", func(a *Atom) *string { return &a.Encoding.Lamepath }},
+	{"encoding.ffmpegpath", "MKPOD_FFMPEGPATH", "ffmpegpath", func(a *Atom) *string { return &a.Encoding.FFmpegPath }},
+}
+
+// localSpec is the content of podspec.local.yaml.
+type localSpec struct {
+	Config   yaml.Node `yaml:"config,omitempty"`
+	Encoding yaml.Node `yaml:"encoding,omitempty"`
+}
+
+// ConfigValue is the effective value of a setting and where it came
+// from.
+type ConfigValue struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// configFlags returns the global flags overriding the settings.
+func configFlags() []cli.Flag {
+	var flags []cli.Flag
+	for _, setting := range configSettings {
+		flags = append(flags, &cli.StringFlag{
+			Name:  setting.Flag,
+			Usage: fmt.Sprintf("Override %s (after the spec, podspec.local.yaml and %s)", setting.Key, setting.Env),
+		})
+	}
+	return flags
+}
+
+// globalContext returns the context of the global flags, the parent of
+// the command context c (a command can have a flag of the same name as
+// a global flag, e.g import), nil if c has no parent.
+func globalContext(c *cli.Context) *cli.Context {
+	lineage := c.Lineage()
+	if len(lineage) < 2 {
+		return nil
+	}
+	return lineage[1]
+}
+
+// configOverrides returns the settings given as global flags by flag
+// name.
+func configOverrides(c *cli.Context) map[string]string {
+	overrides := make(map[string]string)
+	global := globalContext(c)
+	if global == nil {
+		return overrides
+	}
+	for _, setting := range configSettings {
+		if global.IsSet(setting.Flag) {
+			overrides[setting.Flag] = global.String(setting.Flag)
+		}
+	}
+	return overrides
+}
+
+// selectedEnvironment returns the environment selected with --env,
+// empty if none.
+func selectedEnvironment(c *cli.Context) string {
+	if global := globalContext(c); global != nil {
+		return strings.TrimSpace(global.String("env"))
+	}
+	return ""
+}
+
+// environmentFlag returns the global flag selecting the environment.
+func environmentFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "env",
+		EnvVars: []string{"MKPOD_ENV"},
+		Usage:   "Environment in the environments section of the spec (e.g staging or production) to deploy to",
+	}
+}
+
+// localSpecFile returns the name of the local spec next to the spec,
+// podspec.local.yaml for podspec.yaml.
+func (s *Show) localSpecFile() string {
+	ext := filepath.Ext(s.SpecFile)
+	return strings.TrimSuffix(s.SpecFile, ext) + localSpecSuffix + ext
+}
+
+// readLocalSpec returns the content of the local spec, nil if there is
+// none.
+func (s *Show) readLocalSpec() ([]byte, error) {
+	b, err := os.ReadFile(s.localSpecFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// checkLocalSpecKeys checks the keys of the local spec.
+func (s *Show) checkLocalSpecKeys() error {
+	b, err := s.readLocalSpec()
+	if err != nil || b == nil {
+		return err
+	}
+	file := s.localSpecFile()
+	var local localSpec
+	if err := yaml.Unmarshal(b, &local); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return errors.Join(
+		checkYAMLKeys(file, b, localSpec{}, 0),
+		checkKeys(file, &local.Config, Config{}, "config", 0),
+		checkKeys(file, &local.Encoding, Atom{}.Encoding, "encoding", 0),
+	)
+}
+
+// configLayers decodes the settings layered after the spec (the
+// selected environment of the spec doc, the local spec, the environment
+// variables and the flags) into a and records the source of each
+// setting they set in sources.
+func (s *Show) configLayers(a *Atom, doc *yaml.Node, sources map[string]string) error {
+	if s.Environment != "" {
+		env := lookupNode(doc, "environments", s.Environment)
+		if env == nil {
+			return fmt.Errorf("there is no environment %s in %s (environments: %s)", s.Environment, s.SpecFile, strings.Join(a.EnvironmentNames(), ", "))
+		}
+		if err := decodeConfigLayer(a, env, &yaml.Node{}, fmt.Sprintf("%s (environment %s)", s.SpecFile, s.Environment), sources); err != nil {
+			return err
+		}
+	}
+	b, err := s.readLocalSpec()
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		var local localSpec
+		if err := yaml.Unmarshal(b, &local); err != nil {
+			return fmt.Errorf("%s: %w", s.localSpecFile(), err)
+		}
+		if err := decodeConfigLayer(a, &local.Config, &local.Encoding, s.localSpecFile(), sources); err != nil {
+			return err
+		}
+	}
+	for _, setting := range configSettings {
+		if v := os.Getenv(setting.Env); v != "" {
+			*setting.field(a) = v
+			sources[setting.Key] = setting.Env
+		}
+		if v, found := s.overrides[setting.Flag]; found {
+			*setting.field(a) = v
+			sources[setting.Key] = "--" + setting.Flag
+		}
+	}
+	return nil
+}
+
+// decodeConfigLayer decodes the config and encoding nodes of a layer
+// into a and records source for the settings present in them.
+func decodeConfigLayer(a *Atom, config *yaml.Node, encoding *yaml.Node, source string, sources map[string]string) error {
+	if config.Kind != 0 {
+		if err := config.Decode(&a.Config); err != nil {
+			return fmt.Errorf("config in %s: %w", source, err)
+		}
+	}
+	if encoding.Kind != 0 {
+		if err := encoding.Decode(&a.Encoding); err != nil {
+			return fmt.Errorf("encoding in %s: %w", source, err)
+		}
+	}
+	recordSources(config, encoding, source, sources)
+	return nil
+}
+
+// recordSources records source for the settings present in the config
+// and encoding nodes of a layer.
+func recordSources(config *yaml.Node, encoding *yaml.Node, source string, sources map[string]string) {
+	for _, setting := range configSettings {
+		section, rest, _ := strings.Cut(setting.Key, ".")
+		n := config
+		if section == "encoding" {
+			n = encoding
+		}
+		if lookupNode(n, strings.Split(rest, ".")...) != nil {
+			sources[setting.Key] = source
+		}
+	}
+}
+
+// lookupNode returns the value at keys in mapping n, nil if not found
+// (or n is nil).
+func lookupNode(n *yaml.Node, keys ...string) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	if len(keys) == 0 {
+		return n
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == keys[0] {
+			return lookupNode(n.Content[i+1], keys[1:]...)
+		}
+	}
+	return nil
+}
+
+// EnvironmentNames returns the names of the environments, sorted.
+func (a *Atom) EnvironmentNames() []string {
+	var names []string
+	for name := range a.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConfigValues returns the effective value and source of each setting.
+func (s *Show) ConfigValues() []ConfigValue {
+	var values []ConfigValue
+	for _, setting := range configSettings {
+		source, found := s.configSources[setting.Key]
+		if !found {
+			source = sourceDefault
+		}
+		values = append(values, ConfigValue{
+			Key:    setting.Key,
+			Value:  *setting.field(&s.Atom),
+			Source: source,
+		})
+	}
+	return values
+}
+
+func (s *Show) configShow(c *cli.Context) error {
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+	values := s.ConfigValues()
+	if c.Bool("json") {
+		return writeJSON(os.Stdout, values)
+	}
+	if s.Environment != "" {
+		fmt.Printf("Environment %s\n\n", s.Environment)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, v := range values {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Key, v.Value, v.Source)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfigLayers(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "podspec.yaml")
+	content := "atom: podcast.rss\nconfig:\n  localStorageDir: ~/pod\n  aws:\n    profile: default\n    region: eu-west-1\n    buckets:\n      input: masters\n      output: pod\nencoding:\n  lamepath: /usr/bin/lame\n"
+	files := map[string]string{
+		spec:                                     content,
+		filepath.Join(dir, "podspec.local.yaml"): "config:\n  aws:\n    profile: laptop\n    region: eu-north-1\nencoding:\n  ffmpegpath: ~/bin/ffmpeg\n",
+	}
+	for file, content := range files {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("MKPOD_AWS_REGION", "us-east-1")
+	t.Setenv("MKPOD_OUTPUT_BUCKET", "ci-pod")
+
+	shows, err := selectShows(globalTestContext(t, spec, "--output-bucket", "flag-pod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := shows[0]
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][2]string{
+		"config.baseURL":            {"", sourceDefault},
+		"config.aws.endpoint":       {"", sourceDefault},
+		"config.aws.profile":        {"laptop", filepath.Join(dir, "podspec.local.yaml")},
+		"config.aws.region":         {"us-east-1", "MKPOD_AWS_REGION"},
+		"config.aws.buckets.input":  {"masters", spec},
+		"config.aws.buckets.output": {"flag-pod", "--output-bucket"},
+		"config.localStorageDir":    {"~/pod", spec},
+		"encoding.lamepath":         {"/usr/bin/lame", spec},
+		"encoding.ffmpegpath":       {"~/bin/ffmpeg", filepath.Join(dir, "podspec.local.yaml")},
+	}
+	values := s.ConfigValues()
+	if len(values) != len(expected) {
+		t.Fatalf("expected %d settings, got %d", len(expected), len(values))
+	}
+	for _, v := range values {
+		if e := expected[v.Key]; v.Value != e[0] || v.Source != e[1] {
+			t.Errorf("expected %s to be %s from %s, got %s from %s", v.Key, e[0], e[1], v.Value, v.Source)
+		}
+	}
+	if s.Atom.Config.Aws.Buckets.Output != "flag-pod" {
+		t.Errorf("expected output bucket of the flag, got %s", s.Atom.Config.Aws.Buckets.Output)
+	}
+
+	// The layers after the spec are not written back.
+	s.Atom.Title = "Podcast"
+	b, err := s.specBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "atom: podcast.rss\ntitle: Podcast\n" + content[len("atom: podcast.rss\n"):]; string(b) != expected {
+		t.Errorf("expected spec:\n%s\ngot:\n%s", expected, b)
+	}
+}
+
+func TestEnvironments(t *testing.T) {
+	spec := filepath.Join(t.TempDir(), "podspec.yaml")
+	content := "atom: podcast.rss\ntitle: QZJ\nconfig:\n  baseURL: https://pod.example.com\n  aws:\n    region: eu-north-1\n    buckets:\n      output: pod\nenvironments:\n  staging:\n    baseURL: http://minio.local:9000/pod-staging\n    aws:\n      endpoint: http://minio.local:9000\n      buckets:\n        output: pod-staging\n  production:\n    baseURL: https://cdn.example.com\nepisodes:\n- uid: 1\n  title: First\n  pubDate: Mon, 02 Jan 2006 15:04:05 +0000\n  output: qzj001.mp3\n  image: qzj001.jpg\n"
+	if err := os.WriteFile(spec, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		env, baseURL, bucket, endpoint string
+	}{
+		{"", "https://pod.example.com", "pod", ""},
+		{"staging", "http://minio.local:9000/pod-staging", "pod-staging", "http://minio.local:9000"},
+		{"production", "https://cdn.example.com", "pod", ""},
+	} {
+		args := []string{}
+		if test.env != "" {
+			args = append(args, "--env", test.env)
+		}
+		shows, err := selectShows(globalTestContext(t, spec, args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := shows[0]
+		if err := s.loadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		target := s.Atom.ProductionTarget()
+		if target.BaseURL != test.baseURL || target.Bucket != test.bucket || s.Atom.Config.Aws.Endpoint != test.endpoint {
+			t.Errorf("env %q: unexpected target %+v (endpoint %q)", test.env, target, s.Atom.Config.Aws.Endpoint)
+		}
+		if s.Atom.Config.Aws.Region != "eu-north-1" {
+			t.Errorf("env %q: expected region of the spec, got %s", test.env, s.Atom.Config.Aws.Region)
+		}
+		if test.env != "" {
+			for _, v := range s.ConfigValues() {
+				if v.Key == "config.baseURL" && v.Source != spec+" (environment "+test.env+")" {
+					t.Errorf("env %q: unexpected baseURL source %s", test.env, v.Source)
+				}
+			}
+		}
+		s.Atom.Episodes[0].PubDate = ItunesTime{time.Now().Add(-time.Hour)}
+		b, err := s.renderFeed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []string{test.baseURL + "/podcast.rss", test.baseURL + "/qzj001.mp3"} {
+			if !strings.Contains(string(b), expected) {
+				t.Errorf("env %q: expected %s in the feed", test.env, expected)
+			}
+		}
+
+		// The environment is not written back.
+		s.Atom.Episodes[0].PubDate = ItunesTime{}
+		b, err = s.specBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), "config:\n  baseURL: https://pod.example.com\n") || !strings.Contains(string(b), "\n      endpoint: http://minio.local:9000\n") {
+			t.Errorf("env %q: expected environments left as is, got:\n%s", test.env, b)
+		}
+	}
+
+	shows, err := selectShows(globalTestContext(t, spec, "--env", "qa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = shows[0].loadConfig()
+	if err == nil || !strings.Contains(err.Error(), "there is no environment qa") || !strings.Contains(err.Error(), "(environments: production, staging)") {
+		t.Errorf("expected unknown environment error, got %v", err)
+	}
+}
+
+func TestSpecBytesFallbackWithoutLayers(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "podspec.yaml")
+	// A flow style spec can not be patched.
+	content := "{atom: podcast.rss, config: {aws: {buckets: {output: pod}}}, environments: {ci: {baseURL: https://ci.example.com}}, episodes: [{uid: 1, title: First}]}\n"
+	if err := os.WriteFile(spec, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MKPOD_AWS_REGION", "us-east-1")
+	shows, err := selectShows(globalTestContext(t, spec, "--env", "ci", "--output-bucket", "flag-pod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := shows[0]
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	s.Atom.Episodes[0].Output = "qzj001.mp3"
+	b, err := s.specBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written Atom
+	if err := yaml.Unmarshal(b, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Config.Aws.Buckets.Output != "pod" || written.Config.Aws.Region != "" || written.Config.BaseURL != "" {
+		t.Errorf("expected the config of the spec alone, got %+v", written.Config)
+	}
+	if len(written.Episodes) != 1 || written.Episodes[0].Output != "qzj001.mp3" {
+		t.Errorf("expected the changed episode, got %+v", written.Episodes)
+	}
+	if written.Environments["ci"].BaseURL != "https://ci.example.com" {
+		t.Errorf("expected environments to be kept, got %+v", written.Environments)
+	}
+
+	// The re-written spec loads with --env ci as the original did.
+	if err := os.WriteFile(spec, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	shows, err = selectShows(globalTestContext(t, spec, "--env", "ci"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = shows[0]
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Atom.Config.Aws.Buckets.Output != "pod" || s.Atom.Config.BaseURL != "https://ci.example.com" {
+		t.Errorf("expected output bucket pod and baseURL of ci after re-writing, got %+v in:\n%s", s.Atom.Config, b)
+	}
+	if len(s.Atom.Episodes) != 1 || s.Atom.Episodes[0].Output != "qzj001.mp3" {
+		t.Errorf("expected the changed episode after re-writing, got %+v", s.Atom.Episodes)
+	}
+}
+
+// globalTestContext returns the context of a command with --spec spec
+// under the global flags args.
+func globalTestContext(t *testing.T, spec string, args ...string) *cli.Context {
+	t.Helper()
+	global := flag.NewFlagSet("mkpod", flag.ContinueOnError)
+	for _, f := range append([]cli.Flag{environmentFlag()}, configFlags()...) {
+		if err := f.Apply(global); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := global.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	app := cli.NewApp()
+	parent := cli.NewContext(app, global, nil)
+	set := flag.NewFlagSet("config", flag.ContinueOnError)
+	set.String("spec", spec, "")
+	if err := set.Parse([]string{"--spec", spec}); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(app, set, parent)
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Episodes can live in files of their own instead of (or as well as) the
+// episodes list of the spec. Every .yaml, .yml and .md file in
+// episodesDir and every file matching the include globs (both relative
+// to the directory of the spec) holds one episode. A .yaml file is the
+// episode mapping, a .md file is the episode as yaml front matter between
+// --- lines followed by the description in Markdown. Included episodes
+// are merged into the atom ordered by uid (highest first) and fields
+// re-written by mkpod are patched into the file the episode came from.
+// mkpod migrate split moves the episodes of a spec into episodesDir.
+
+const (
+	defaultEpisodesDir    string = "episodes"
+	frontMatterDelimiter  string = "---"
+	episodeFormatMarkdown string = "md"
+	episodeFormatYAML     string = "yaml"
+)
+
+// EpisodeSource is where an included episode was loaded from.
+type EpisodeSource struct {
+	File string
+	// The description is the body of a Markdown file (not in the front
+	// matter).
+	BodyDescription bool
+}
+
+// specRelative returns name relative to the directory of the spec unless
+// it is absolute.
+func (s *Show) specRelative(name string) string {
+	name = resolvetilde(name)
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(s.SpecFile), name)
+}
+
+// episodeFiles returns the files in episodesDir and matching the include
+// globs of a, sorted and without duplicates.
+func (s *Show) episodeFiles(a *Atom) ([]string, error) {
+	var patterns []string
+	if strings.TrimSpace(a.EpisodesDir) != "" {
+		for _, ext := range []string{"*.yaml", "*.yml", "*.md"} {
+			patterns = append(patterns, filepath.Join(s.specRelative(a.EpisodesDir), ext))
+		}
+	}
+	for _, include := range a.Include {
+		patterns = append(patterns, s.specRelative(include))
+	}
+	seen := make(map[string]bool)
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %s in %s: %w", pattern, s.SpecFile, err)
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err != nil || fi.IsDir() || seen[m] {
+				continue
+			}
+			seen[m] = true
+			files = append(files, m)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// isMarkdown returns true if file is a Markdown episode file.
+func isMarkdown(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// splitFrontMatter returns the yaml front matter and the body of a
+// Markdown file.
+func splitFrontMatter(b []byte) (front []byte, body []byte, err error) {
+	text := strings.ReplaceAll(string(b), "\r\n", "\n")
+	if !strings.HasPrefix(text, frontMatterDelimiter+"\n") {
+		return nil, nil, fmt.Errorf("no front matter (the file has to start with a %s line)", frontMatterDelimiter)
+	}
+	rest := text[len(frontMatterDelimiter)+1:]
+	end := -1
+	if strings.HasPrefix(rest, frontMatterDelimiter+"\n") || rest == frontMatterDelimiter {
+		end = 0
+	} else if i := strings.Index(rest, "\n"+frontMatterDelimiter+"\n"); i >= 0 {
+		end = i + 1
+	} else if strings.HasSuffix(rest, "\n"+frontMatterDelimiter) {
+		end = len(rest) - len(frontMatterDelimiter)
+	}
+	if end < 0 {
+		return nil, nil, fmt.Errorf("front matter is not terminated by a %s line", frontMatterDelimiter)
+	}
+	front = []byte(rest[:end])
+	body = []byte(strings.TrimPrefix(rest[end+len(frontMatterDelimiter):], "\n"))
+	return front, body, nil
+}
+
+// readEpisodeFile returns the episode in file.
+func readEpisodeFile(file string) (Episode, error) {
+	var e Episode
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return e, err
+	}
+	source := &EpisodeSource{File: file}
+	if isMarkdown(file) {
+		front, body, err := splitFrontMatter(b)
+		if err != nil {
+			return e, fmt.Errorf("%s: %w", file, err)
+		}
+		if err := yaml.Unmarshal(front, &e); err != nil {
+			return e, fmt.Errorf("%s: %w", file, err)
+		}
+		// The front matter starts after the first delimiter.
+		if err := checkYAMLKeys(file, front, Episode{}, 1); err != nil {
+			return e, err
+		}
+		if e.Description == "" {
+			e.Description = strings.TrimSpace(string(body))
+			source.BodyDescription = true
+		}
+	} else if err := yaml.Unmarshal(b, &e); err != nil {
+		return e, fmt.Errorf("%s: %w", file, err)
+	} else if err := checkYAMLKeys(file, b, Episode{}, 0); err != nil {
+		return e, err
+	}
+	e.source = source
+	return e, nil
+}
+
+// loadEpisodeFiles merges the episodes in episodesDir and the include
+// globs into the atom.
+func (s *Show) loadEpisodeFiles() error {
+	files, err := s.episodeFiles(&s.Atom)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	sources := make(map[int64]string)
+	for _, e := range s.Atom.Episodes {
+		sources[e.UID] = s.SpecFile
+	}
+	for _, file := range files {
+		e, err := readEpisodeFile(file)
+		if err != nil {
+			return err
+		}
+		if previous, found := sources[e.UID]; found {
+			return fmt.Errorf("episode uid %d in %s is already in %s", e.UID, file, previous)
+		}
+		sources[e.UID] = file
+		s.Atom.Episodes = append(s.Atom.Episodes, e)
+	}
+	sort.SliceStable(s.Atom.Episodes, func(i, j int) bool {
+		return s.Atom.Episodes[i].UID > s.Atom.Episodes[j].UID
+	})
+	return nil
+}
+
+// specAtom returns the atom as written to the spec, without the included
+// episodes.
+func (s *Show) specAtom() Atom {
+	a := s.Atom
+	a.Episodes = nil
+	for _, e := range s.Atom.Episodes {
+		if e.source == nil {
+			a.Episodes = append(a.Episodes, e)
+		}
+	}
+	return a
+}
+
+// episodeFileBytes returns the content of the file episode was included
+// from with the changed fields patched in.
+func episodeFileBytes(episode *Episode) ([]byte, error) {
+	src := episode.source
+	original, err := os.ReadFile(src.File)
+	if err != nil {
+		return nil, err
+	}
+	if !isMarkdown(src.File) {
+		var loaded Episode
+		if err := yaml.Unmarshal(original, &loaded); err != nil {
+			return nil, err
+		}
+		return patchYAML(original, &loaded, episode)
+	}
+	front, body, err := splitFrontMatter(original)
+	if err != nil {
+		return nil, err
+	}
+	var loaded Episode
+	if err := yaml.Unmarshal(front, &loaded); err != nil {
+		return nil, err
+	}
+	updated := *episode
+	if src.BodyDescription {
+		updated.Description = ""
+		if strings.TrimSpace(string(body)) != strings.TrimSpace(episode.Description) {
+			body = []byte(episode.Description + "\n")
+		}
+	}
+	if len(bytes.TrimSpace(front)) > 0 {
+		if front, err = patchYAML(front, &loaded, &updated); err != nil {
+			return nil, err
+		}
+	} else if front, err = frontMatter(&updated); err != nil {
+		return nil, err
+	}
+	return joinFrontMatter(front, body), nil
+}
+
+func joinFrontMatter(front []byte, body []byte) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(frontMatterDelimiter + "\n")
+	b.Write(front)
+	b.WriteString(frontMatterDelimiter + "\n")
+	b.Write(body)
+	return b.Bytes()
+}
+
+// episodeYAML returns episode as yaml (without the description if
+// withoutDescription is true).
+func episodeYAML(episode *Episode, withoutDescription bool) ([]byte, error) {
+	var n yaml.Node
+	if err := n.Encode(episode); err != nil {
+		return nil, err
+	}
+	if withoutDescription {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == "description" {
+				n.Content = append(n.Content[:i], n.Content[i+2:]...)
+				break
+			}
+		}
+	}
+	b := &bytes.Buffer{}
+	enc := yaml.NewEncoder(b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&n); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func frontMatter(episode *Episode) ([]byte, error) {
+	return episodeYAML(episode, true)
+}
+
+// newEpisodeFile returns the content of a new episode file in format.
+func newEpisodeFile(episode *Episode, format string) ([]byte, error) {
+	if format != episodeFormatMarkdown {
+		return episodeYAML(episode, false)
+	}
+	front, err := frontMatter(episode)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if episode.Description != "" {
+		body = []byte(episode.Description + "\n")
+	}
+	return joinFrontMatter(front, body), nil
+}
+
+// episodeFileName returns the base name of the file of episode, named
+// after the output file as the episode page of the site.
+func (a *Atom) episodeFileName(episode *Episode, format string) string {
+	return strings.TrimSuffix(a.EpisodePage(episode), ".html") + "." + format
+}
+
+// writeIncludedEpisodes writes the included episodes that have changed
+// back to their files.
+func (s *Show) writeIncludedEpisodes() error {
+	for i := range s.Atom.Episodes {
+		episode := &s.Atom.Episodes[i]
+		if episode.source == nil {
+			continue
+		}
+		b, err := episodeFileBytes(episode)
+		if err != nil {
+			return fmt.Errorf("unable to patch %s: %w", episode.source.File, err)
+		}
+		original, err := os.ReadFile(episode.source.File)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(b, original) {
+			continue
+		}
+		if err := s.writeBackedUp(episode.source.File, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// specModified returns the latest modification time of the spec and the
+// episode files of the atom.
+func (s *Show) specModified() (time.Time, error) {
+	fi, err := os.Stat(s.SpecFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := fi.ModTime()
+	files, err := s.episodeFiles(&s.Atom)
+	if err != nil {
+		return latest, nil
+	}
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (s *Show) migrateSplit(c *cli.Context) error {
+	s.opts.Force = c.Bool("force")
+	format := strings.TrimPrefix(strings.ToLower(c.String("format")), ".")
+	switch format {
+	case "markdown":
+		format = episodeFormatMarkdown
+	case "yml":
+		format = episodeFormatYAML
+	case episodeFormatMarkdown, episodeFormatYAML:
+	default:
+		return fmt.Errorf("unknown episode file format %q, expected %s or %s", format, episodeFormatMarkdown, episodeFormatYAML)
+	}
+
+	unlock, err := s.lockSpec()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+	dir := c.String("dir")
+	if d := strings.TrimSpace(s.Atom.EpisodesDir); d != "" && !c.IsSet("dir") {
+		dir = d
+	}
+	split := s.specAtom().Episodes
+	if len(split) == 0 {
+		log.Printf("There are no episodes in %s to split", s.SpecFile)
+		return nil
+	}
+	if !s.opts.doAction("Move %d episodes from %s into a file each in %s?", len(split), s.SpecFile, s.specRelative(dir)) {
+		return nil
+	}
+	if err := os.MkdirAll(s.specRelative(dir), 0755); err != nil {
+		return err
+	}
+	for _, e := range split {
+		file := filepath.Join(s.specRelative(dir), s.Atom.episodeFileName(&e, format))
+		if _, err := os.Stat(file); err == nil {
+			if !s.opts.doAction("Overwrite %s?", file) {
+				return fmt.Errorf("%s exists, not splitting %s", file, s.SpecFile)
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		b, err := newEpisodeFile(&e, format)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(file, b, 0644); err != nil {
+			return err
+		}
+		log.Printf("Wrote episode %d to %s", e.UID, file)
+	}
+	s.Atom.EpisodesDir = dir
+	for i := range s.Atom.Episodes {
+		if s.Atom.Episodes[i].source == nil {
+			s.Atom.Episodes[i].source = &EpisodeSource{File: filepath.Join(s.specRelative(dir), s.Atom.episodeFileName(&s.Atom.Episodes[i], format)), BodyDescription: format == episodeFormatMarkdown}
+		}
+	}
+	b, err := s.specBytes()
+	if err != nil {
+		return err
+	}
+	if err := s.writeSpec(b); err != nil {
+		return err
+	}
+	log.Printf("Set episodesDir to %s in %s", dir, s.SpecFile)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestLoadEpisodeFiles(t *testing.T) {
+	s := NewShow("", "")
+	dir := t.TempDir()
+	s.SpecFile = filepath.Join(dir, "podspec.yaml")
+	files := map[string]string{
+		s.SpecFile:                                "atom: podcast.rss\nepisodesDir: episodes\ninclude:\n- extra/*.yaml\nepisodes:\n- uid: 1\n  title: First\n",
+		filepath.Join(dir, "episodes", "two.md"):  "---\nuid: 2\ntitle: Second # keep me\nlength: 0\n---\nShow *notes*.\n",
+		filepath.Join(dir, "extra", "three.yaml"): "# Third\nuid: 3\ntitle: Third\nduration: \"00:00:00\"\n",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Atom = Atom{}
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Atom.Episodes) != 3 || s.Atom.Episodes[0].UID != 3 || s.Atom.Episodes[1].UID != 2 || s.Atom.Episodes[2].UID != 1 {
+		t.Fatalf("expected episodes 3, 2 and 1, got %+v", s.Atom.Episodes)
+	}
+	if s.Atom.Episodes[1].Description != "Show *notes*." {
+		t.Errorf("expected description from the Markdown body, got %q", s.Atom.Episodes[1].Description)
+	}
+
+	s.Atom.Episodes[0].Duration.Duration = 90e9
+	s.Atom.Episodes[1].Length = 1234
+	s.Atom.Episodes[1].Description = "New notes."
+	s.Atom.Episodes[2].Output = "one.mp3"
+	b, err := s.specBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.writeSpec(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.writeIncludedEpisodes(); err != nil {
+		t.Fatal(err)
+	}
+	for file, expected := range map[string]string{
+		s.SpecFile:                                "atom: podcast.rss\nepisodesDir: episodes\ninclude:\n- extra/*.yaml\nepisodes:\n- uid: 1\n  title: First\n  output: one.mp3\n",
+		filepath.Join(dir, "episodes", "two.md"):  "---\nuid: 2\ntitle: Second # keep me\nlength: 1234\n---\nNew notes.\n",
+		filepath.Join(dir, "extra", "three.yaml"): "# Third\nuid: 3\ntitle: Third\nduration: \"00:01:30\"\n",
+	} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("expected %s to be:\n%s\ngot:\n%s", file, expected, b)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "extra", "dup.yaml"), []byte("uid: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.Atom = Atom{}
+	if err := s.loadConfig(); err == nil || !strings.Contains(err.Error(), "uid 2") {
+		t.Errorf("expected duplicate uid error, got %v", err)
+	}
+}
+
+func TestMigrateSplit(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "podspec.yaml")
+	content := "# My podcast\natom: podcast.rss\nepisodes:\n- uid: 2\n  title: Second\n  description: |-\n    Two\n  output: two.mp3\n- uid: 1\n  title: First\n  description: One\n"
+	if err := os.WriteFile(spec, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set := flag.NewFlagSet("split", flag.ContinueOnError)
+	set.String("spec", spec, "")
+	set.String("dir", defaultEpisodesDir, "")
+	set.String("format", episodeFormatMarkdown, "")
+	set.Bool("force", true, "")
+	s := NewShow("", spec)
+	if err := s.migrateSplit(cli.NewContext(cli.NewApp(), set, nil)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "episodes", "two.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "---\nuid: 2\ntitle: Second\n") || !strings.HasSuffix(string(b), "---\nTwo\n") || strings.Contains(string(b), "description") {
+		t.Errorf("unexpected episode file:\n%s", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "episodes", "episode-1.md")); err != nil {
+		t.Error(err)
+	}
+	b, err = os.ReadFile(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "# My podcast\n") || !strings.Contains(string(b), "episodesDir: episodes\n") || strings.Contains(string(b), "uid:") {
+		t.Errorf("unexpected spec after split:\n%s", b)
+	}
+
+	s = NewShow("", spec)
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Atom.Episodes) != 2 || s.Atom.Episodes[0].Description != "Two" || s.Atom.Episodes[1].Description != "One" {
+		t.Errorf("unexpected episodes after split %+v", s.Atom.Episodes)
+	}
+}
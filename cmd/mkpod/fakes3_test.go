@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal path-style S3 endpoint keeping objects in memory:
+// PutObject (and CopyObject), HeadObject, GetObject, DeleteObject,
+// ListObjectsV2 and an empty ListMultipartUploads.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+}
+
+type fakeObject struct {
+	Body   []byte
+	Header http.Header
+}
+
+// newFakeS3 starts a fake S3 endpoint for the test with credentials in
+// the environment (and no shared config) for the AWS SDK.
+func newFakeS3(t *testing.T) (*fakeS3, string) {
+	t.Helper()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	fake := &fakeS3{objects: make(map[string]*fakeObject)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+// Put stores body as bucket/key.
+func (f *fakeS3) Put(bucket string, key string, body []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects["/"+bucket+"/"+key] = &fakeObject{Body: body, Header: make(http.Header)}
+}
+
+// Object returns bucket/key, nil if it does not exist.
+func (f *fakeS3) Object(bucket string, key string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects["/"+bucket+"/"+key]
+}
+
+func (o *fakeObject) etag() string {
+	sum := md5.Sum(o.Body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+type fakeListResult struct {
+	XMLName     xml.Name          `xml:"ListBucketResult"`
+	Name        string            `xml:"Name"`
+	KeyCount    int               `xml:"KeyCount"`
+	IsTruncated bool              `xml:"IsTruncated"`
+	Contents    []fakeListContent `xml:"Contents"`
+}
+
+type fakeListContent struct {
+	Key          string `xml:"Key"`
+	Size         int    `xml:"Size"`
+	ETag         string `xml:"ETag"`
+	StorageClass string `xml:"StorageClass"`
+	LastModified string `xml:"LastModified"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" {
+		f.serveBucket(w, r, bucket)
+		return
+	}
+	o := f.objects[r.URL.Path]
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			source, _ = url.PathUnescape(source)
+			src := f.objects["/"+strings.TrimPrefix(source, "/")]
+			if src == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			body = src.Body
+		}
+		o = &fakeObject{Body: body, Header: r.Header.Clone()}
+		f.objects[r.URL.Path] = o
+		w.Header().Set("ETag", o.etag())
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			io.WriteString(w, `<CopyObjectResult><ETag>`+o.etag()+`</ETag></CopyObjectResult>`)
+		}
+	case http.MethodHead, http.MethodGet:
+		if o == nil {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			}
+			return
+		}
+		for k, v := range o.Header {
+			if strings.HasPrefix(k, "X-Amz-Meta-") || k == "Content-Type" || k == "Cache-Control" {
+				w.Header()[k] = v
+			}
+		}
+		w.Header().Set("ETag", o.etag())
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.Body)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(o.Body)
+		}
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	w.Header().Set("Content-Type", "application/xml")
+	if _, found := r.URL.Query()["uploads"]; found {
+		io.WriteString(w, `<ListMultipartUploadsResult><Bucket>`+bucket+`</Bucket><IsTruncated>false</IsTruncated></ListMultipartUploadsResult>`)
+		return
+	}
+	result := fakeListResult{Name: bucket}
+	prefix := "/" + bucket + "/"
+	for p, o := range f.objects {
+		if key, found := strings.CutPrefix(p, prefix); found {
+			result.Contents = append(result.Contents, fakeListContent{
+				Key:          key,
+				Size:         len(o.Body),
+				ETag:         o.etag(),
+				StorageClass: "STANDARD",
+				LastModified: time.Now().UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+	b, _ := xml.Marshal(result)
+	w.Write(b)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Alternate feeds. Besides the rss feed rendered from template.rss, the
+// parse command can render a JSON Feed 1.1 and an Atom 1.0 feed from the
+// same atom. They are enabled by giving them a filename under
+// config.feeds, are uploaded together with the rss feed (before it, so
+// that the rss never advertises a feed that is not there) and are
+// advertised in the rss feed as <atom:link rel="alternate">.
+
+const (
+	jsonFeedVersion     string = "https://jsonfeed.org/version/1.1"
+	jsonFeedContentType string = "application/feed+json"
+	atomFeedContentType string = "application/atom+xml"
+	atomNamespace       string = "http://www.w3.org/2005/Atom"
+)
+
+type FeedsConfig struct {
+	// Filename of the JSON Feed, e.g podcast.json.
+	JSON string `yaml:"json,omitempty"`
+	// Filename of the Atom feed, e.g podcast.atom.
+	Atom string `yaml:"atom,omitempty"`
+}
+
+// AlternateFeed is a feed rendered in addition to the rss feed.
+type AlternateFeed struct {
+	// Filename (and key) of the feed.
+	Name        string
+	ContentType string
+	render      func(s *Show, target FeedTarget) ([]byte, error)
+}
+
+// AlternateFeeds returns the alternate feeds enabled in config.feeds.
+func (a *Atom) AlternateFeeds() []AlternateFeed {
+	var feeds []AlternateFeed
+	if strings.TrimSpace(a.Config.Feeds.JSON) != "" {
+		feeds = append(feeds, AlternateFeed{
+			Name:        a.Config.Feeds.JSON,
+			ContentType: jsonFeedContentType,
+			render:      (*Show).renderJSONFeed,
+		})
+	}
+	if strings.TrimSpace(a.Config.Feeds.Atom) != "" {
+		feeds = append(feeds, AlternateFeed{
+			Name:        a.Config.Feeds.Atom,
+			ContentType: atomFeedContentType,
+			render:      (*Show).renderAtomFeed,
+		})
+	}
+	return feeds
+}
+
+// validateFeeds returns error if an alternate feed would overwrite the
+// rss feed or another alternate feed.
+func (s *Show) validateFeeds() error {
+	names := map[string]bool{path.Clean(s.Atom.Atom): true}
+	for _, feed := range s.Atom.AlternateFeeds() {
+		name := path.Clean(feed.Name)
+		if names[name] {
+			return fmt.Errorf("config.feeds in %s has %s more than once or as the atom property", s.SpecFile, feed.Name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// localAlternateFeedFile returns the name of the locally rendered
+// alternate feed of target, e.g podcast.staging.json for staging.
+func (s *Show) localAlternateFeedFile(target FeedTarget, feed AlternateFeed) string {
+	if target.Name == "staging" {
+		return ReplaceExtension(feed.Name, ".staging"+path.Ext(feed.Name))
+	}
+	return feed.Name
+}
+
+// writeAlternateFeeds renders every alternate feed of target into a
+// local file.
+func (s *Show) writeAlternateFeeds(target FeedTarget) error {
+	for _, feed := range s.Atom.AlternateFeeds() {
+		b, err := feed.render(s, target)
+		if err != nil {
+			return fmt.Errorf("unable to render %s: %w", feed.Name, err)
+		}
+		file := s.localAlternateFeedFile(target, feed)
+		if err := os.WriteFile(file, b, 0644); err != nil {
+			return err
+		}
+		log.Printf("Successfully generated %s", file)
+	}
+	return nil
+}
+
+// uploadAlternateFeeds uploads the rendered alternate feeds of target
+// (skipped by Upload if unchanged).
+func (s *Show) uploadAlternateFeeds(target FeedTarget) error {
+	for _, feed := range s.Atom.AlternateFeeds() {
+		file := s.localAlternateFeedFile(target, feed)
+		if err := s.Aws.Upload(ObjectKindFeed, target.Bucket, target.Key(feed.Name), feed.ContentType, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// feedEpisodes returns the episodes in the feed of target at now in spec
+// order.
+func (s *Show) feedEpisodes(target FeedTarget, now time.Time) []*Episode {
+	var episodes []*Episode
+	for i := range s.Atom.Episodes {
+		if target.Includes(&s.Atom.Episodes[i], now) {
+			episodes = append(episodes, &s.Atom.Episodes[i])
+		}
+	}
+	return episodes
+}
+
+type JSONFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Icon        string           `json:"icon,omitempty"`
+	Authors     []JSONFeedAuthor `json:"authors,omitempty"`
+	Language    string           `json:"language,omitempty"`
+	Hubs        []JSONFeedHub    `json:"hubs,omitempty"`
+	Items       []JSONFeedItem   `json:"items"`
+}
+
+type JSONFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type JSONFeedHub struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type JSONFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	Title         string               `json:"title"`
+	ContentHTML   string               `json:"content_html"`
+	Summary       string               `json:"summary,omitempty"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published"`
+	Authors       []JSONFeedAuthor     `json:"authors,omitempty"`
+	Attachments   []JSONFeedAttachment `json:"attachments"`
+}
+
+type JSONFeedAttachment struct {
+	URL               string  `json:"url"`
+	MimeType          string  `json:"mime_type"`
+	SizeInBytes       int64   `json:"size_in_bytes,omitempty"`
+	DurationInSeconds float64 `json:"duration_in_seconds,omitempty"`
+}
+
+// renderJSONFeed returns the atom as the JSON Feed 1.1 of target.
+func (s *Show) renderJSONFeed(target FeedTarget) ([]byte, error) {
+	feed := JSONFeed{
+		Version:     jsonFeedVersion,
+		Title:       s.Atom.Title,
+		HomePageURL: s.Atom.ChannelLink(),
+		FeedURL:     target.URL(target.Key(s.Atom.Config.Feeds.JSON)),
+		Description: s.Atom.Description,
+		Icon:        s.Atom.Config.Image,
+		Language:    s.Atom.Language,
+		Items:       []JSONFeedItem{},
+	}
+	if strings.TrimSpace(s.Atom.Author) != "" {
+		feed.Authors = []JSONFeedAuthor{{Name: s.Atom.Author}}
+	}
+	for _, hub := range s.Atom.Config.Notify.WebSub.Hubs {
+		feed.Hubs = append(feed.Hubs, JSONFeedHub{Type: "WebSub", URL: hub})
+	}
+	for _, e := range s.feedEpisodes(target, time.Now()) {
+		episodeTarget := s.Atom.EpisodeTarget(e)
+		item := JSONFeedItem{
+			ID:            s.Atom.EpisodeGUID(e),
+			URL:           s.Atom.EpisodeLink(e),
+			Title:         e.Title,
+			ContentHTML:   MarkdownToHTML(e.Description) + SpotifyChaptersHTML(e.Chapters),
+			Summary:       e.Subtitle,
+			DatePublished: e.PubDate.Format(time.RFC3339),
+			Attachments: []JSONFeedAttachment{{
+				URL:               episodeTarget.URL(episodeTarget.Key(e.Output)),
+				MimeType:          e.Type,
+				SizeInBytes:       e.Length,
+				DurationInSeconds: e.Duration.Seconds(),
+			}},
+		}
+		if strings.TrimSpace(e.Image) != "" {
+			item.Image = episodeTarget.URL(episodeTarget.Key(e.Image))
+		}
+		if strings.TrimSpace(e.Author) != "" {
+			item.Authors = []JSONFeedAuthor{{Name: e.Author}}
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	b, err := json.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+type AtomFeed struct {
+	XMLName  xml.Name       `xml:"feed"`
+	Xmlns    string         `xml:"xmlns,attr"`
+	Lang     string         `xml:"xml:lang,attr,omitempty"`
+	ID       string         `xml:"id"`
+	Title    string         `xml:"title"`
+	Subtitle string         `xml:"subtitle,omitempty"`
+	Updated  string         `xml:"updated"`
+	Links    []AtomLink     `xml:"link"`
+	Author   *AtomPerson    `xml:"author,omitempty"`
+	Rights   string         `xml:"rights,omitempty"`
+	Logo     string         `xml:"logo,omitempty"`
+	Category []AtomCategory `xml:"category"`
+	Entries  []AtomEntry    `xml:"entry"`
+}
+
+type AtomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type AtomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type AtomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type AtomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Links     []AtomLink  `xml:"link"`
+	Author    *AtomPerson `xml:"author,omitempty"`
+	Summary   string      `xml:"summary,omitempty"`
+	Content   AtomText    `xml:"content"`
+}
+
+// renderAtomFeed returns the atom as the Atom 1.0 feed of target.
+func (s *Show) renderAtomFeed(target FeedTarget) ([]byte, error) {
+	feedURL := target.URL(target.Key(s.Atom.Config.Feeds.Atom))
+	feed := AtomFeed{
+		Xmlns:    atomNamespace,
+		Lang:     s.Atom.Language,
+		ID:       feedURL,
+		Title:    s.Atom.Title,
+		Subtitle: s.Atom.Subtitle,
+		Rights:   s.Atom.Copyright,
+		Logo:     s.Atom.Config.Image,
+		Links: []AtomLink{
+			{Href: feedURL, Rel: "self", Type: atomFeedContentType},
+			{Href: target.URL(target.Key(s.Atom.Atom)), Rel: "alternate", Type: "application/rss+xml"},
+		},
+	}
+	if strings.TrimSpace(s.Atom.Config.Feeds.JSON) != "" {
+		feed.Links = append(feed.Links, AtomLink{Href: target.URL(target.Key(s.Atom.Config.Feeds.JSON)), Rel: "alternate", Type: jsonFeedContentType})
+	}
+	if link := s.Atom.ChannelLink(); strings.TrimSpace(link) != "" {
+		feed.Links = append(feed.Links, AtomLink{Href: link, Rel: "alternate", Type: "text/html"})
+	}
+	for _, hub := range s.Atom.Config.Notify.WebSub.Hubs {
+		feed.Links = append(feed.Links, AtomLink{Href: hub, Rel: "hub"})
+	}
+	if strings.TrimSpace(s.Atom.Author) != "" {
+		feed.Author = &AtomPerson{Name: s.Atom.Author, Email: s.Atom.OwnerEmail}
+	}
+	for _, c := range s.Atom.Categories {
+		feed.Category = append(feed.Category, AtomCategory{Term: c.Name})
+	}
+	// updated is the latest of lastBuildDate and the pubDate of the
+	// episodes in the feed.
+	updated := s.Atom.LastBuildDate.Time
+	for _, e := range s.feedEpisodes(target, time.Now()) {
+		episodeTarget := s.Atom.EpisodeTarget(e)
+		published := e.PubDate.Format(time.RFC3339)
+		entry := AtomEntry{
+			ID:        s.Atom.EpisodeGUID(e),
+			Title:     e.Title,
+			Updated:   published,
+			Published: published,
+			Summary:   e.Subtitle,
+			Content: AtomText{
+				Type: "html",
+				Body: MarkdownToHTML(e.Description) + SpotifyChaptersHTML(e.Chapters),
+			},
+		}
+		if link := s.Atom.EpisodeLink(e); strings.TrimSpace(link) != "" {
+			entry.Links = append(entry.Links, AtomLink{Href: link, Rel: "alternate", Type: "text/html"})
+		}
+		entry.Links = append(entry.Links, AtomLink{
+			Href:   episodeTarget.URL(episodeTarget.Key(e.Output)),
+			Rel:    "enclosure",
+			Type:   e.Type,
+			Length: e.Length,
+		})
+		if strings.TrimSpace(e.Author) != "" {
+			entry.Author = &AtomPerson{Name: e.Author}
+		}
+		if e.PubDate.After(updated) {
+			updated = e.PubDate.Time
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func alternateFeedsAtom() Atom {
+	a := Atom{
+		Atom:     "podcast.rss",
+		Title:    "QZJ",
+		Author:   "SA6MWA",
+		Language: "sv",
+	}
+	a.Config.BaseURL = "https://mypodbucket.s3.eu-north-1.amazonaws.com"
+	a.Config.Feeds = FeedsConfig{JSON: "podcast.json", Atom: "podcast.atom"}
+	a.Episodes = []Episode{
+		{
+			UID:     2,
+			Title:   "Future",
+			PubDate: ItunesTime{time.Now().Add(24 * time.Hour)},
+			Output:  "qzj002.mp3",
+		},
+		{
+			UID:         1,
+			Title:       "Fish & Chips",
+			PubDate:     ItunesTime{time.Date(2024, 10, 22, 21, 23, 47, 0, time.UTC)},
+			Link:        "https://qzj.se/audio/qzj001",
+			Output:      "qzj001.mp3",
+			Type:        "audio/mpeg",
+			Length:      43395072,
+			Duration:    ItunesDuration{2712 * time.Second},
+			Description: "Show *notes*",
+		},
+	}
+	return a
+}
+
+func TestRenderJSONFeed(t *testing.T) {
+	s := NewShow("", "")
+	s.Atom = alternateFeedsAtom()
+
+	b, err := s.renderJSONFeed(s.Atom.ProductionTarget())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed JSONFeed
+	if err := json.Unmarshal(b, &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Version != jsonFeedVersion || feed.FeedURL != "https://mypodbucket.s3.eu-north-1.amazonaws.com/podcast.json" {
+		t.Errorf("unexpected version %s or feed_url %s", feed.Version, feed.FeedURL)
+	}
+	if len(feed.Items) != 1 {
+		t.Fatalf("expected 1 published item, got %d", len(feed.Items))
+	}
+	item := feed.Items[0]
+	if item.ID != "https://mypodbucket.s3.eu-north-1.amazonaws.com/qzj001.mp3" || item.URL != "https://qzj.se/audio/qzj001" {
+		t.Errorf("unexpected id %s or url %s", item.ID, item.URL)
+	}
+	if item.DatePublished != "2024-10-22T21:23:47Z" || !strings.Contains(item.ContentHTML, "<em>notes</em>") {
+		t.Errorf("unexpected date_published %s or content_html %s", item.DatePublished, item.ContentHTML)
+	}
+	expected := JSONFeedAttachment{
+		URL:               "https://mypodbucket.s3.eu-north-1.amazonaws.com/qzj001.mp3",
+		MimeType:          "audio/mpeg",
+		SizeInBytes:       43395072,
+		DurationInSeconds: 2712,
+	}
+	if len(item.Attachments) != 1 || item.Attachments[0] != expected {
+		t.Errorf("expected attachment %+v, got %+v", expected, item.Attachments)
+	}
+}
+
+func TestRenderAtomFeed(t *testing.T) {
+	s := NewShow("", "")
+	s.Atom = alternateFeedsAtom()
+
+	b, err := s.renderAtomFeed(s.Atom.ProductionTarget())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed AtomFeed
+	if err := xml.Unmarshal(b, &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.ID != "https://mypodbucket.s3.eu-north-1.amazonaws.com/podcast.atom" || feed.Updated != "2024-10-22T21:23:47Z" {
+		t.Errorf("unexpected id %s or updated %s", feed.ID, feed.Updated)
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].Title != "Fish & Chips" {
+		t.Fatalf("expected the published entry only, got %+v", feed.Entries)
+	}
+	var enclosure AtomLink
+	for _, l := range feed.Entries[0].Links {
+		if l.Rel == "enclosure" {
+			enclosure = l
+		}
+	}
+	if enclosure.Href != "https://mypodbucket.s3.eu-north-1.amazonaws.com/qzj001.mp3" || enclosure.Length != 43395072 {
+		t.Errorf("unexpected enclosure %+v", enclosure)
+	}
+	if !strings.Contains(string(b), `<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="sv">`) {
+		t.Errorf("expected atom namespace and language in %s", b)
+	}
+}
+
+func TestRenderFeedAlternateLinks(t *testing.T) {
+	s := NewShow("", "")
+	s.Atom = alternateFeedsAtom()
+
+	b, err := s.renderFeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<atom:link href="https://mypodbucket.s3.eu-north-1.amazonaws.com/podcast.json" rel="alternate" type="application/feed+json"/>`,
+		`<atom:link href="https://mypodbucket.s3.eu-north-1.amazonaws.com/podcast.atom" rel="alternate" type="application/atom+xml"/>`,
+	} {
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("expected rss feed to contain %s", expected)
+		}
+	}
+
+	s.Atom.Config.Feeds.JSON = "podcast.rss"
+	if err := s.validateFeeds(); err == nil {
+		t.Error("expected error when the json feed overwrites the rss feed")
+	}
+}
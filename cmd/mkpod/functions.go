@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/AlecAivazis/survey/v2"
@@ -25,7 +26,6 @@ import (
 	"github.com/sa6mwa/mp3duration"
 	"golang.org/x/term"
 	"gopkg.in/alessio/shellescape.v1"
-	"gopkg.in/yaml.v3"
 )
 
 // Generic functions used in more than one cli command of mkpod.go.
@@ -43,8 +43,8 @@ func resolvetilde(path string) string {
 	return path
 }
 
-func loadConfig() error {
-	sf, err := os.Open(specFile)
+func (s *Show) loadConfig() error {
+	sf, err := os.Open(s.SpecFile)
 	if err != nil {
 		return err
 	}
@@ -54,21 +54,184 @@ func loadConfig() error {
 		return err
 	}
 
-	err = yaml.Unmarshal(atomYaml, &atom)
+	s.Atom, err = s.decodeSpec(atomYaml)
 	if err != nil {
 		return err
 	}
+	if err := checkYAMLKeys(s.SpecFile, atomYaml, Atom{}, 0); err != nil {
+		return err
+	}
+	if err := s.checkLocalSpecKeys(); err != nil {
+		return err
+	}
+	if err := s.loadEpisodeFiles(); err != nil {
+		return err
+	}
 
-	// Set defaults
+	if err := s.validateFeeds(); err != nil {
+		return err
+	}
+	if err := s.validateHooks(); err != nil {
+		return err
+	}
+	return s.loadTemplates()
+}
 
-	if atom.Encoding.CRF == 0 {
-		atom.Encoding.CRF = 28
+// setDefaults sets the defaults of fields not set in the spec.
+func setDefaults(a *Atom) {
+	if a.Encoding.CRF == 0 {
+		a.Encoding.CRF = 28
 	}
-	if strings.TrimSpace(atom.Encoding.ABR) == "" {
-		atom.Encoding.ABR = "196k"
+	if strings.TrimSpace(a.Encoding.ABR) == "" {
+		a.Encoding.ABR = "196k"
 	}
+}
 
-	return nil
+// rewriteSpec re-writes the spec from the atom if any field in the atom
+// has changed (updateAtom is true) and the user agrees.
+func (s *Show) rewriteSpec() error {
+	if !s.updateAtom {
+		return nil
+	}
+	if !s.opts.doAction("Fields in the atom has changed, re-write %s?", s.SpecFile) {
+		return nil
+	}
+	return s.writeAtom()
+}
+
+// writeAtom writes the atom back into the spec (and the included
+// episode files) without asking.
+func (s *Show) writeAtom() error {
+	s.Atom.LastBuildDate.Time = time.Now().UTC()
+	b, err := s.specBytes()
+	if err != nil {
+		return err
+	}
+	if err := s.writeSpec(b); err != nil {
+		return err
+	}
+	return s.writeIncludedEpisodes()
+}
+
+// specBytes returns the spec with the changed fields of the atom patched
+// in, preserving comments and formatting. If the spec can not be patched
+// it is re-written as a whole with the episodes and lastBuildDate of the
+// atom, every other section is kept as it is in the spec (without the
+// layers of config.go). Included episodes are left out.
+func (s *Show) specBytes() ([]byte, error) {
+	a := s.specAtom()
+	original, err := os.ReadFile(s.SpecFile)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.patchSpec(original, &a)
+	if err == nil {
+		return b, nil
+	}
+	log.Printf("WARNING: Unable to patch %s, re-writing it as a whole: %v", s.SpecFile, err)
+	b, err = replaceYAML(original, &a, "lastBuildDate", "episodes")
+	if err != nil {
+		return nil, fmt.Errorf("unable to re-write %s: %w", s.SpecFile, err)
+	}
+	return b, nil
+}
+
+// feedFuncMap returns the functions available in the rss template
+// when rendering the feed of target.
+func (s *Show) feedFuncMap(target FeedTarget) template.FuncMap {
+	return template.FuncMap{
+		// URL of the feed itself.
+		"feedURL": func() string {
+			return target.URL(target.Key(s.Atom.Atom))
+		},
+		// URL of key (output or image) of an episode, staged episodes
+		// are served from staging.
+		"episodeURL": func(e Episode, key string) string {
+			return s.Atom.EpisodeURL(&e, key)
+		},
+		// Alternate feeds (see feeds.go) with URL and content type.
+		"alternateFeeds": func() []AtomLink {
+			var links []AtomLink
+			for _, feed := range s.Atom.AlternateFeeds() {
+				links = append(links, AtomLink{Href: target.URL(target.Key(feed.Name)), Rel: "alternate", Type: feed.ContentType})
+			}
+			return links
+		},
+		// Whether an episode is part of the feed.
+		"inFeed": func(e Episode) bool {
+			return target.Includes(&e, time.Now())
+		},
+		"episodeGUID": func(e Episode) string {
+			return s.Atom.EpisodeGUID(&e)
+		},
+		// Links fall back to the pages of the site (see site.go).
+		"channelLink": func() string {
+			return s.Atom.ChannelLink()
+		},
+		"episodeLink": func(e Episode) string {
+			return s.Atom.EpisodeLink(&e)
+		},
+		"escape": func(s string) string {
+			return shellescape.Quote(s)
+		},
+		"timeNow": func() time.Time {
+			return time.Now()
+		},
+		"isAfter": isAfter,
+		"markdown": func(s string) string {
+			return MarkdownToHTML(s)
+		},
+		"spotifyChapters": SpotifyChaptersHTML,
+	}
+}
+
+// SpotifyChaptersHTML returns the chapters as formatted by
+// SpotifyChapters in a pre element or empty string if there are no
+// chapters.
+func SpotifyChaptersHTML(chapters []id3v24.Chapter) string {
+	var output string
+	chaps := SpotifyChapters(chapters)
+	if len([]rune(chaps)) > 0 {
+		output = "\n<pre>\n"
+		output += chaps
+		output += "</pre>\n"
+	}
+	return output
+}
+
+// isAfter returns true if t1 is equal to or after t2, false if either
+// is zero.
+func isAfter(t1 time.Time, t2 time.Time) bool {
+	if t1.IsZero() || t2.IsZero() {
+		return false
+	}
+	return (t1 == t2 || t1.After(t2))
+}
+
+// feedTemplate parses the rss template for rendering the feed of
+// target.
+func (s *Show) feedTemplate(target FeedTarget) (*template.Template, error) {
+	return template.New("template.rss").Funcs(s.feedFuncMap(target)).Parse(s.rssTemplate)
+}
+
+// renderFeed returns the atom rendered through the rss template as the
+// production feed.
+func (s *Show) renderFeed() ([]byte, error) {
+	return s.renderFeedFor(s.Atom.ProductionTarget())
+}
+
+// renderFeedFor returns the atom rendered through the rss template as
+// the feed of target.
+func (s *Show) renderFeedFor(target FeedTarget) ([]byte, error) {
+	t, err := s.feedTemplate(target)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, Combined{Atom: &s.Atom}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // MarkdownToHTML takes md as markdown and returns html.
@@ -155,12 +318,30 @@ func ExtensionToBaseFormat(filename string, format string) string {
 	return ReplaceExtension(baseName, "."+strings.TrimSpace(strings.ToLower(format)))
 }
 
-func doAction(format string, a ...any) bool {
-	if dryRun {
+// RunOptions are the options of a command given for a run of a show,
+// kept per show as serve-schedule runs several shows concurrently.
+type RunOptions struct {
+	// Answer yes without asking (--force).
+	Force bool
+	// Answer no and change nothing (--dry-run).
+	DryRun bool
+	// Remove the master in the input bucket before encoding (-R).
+	RemoveRemoteMaster bool
+	// Upload encoded episodes to staging rather than the output bucket.
+	ToStaging bool
+}
+
+// doAction logs the question and returns false in dry-run mode, true
+// if forced, or asks for yes or no. A nil o asks.
+func (o *RunOptions) doAction(format string, a ...any) bool {
+	if o == nil {
+		o = &RunOptions{}
+	}
+	if o.DryRun {
 		log.Printf("%s No", fmt.Sprintf(format, a...))
 		return false
 	}
-	if askNoQuestions {
+	if o.Force {
 		log.Printf("%s Yes", fmt.Sprintf(format, a...))
 		return true
 	}
@@ -195,18 +376,18 @@ func yes(format string, a ...any) bool {
 	return false
 }
 
-func basicAtomValidation() error {
-	if len(atom.Atom) < 1 {
-		return fmt.Errorf("atom property in %s must not be empty", specFile)
+func (s *Show) basicAtomValidation() error {
+	if len(s.Atom.Atom) < 1 {
+		return fmt.Errorf("atom property in %s must not be empty", s.SpecFile)
 	}
-	if atom.TTL < 1 {
-		return fmt.Errorf("ttl must not be 0 in %s", specFile)
+	if s.Atom.TTL < 1 {
+		return fmt.Errorf("ttl must not be 0 in %s", s.SpecFile)
 	}
-	if len(atom.Description) < 1 || len(atom.Title) < 1 {
-		return fmt.Errorf("title and description must not be empty in %s", specFile)
+	if len(s.Atom.Description) < 1 || len(s.Atom.Title) < 1 {
+		return fmt.Errorf("title and description must not be empty in %s", s.SpecFile)
 	}
 	// Validate executables
-	executables := []string{atom.LamepathExpanded(), atom.FFmpegPathExpanded()}
+	executables := []string{s.Atom.LamepathExpanded(), s.Atom.FFmpegPathExpanded()}
 	for _, e := range executables {
 		fs, err := os.Stat(e)
 		if err != nil {
@@ -221,87 +402,89 @@ func basicAtomValidation() error {
 	}
 
 	// Ensure all episodes have at least a title, description, and pubDate.
-	for i, e := range atom.Episodes {
+	for i, e := range s.Atom.Episodes {
 		if e.UID < 1 {
-			return fmt.Errorf("uid must be above 0 in %s, in episode with output=%s", specFile, e.Output)
+			return fmt.Errorf("uid must be above 0 in %s, in episode with output=%s", s.SpecFile, e.Output)
 		}
 		if len(e.Title) < 1 || len(e.Description) < 1 {
-			return fmt.Errorf("title and description for episode with uid %d must not be empty in %s", e.UID, specFile)
+			return fmt.Errorf("title and description for episode with uid %d must not be empty in %s", e.UID, s.SpecFile)
 		}
 		if len(e.Author) < 1 {
-			if len(atom.Author) < 1 {
-				return fmt.Errorf("author must not be empty in atom, check %s", specFile)
+			if len(s.Atom.Author) < 1 {
+				return fmt.Errorf("author must not be empty in atom, check %s", s.SpecFile)
 			}
-			atom.Episodes[i].Author = atom.Author
-			updateAtom = true
+			s.Atom.Episodes[i].Author = s.Atom.Author
+			s.updateAtom = true
 		}
 		if e.PubDate.IsZero() {
-			log.Printf("UID %d (%s) pubDate is zero, setting to time.Now().UTC()", atom.Episodes[i].UID, atom.Episodes[i].Title)
-			atom.Episodes[i].PubDate.Time = time.Now().UTC()
-			updateAtom = true
+			log.Printf("UID %d (%s) pubDate is zero, setting to time.Now().UTC()", s.Atom.Episodes[i].UID, s.Atom.Episodes[i].Title)
+			s.Atom.Episodes[i].PubDate.Time = time.Now().UTC()
+			s.updateAtom = true
 		}
-		if len(e.Link) < 1 {
-			atom.Episodes[i].Link = atom.Link
-			updateAtom = true
+		// An empty link is derived from the site when configured.
+		if len(e.Link) < 1 && !s.Atom.Config.SiteEnabled() {
+			s.Atom.Episodes[i].Link = s.Atom.Link
+			s.updateAtom = true
 		}
 	}
 
 	return nil
 }
 
-func validateAtom() error {
-	err := basicAtomValidation()
+func (s *Show) validateAtom() error {
+	err := s.basicAtomValidation()
 	if err != nil {
 		return err
 	}
-	for i, e := range atom.Episodes {
+	for i, e := range s.Atom.Episodes {
 		if len(e.Output) < 3 {
 			return fmt.Errorf("episode with uid %d (%s) does not have an output file, maybe you need to encode one?", e.UID, e.Title)
 		}
+		target := s.Atom.EpisodeTarget(&s.Atom.Episodes[i])
 		if e.Length < 1 {
 			log.Printf("WARNING: length field (%s size in bytes) of episode with uid %d (%s) is zero.", e.Output, e.UID, e.Title)
-			if doAction("Ask AWS for the ContentLength of s3://%s?", path.Join(atom.Config.Aws.Buckets.Output, e.Output)) {
-				size, err := awsHandler.GetSize(atom.Config.Aws.Buckets.Output, e.Output)
+			if s.opts.doAction("Ask AWS for the ContentLength of s3://%s?", path.Join(target.Bucket, target.Key(e.Output))) {
+				size, err := s.Aws.GetSize(target.Bucket, target.Key(e.Output))
 				if err != nil {
 					return err
 				}
-				log.Printf("Size of s3://%s is %d (%s will be updated)", path.Join(atom.Config.Aws.Buckets.Output, e.Output), size, specFile)
-				atom.Episodes[i].Length = size
-				updateAtom = true
+				log.Printf("Size of s3://%s is %d (%s will be updated)", path.Join(target.Bucket, target.Key(e.Output)), size, s.SpecFile)
+				s.Atom.Episodes[i].Length = size
+				s.updateAtom = true
 			}
 		}
 		if e.Duration.Duration < (time.Duration(1) * time.Second) {
 			log.Printf("WARNING: duration is too short for episode with uid %d (%s).", e.UID, e.Title)
-			if doAction("Download s3://%s and resolve duration?", path.Join(atom.Config.Aws.Buckets.Output, e.Output)) {
-				err := awsHandler.Download(atom.Config.Aws.Buckets.Output, e.Output)
+			if s.opts.doAction("Download s3://%s and resolve duration?", path.Join(target.Bucket, target.Key(e.Output))) {
+				err := s.Aws.DownloadTo(target.Bucket, target.Key(e.Output), e.Output)
 				if err != nil {
 					return err
 				}
 
-				contentType, err := GetFileContentType(path.Join(atom.LocalStorageDirExpanded(), e.Output))
+				contentType, err := GetFileContentType(path.Join(s.Atom.LocalStorageDirExpanded(), e.Output))
 				if err != nil {
 					return err
 				}
 				if strings.HasPrefix(contentType, "video/") {
 					// Assume it's an mp4
-					l, d, err := Mp4Duration(path.Join(atom.LocalStorageDirExpanded(), e.Output))
+					l, d, err := Mp4Duration(path.Join(s.Atom.LocalStorageDirExpanded(), e.Output))
 					if err != nil {
 						return err
 					}
-					log.Printf("%s is %s long and %d bytes (updating %s).", e.Output, d, l, specFile)
-					atom.Episodes[i].Length = l
-					atom.Episodes[i].Duration.Duration = d
-					updateAtom = true
+					log.Printf("%s is %s long and %d bytes (updating %s).", e.Output, d, l, s.SpecFile)
+					s.Atom.Episodes[i].Length = l
+					s.Atom.Episodes[i].Duration.Duration = d
+					s.updateAtom = true
 				} else {
 					// Assume it's an mp3
-					di, err := mp3duration.ReadFile(path.Join(atom.LocalStorageDirExpanded(), e.Output))
+					di, err := mp3duration.ReadFile(path.Join(s.Atom.LocalStorageDirExpanded(), e.Output))
 					if err != nil {
 						return err
 					}
-					log.Printf("%s is %s long and %d bytes (updating %s).", e.Output, di.Duration, di.Length, specFile)
-					atom.Episodes[i].Length = di.Length
-					atom.Episodes[i].Duration.Duration = di.TimeDuration
-					updateAtom = true
+					log.Printf("%s is %s long and %d bytes (updating %s).", e.Output, di.Duration, di.Length, s.SpecFile)
+					s.Atom.Episodes[i].Length = di.Length
+					s.Atom.Episodes[i].Duration.Duration = di.TimeDuration
+					s.updateAtom = true
 				}
 			}
 		}
@@ -311,9 +494,9 @@ func validateAtom() error {
 
 // Returns a struct combining full atom, private and the episode (for use with
 // the lameCommandTemplate or ffmpegCommandTemplate).
-func getCombined(episode Episode) Combined {
+func (s *Show) getCombined(episode Episode) Combined {
 	return Combined{
-		Atom:    &atom,
+		Atom:    &s.Atom,
 		Episode: &episode,
 	}
 }
@@ -321,46 +504,58 @@ func getCombined(episode Episode) Combined {
 // This function downloads a single episode's (selected by UID) input file,
 // encodes it to mp3, resolves the mp3 files length and duration, and uploads it
 // to the output S3 bucket.
-func downloadEncodeUpload(tmpl *Templates, uid int64, force bool) error {
-	if idx := atom.ContainsEpisode(uid); idx >= 0 {
-		if strings.TrimSpace(atom.Episodes[idx].Input) == "" {
-			return fmt.Errorf("input is missing for UID %d (%s)", atom.Episodes[idx].UID, atom.Episodes[idx].Title)
+func (s *Show) downloadEncodeUpload(tmpl *Templates, uid int64, force bool) error {
+	if idx := s.Atom.ContainsEpisode(uid); idx >= 0 {
+		if strings.TrimSpace(s.Atom.Episodes[idx].Input) == "" {
+			return fmt.Errorf("input is missing for UID %d (%s)", s.Atom.Episodes[idx].UID, s.Atom.Episodes[idx].Title)
 		}
-		if len(atom.Episodes[idx].Output) < 3 || force {
+		if len(s.Atom.Episodes[idx].Output) < 3 || force {
 			// If -R option is given and user answers yes or supplied the
 			// force option, delete remote master.
-			if removeRemoteMasterFile && doAction("Remove s3://%s?", path.Join(atom.Config.Aws.Buckets.Input, atom.Episodes[idx].Input)) {
-				if err := awsHandler.Remove(atom.Config.Aws.Buckets.Input, atom.Episodes[idx].Input); err != nil {
+			if s.opts.RemoveRemoteMaster && s.opts.doAction("Remove s3://%s?", path.Join(s.Atom.Config.Aws.Buckets.Input, s.Atom.Episodes[idx].Input)) {
+				if err := s.Aws.Remove(s.Atom.Config.Aws.Buckets.Input, s.Atom.Episodes[idx].Input); err != nil {
 					return err
 				}
 			}
 			// Download input file, encode it and upload the output file.
-			if doAction("Download s3://%s, encode and upload to s3://%s?", path.Join(atom.Config.Aws.Buckets.Input, atom.Episodes[idx].Input), atom.Config.Aws.Buckets.Output) {
+			if s.opts.doAction("Download s3://%s, encode and upload to s3://%s?", path.Join(s.Atom.Config.Aws.Buckets.Input, s.Atom.Episodes[idx].Input), s.Atom.Config.Aws.Buckets.Output) {
 				// Start by downloading the artwork.
-				if strings.TrimSpace(atom.Episodes[idx].Image) == "" {
-					if strings.TrimSpace(atom.Config.DefaultPodImage) == "" {
-						return fmt.Errorf("no image defined for UID %d and defaultPodImage is empty in %s", atom.Episodes[idx].UID, specFile)
+				if strings.TrimSpace(s.Atom.Episodes[idx].Image) == "" {
+					if strings.TrimSpace(s.Atom.Config.DefaultPodImage) == "" {
+						return fmt.Errorf("no image defined for UID %d and defaultPodImage is empty in %s", s.Atom.Episodes[idx].UID, s.SpecFile)
 					}
-					log.Printf("Using %s as default episode image", atom.Config.DefaultPodImage)
-					atom.Episodes[idx].Image = atom.Config.DefaultPodImage
-					updateAtom = true
+					log.Printf("Using %s as default episode image", s.Atom.Config.DefaultPodImage)
+					s.Atom.Episodes[idx].Image = s.Atom.Config.DefaultPodImage
+					s.updateAtom = true
 				}
-				err := awsHandler.Download(atom.Config.Aws.Buckets.Input, atom.Episodes[idx].Image)
-				if err != nil {
-					return err
+				// The transcript is written as unsynchronised lyrics by the
+				// tagger. Objects that are archived are restored, the
+				// episode is skipped until all restores have completed and
+				// will be picked up by a later run of encode.
+				restorePending := false
+				for _, key := range []string{s.Atom.Episodes[idx].Image, s.Atom.Episodes[idx].Input, s.Atom.Episodes[idx].Transcript} {
+					if strings.TrimSpace(key) == "" {
+						continue
+					}
+					if err := s.Aws.Download(s.Atom.Config.Aws.Buckets.Input, key); err != nil {
+						if !errors.Is(err, ErrRestorePending) {
+							return err
+						}
+						restorePending = true
+					}
 				}
-				err = awsHandler.Download(atom.Config.Aws.Buckets.Input, atom.Episodes[idx].Input)
-				if err != nil {
-					return err
+				if restorePending {
+					log.Printf("Skipping UID %d (%s) until restore from archive has completed, run encode again later", s.Atom.Episodes[idx].UID, s.Atom.Episodes[idx].Title)
+					return nil
 				}
 
-				inputPath := path.Join(atom.LocalStorageDirExpanded(), atom.Episodes[idx].Input)
+				inputPath := path.Join(s.Atom.LocalStorageDirExpanded(), s.Atom.Episodes[idx].Input)
 				inputContentType, err := GetFileContentType(inputPath)
 				if err != nil {
 					return err
 				}
 
-				format := strings.TrimSpace(strings.ToLower(atom.Episodes[idx].Format))
+				format := strings.TrimSpace(strings.ToLower(s.Atom.Episodes[idx].Format))
 
 				// TODO: This nested if statement needs to serious refactoring.
 				// Perhaps
@@ -373,29 +568,29 @@ func downloadEncodeUpload(tmpl *Templates, uid int64, force bool) error {
 					// If episode.format is video or mp4, it's a video episode.
 					switch format {
 					case "", "video", "mp4":
-						if err := EncodeMP4(tmpl, &atom.Episodes[idx]); err != nil {
+						if err := s.EncodeMP4(tmpl, &s.Atom.Episodes[idx]); err != nil {
 							return err
 						}
 					case "audio":
-						if strings.EqualFold(atom.Encoding.PreferredFormat, "m4a") || strings.EqualFold(atom.Encoding.PreferredFormat, "m4b") {
+						if strings.EqualFold(s.Atom.Encoding.PreferredFormat, "m4a") || strings.EqualFold(s.Atom.Encoding.PreferredFormat, "m4b") {
 							// Encode into m4a or m4b
-							if err := EncodeFFmpegAudio(tmpl, &atom.Episodes[idx], atom.Encoding.PreferredFormat); err != nil {
+							if err := s.EncodeFFmpegAudio(tmpl, &s.Atom.Episodes[idx], s.Atom.Encoding.PreferredFormat); err != nil {
 								return err
 							}
 						} else {
 							// Encode mp3 via ffmpeg (piped into lame)
-							if err := EncodeMP3ViaFFmpeg(tmpl, &atom.Episodes[idx]); err != nil {
+							if err := s.EncodeMP3ViaFFmpeg(tmpl, &s.Atom.Episodes[idx]); err != nil {
 								return err
 							}
 						}
 					case "mp3":
 						// Encode mp3 via ffmpeg
-						if err := EncodeMP3ViaFFmpeg(tmpl, &atom.Episodes[idx]); err != nil {
+						if err := s.EncodeMP3ViaFFmpeg(tmpl, &s.Atom.Episodes[idx]); err != nil {
 							return err
 						}
 					case "m4a", "m4b":
 						// Encode m4a or m4b
-						if err := EncodeFFmpegAudio(tmpl, &atom.Episodes[idx], format); err != nil {
+						if err := s.EncodeFFmpegAudio(tmpl, &s.Atom.Episodes[idx], format); err != nil {
 							return err
 						}
 					default:
@@ -406,25 +601,25 @@ func downloadEncodeUpload(tmpl *Templates, uid int64, force bool) error {
 					// mp3 using lame or m4a/m4b using ffmpeg
 					switch format {
 					case "", "audio":
-						if strings.EqualFold(atom.Encoding.PreferredFormat, "m4a") || strings.EqualFold(atom.Encoding.PreferredFormat, "m4b") {
+						if strings.EqualFold(s.Atom.Encoding.PreferredFormat, "m4a") || strings.EqualFold(s.Atom.Encoding.PreferredFormat, "m4b") {
 							// Encode into m4a or m4b
-							if err := EncodeFFmpegAudio(tmpl, &atom.Episodes[idx], atom.Encoding.PreferredFormat); err != nil {
+							if err := s.EncodeFFmpegAudio(tmpl, &s.Atom.Episodes[idx], s.Atom.Encoding.PreferredFormat); err != nil {
 								return err
 							}
 						} else {
 							// Encode mp3 using Lame
-							if err := EncodeMP3(tmpl, &atom.Episodes[idx]); err != nil {
+							if err := s.EncodeMP3(tmpl, &s.Atom.Episodes[idx]); err != nil {
 								return err
 							}
 						}
 					case "mp3":
 						// Encode mp3 using Lame
-						if err := EncodeMP3(tmpl, &atom.Episodes[idx]); err != nil {
+						if err := s.EncodeMP3(tmpl, &s.Atom.Episodes[idx]); err != nil {
 							return err
 						}
 					case "m4a", "m4b":
 						// Encode m4a or m4b
-						if err := EncodeFFmpegAudio(tmpl, &atom.Episodes[idx], format); err != nil {
+						if err := s.EncodeFFmpegAudio(tmpl, &s.Atom.Episodes[idx], format); err != nil {
 							return err
 						}
 					default:
@@ -433,56 +628,75 @@ func downloadEncodeUpload(tmpl *Templates, uid int64, force bool) error {
 				}
 
 				// The Encode functions above all change fields in the atom.
-				updateAtom = true
+				s.updateAtom = true
+
+				// With staging configured, the output file and artwork are
+				// uploaded to staging and the episode is left out of the
+				// production feed until promoted.
+				if s.opts.ToStaging {
+					if !s.Atom.Episodes[idx].Staged && isAfter(time.Now(), s.Atom.Episodes[idx].PubDate.Time) {
+						log.Printf("WARNING: UID %d (%s) is published, it will be left out of the production feed until promoted", s.Atom.Episodes[idx].UID, s.Atom.Episodes[idx].Title)
+					}
+					s.Atom.Episodes[idx].Staged = true
+				} else {
+					s.Atom.Episodes[idx].Staged = false
+				}
+				target := s.Atom.EpisodeTarget(&s.Atom.Episodes[idx])
 
 				// Upload output mp4/mp3/m4a/m4b to output S3 bucket.
-				contentType, err := GetFileContentType(path.Join(atom.LocalStorageDirExpanded(), atom.Episodes[idx].Output))
+				contentType, err := GetFileContentType(path.Join(s.Atom.LocalStorageDirExpanded(), s.Atom.Episodes[idx].Output))
 				if err != nil {
-					return fmt.Errorf("unable to get content-type of file %s: %w", path.Join(atom.LocalStorageDirExpanded(), atom.Episodes[idx].Output), err)
+					return fmt.Errorf("unable to get content-type of file %s: %w", path.Join(s.Atom.LocalStorageDirExpanded(), s.Atom.Episodes[idx].Output), err)
 				}
-				log.Printf("Content-Type of %s is: %s", atom.Episodes[idx].Output, contentType)
-				atom.Episodes[idx].Type = contentType
-				err = awsHandler.Upload(atom.Config.Aws.Buckets.Output, atom.Episodes[idx].Output, contentType, path.Join(atom.LocalStorageDirExpanded(), atom.Episodes[idx].Output))
+				log.Printf("Content-Type of %s is: %s", s.Atom.Episodes[idx].Output, contentType)
+				s.Atom.Episodes[idx].Type = contentType
+				s.runHooks(EventEpisodeEncoded, target, &s.Atom.Episodes[idx])
+
+				err = s.Aws.Upload(ObjectKindMedia, target.Bucket, target.Key(s.Atom.Episodes[idx].Output), contentType, path.Join(s.Atom.LocalStorageDirExpanded(), s.Atom.Episodes[idx].Output))
 				if err != nil {
 					return err
 				}
 
 				// Ensure there is a pubDate set
-				if atom.Episodes[idx].PubDate.IsZero() {
-					log.Printf("UID %d (%s) pubDate is zero, setting to time.Now().UTC()", atom.Episodes[idx].UID, atom.Episodes[idx].Title)
-					atom.Episodes[idx].PubDate.Time = time.Now().UTC()
-					updateAtom = true
+				if s.Atom.Episodes[idx].PubDate.IsZero() {
+					log.Printf("UID %d (%s) pubDate is zero, setting to time.Now().UTC()", s.Atom.Episodes[idx].UID, s.Atom.Episodes[idx].Title)
+					s.Atom.Episodes[idx].PubDate.Time = time.Now().UTC()
+					s.updateAtom = true
 				}
 
-				// Upload artwork (data-in is free, so I did not bother making a smart upload function)
-				contentType, err = GetFileContentType(path.Join(atom.LocalStorageDirExpanded(), atom.Episodes[idx].Image))
+				// Upload artwork (skipped by Upload if unchanged).
+				contentType, err = GetFileContentType(path.Join(s.Atom.LocalStorageDirExpanded(), s.Atom.Episodes[idx].Image))
 				if err != nil {
-					return fmt.Errorf("unable to get content-type of file %s: %w", path.Join(atom.LocalStorageDirExpanded(), atom.Episodes[idx].Image), err)
+					return fmt.Errorf("unable to get content-type of file %s: %w", path.Join(s.Atom.LocalStorageDirExpanded(), s.Atom.Episodes[idx].Image), err)
 				}
-				log.Printf("Content-Type of %s is: %s", atom.Episodes[idx].Image, contentType)
-				err = awsHandler.Upload(atom.Config.Aws.Buckets.Output, atom.Episodes[idx].Image, contentType, path.Join(atom.LocalStorageDirExpanded(), atom.Episodes[idx].Image))
+				log.Printf("Content-Type of %s is: %s", s.Atom.Episodes[idx].Image, contentType)
+				err = s.Aws.Upload(ObjectKindArtwork, target.Bucket, target.Key(s.Atom.Episodes[idx].Image), contentType, path.Join(s.Atom.LocalStorageDirExpanded(), s.Atom.Episodes[idx].Image))
 				if err != nil {
 					return err
 				}
-				processCounter++
+				if err := s.uploadSidecars(&s.Atom.Episodes[idx], target); err != nil {
+					return err
+				}
+				s.runHooks(EventEpisodeUploaded, target, &s.Atom.Episodes[idx])
+				s.processCounter++
 			}
 		}
 	} else {
-		log.Printf("WARNING: Episode with uid %d does not exist in %s, skipping", uid, specFile)
+		log.Printf("WARNING: Episode with uid %d does not exist in %s, skipping", uid, s.SpecFile)
 	}
 	return nil
 }
 
 // Function will iterate all episodes and download, encode, upload any episode
 // with an empty output filename.
-func processAllEpisodes(tmpl *Templates, force bool) error {
+func (s *Show) processAllEpisodes(tmpl *Templates, force bool) error {
 	// We need to download the coverfront image in order to encode anything.
-	err := awsHandler.Download(atom.Config.Aws.Buckets.Input, atom.Encoding.Coverfront)
+	err := s.Aws.Download(s.Atom.Config.Aws.Buckets.Input, s.Atom.Encoding.Coverfront)
 	if err != nil {
 		return err
 	}
-	for idx := range atom.Episodes {
-		err := downloadEncodeUpload(tmpl, atom.Episodes[idx].UID, force)
+	for idx := range s.Atom.Episodes {
+		err := s.downloadEncodeUpload(tmpl, s.Atom.Episodes[idx].UID, force)
 		if err != nil {
 			return err
 		}
@@ -490,9 +704,9 @@ func processAllEpisodes(tmpl *Templates, force bool) error {
 	return nil
 }
 
-func processEpisodes(tmpl *Templates, uidStrings []string, force bool) error {
+func (s *Show) processEpisodes(tmpl *Templates, uidStrings []string, force bool) error {
 	// We need to download the coverfront image in order to encode anything.
-	err := awsHandler.Download(atom.Config.Aws.Buckets.Input, atom.Encoding.Coverfront)
+	err := s.Aws.Download(s.Atom.Config.Aws.Buckets.Input, s.Atom.Encoding.Coverfront)
 	if err != nil {
 		return err
 	}
@@ -501,7 +715,7 @@ func processEpisodes(tmpl *Templates, uidStrings []string, force bool) error {
 		if err != nil {
 			return fmt.Errorf("must specify the UID integer of the episode to process: %w", err)
 		}
-		err = downloadEncodeUpload(tmpl, uid, force)
+		err = s.downloadEncodeUpload(tmpl, uid, force)
 		if err != nil {
 			return fmt.Errorf("error processing episode with UID %d: %w", uid, err)
 		}
@@ -519,20 +733,20 @@ func strSliceContains(slice []string, str string) bool {
 	return false
 }
 
-func createLocalStorageDir() error {
-	dirPaths := []string{atom.LocalStorageDirExpanded()}
-	if len(atom.Encoding.Coverfront) > 0 {
-		dirPaths = append(dirPaths, path.Dir(path.Join(atom.LocalStorageDirExpanded(), atom.Encoding.Coverfront)))
+func (s *Show) createLocalStorageDir() error {
+	dirPaths := []string{s.Atom.LocalStorageDirExpanded()}
+	if len(s.Atom.Encoding.Coverfront) > 0 {
+		dirPaths = append(dirPaths, path.Dir(path.Join(s.Atom.LocalStorageDirExpanded(), s.Atom.Encoding.Coverfront)))
 	}
-	for _, e := range atom.Episodes {
+	for _, e := range s.Atom.Episodes {
 		if len(e.Output) != 0 {
-			dirToAdd := path.Dir(path.Join(atom.LocalStorageDirExpanded(), e.Output))
+			dirToAdd := path.Dir(path.Join(s.Atom.LocalStorageDirExpanded(), e.Output))
 			if !strSliceContains(dirPaths, dirToAdd) {
 				dirPaths = append(dirPaths, dirToAdd)
 			}
 		}
 		if len(e.Input) != 0 {
-			dirToAdd := path.Dir(path.Join(atom.LocalStorageDirExpanded(), e.Input))
+			dirToAdd := path.Dir(path.Join(s.Atom.LocalStorageDirExpanded(), e.Input))
 			if !strSliceContains(dirPaths, dirToAdd) {
 				dirPaths = append(dirPaths, dirToAdd)
 			}
@@ -659,47 +873,30 @@ func Run(commandString string) error {
 
 // EncodeFFmpegAudio encodes input into an m4a or m4b file depending
 // on the value of format.
-func EncodeFFmpegAudio(tmpl *Templates, episode *Episode, format string) error {
+func (s *Show) EncodeFFmpegAudio(tmpl *Templates, episode *Episode, format string) error {
 	if episode == nil {
 		return errors.New("received nil pointer episode")
 	}
 	format = strings.TrimSpace(strings.ToLower(format))
 	episode.Output = ExtensionToBaseFormat(episode.Input, format)
-	combined := getCombined(*episode)
-
-	rplcr := strings.NewReplacer("\n", " ", "\r", "")
+	combined := s.getCombined(*episode)
 
-	lang := atom.Encoding.Language
-	if episode.EncodingLanguage != "" {
-		lang = episode.EncodingLanguage
-	}
-
-	trackInfo := id3v24.TrackInfo{
-		Title:       episode.Title,
-		Album:       atom.Title,
-		Artist:      episode.Author,
-		Genre:       atom.Encoding.Genre,
-		Year:        episode.PubDate.Format("2006"),
-		Date:        episode.PubDate.Time,
-		Track:       fmt.Sprintf("%d", episode.UID),
-		Comment:     episode.Link,
-		Description: rplcr.Replace(episode.Subtitle),
-		Language:    strings.ToLower(lang),
-		CoverJPEG:   path.Join(atom.LocalStorageDirExpanded(), atom.Encoding.Coverfront),
-		Chapters:    episode.Chapters,
+	tags, err := NewPodcastTags(&s.Atom, episode)
+	if err != nil {
+		return err
 	}
 
 	// Get duration of original input file
-	duration, size, err := GetSizeAndDurationViaFFprobe(path.Join(atom.LocalStorageDirExpanded(), episode.Input))
+	duration, size, err := GetSizeAndDurationViaFFprobe(path.Join(s.Atom.LocalStorageDirExpanded(), episode.Input))
 	if err != nil {
 		return fmt.Errorf("unable to get duration and size from input file: %w", err)
 	}
 	// Generate metadata /w chapters (if any)
-	metadataFile, err := id3v24.WriteFFmpegMetadataFile(duration, trackInfo)
+	metadataFile, err := tags.WriteFFmpegMetadataFile(duration)
 	if err != nil {
 		return fmt.Errorf("unable to generate ffmetadata file: %w", err)
 	}
-	//defer os.Remove(metadataFile)
+	defer os.Remove(metadataFile)
 	combined.MetadataFile = metadataFile
 	// Parse template (with metadatafile added to input values)
 	buf := &bytes.Buffer{}
@@ -709,16 +906,19 @@ func EncodeFFmpegAudio(tmpl *Templates, episode *Episode, format string) error {
 	// Encode to preferred output format (m4a or m4b).
 	log.Printf("Executing: %s", buf.String())
 	if err := Run(buf.String()); err != nil {
-		return fmt.Errorf("unable to encode to %s using ffmpeg: %w", atom.Encoding.PreferredFormat, err)
+		return fmt.Errorf("unable to encode to %s using ffmpeg: %w", s.Atom.Encoding.PreferredFormat, err)
 	}
-	outputPath := path.Join(atom.LocalStorageDirExpanded(), episode.Output)
+	outputPath := path.Join(s.Atom.LocalStorageDirExpanded(), episode.Output)
 	// Get correct duration and size of the output file
 	duration, size, err = GetSizeAndDurationViaFFprobe(outputPath)
 	if err != nil {
 		return fmt.Errorf("unable to get duration and size from %s: %w", episode.Output, err)
 	}
+	if err := tags.VerifyFFprobe(outputPath); err != nil {
+		return err
+	}
 	// Update episode length and duration
-	log.Printf("%s is %s long and %d bytes (updating %s)", episode.Output, duration, size, specFile)
+	log.Printf("%s is %s long and %d bytes (updating %s)", episode.Output, duration, size, s.SpecFile)
 	episode.Length = size
 	episode.Duration.Duration = duration
 	return nil
@@ -726,12 +926,12 @@ func EncodeFFmpegAudio(tmpl *Templates, episode *Episode, format string) error {
 
 // EncodeMP3ViaFFmpeg encodes input through ffmpeg piped into lame as
 // an mp3.
-func EncodeMP3ViaFFmpeg(tmpl *Templates, episode *Episode) error {
+func (s *Show) EncodeMP3ViaFFmpeg(tmpl *Templates, episode *Episode) error {
 	if episode == nil {
 		return errors.New("received nil pointer episode")
 	}
 	episode.Output = ExtensionToBaseMp3(episode.Input)
-	combined := getCombined(*episode)
+	combined := s.getCombined(*episode)
 
 	buf := &bytes.Buffer{}
 	if err := tmpl.FFmpegToLame.Execute(buf, combined); err != nil {
@@ -743,17 +943,13 @@ func EncodeMP3ViaFFmpeg(tmpl *Templates, episode *Episode) error {
 	}
 
 	// Add ID3v2.4 tag (artist, album, title, chapters, etc.).
-	outputPath := path.Join(atom.LocalStorageDirExpanded(), episode.Output)
+	outputPath := path.Join(s.Atom.LocalStorageDirExpanded(), episode.Output)
 	log.Printf("Adding ID3v2.4 tag to %s", outputPath)
-	if err := id3v24.WriteID3v2Tag(outputPath, id3v24.TrackInfo{
-		Title:     episode.Title,
-		Album:     atom.Title,
-		Artist:    episode.Author,
-		Genre:     atom.Encoding.Genre,
-		Year:      episode.PubDate.Format("2006"),
-		CoverJPEG: path.Join(atom.LocalStorageDirExpanded(), atom.Encoding.Coverfront),
-		Chapters:  episode.Chapters,
-	}); err != nil {
+	tags, err := NewPodcastTags(&s.Atom, episode)
+	if err != nil {
+		return err
+	}
+	if err := tags.WriteID3v2Tag(outputPath); err != nil {
 		return err
 	}
 	// Get duration and length.
@@ -762,19 +958,19 @@ func EncodeMP3ViaFFmpeg(tmpl *Templates, episode *Episode) error {
 		return err
 	}
 	// Update atom with the length and duration of the encoded mp3.
-	log.Printf("%s is %s long and %d bytes (updating %s)", episode.Output, di.Duration, di.Length, specFile)
+	log.Printf("%s is %s long and %d bytes (updating %s)", episode.Output, di.Duration, di.Length, s.SpecFile)
 	episode.Length = di.Length
 	episode.Duration.Duration = di.TimeDuration
 	return nil
 }
 
 // EncodeMP3 encodes an mp3 using lame.
-func EncodeMP3(tmpl *Templates, episode *Episode) error {
+func (s *Show) EncodeMP3(tmpl *Templates, episode *Episode) error {
 	if episode == nil {
 		return errors.New("received nil pointer episode")
 	}
 	episode.Output = ExtensionToBaseMp3(episode.Input)
-	combined := getCombined(*episode)
+	combined := s.getCombined(*episode)
 	buf := &bytes.Buffer{}
 	if err := tmpl.Lame.Execute(buf, combined); err != nil {
 		return err
@@ -784,17 +980,13 @@ func EncodeMP3(tmpl *Templates, episode *Episode) error {
 		return fmt.Errorf("unable to encode to audio using external encoders (ffmpeg and lame): %w", err)
 	}
 	// Add ID3v2.4 tag (artist, album, title, chapters, etc.).
-	outputPath := path.Join(atom.LocalStorageDirExpanded(), episode.Output)
+	outputPath := path.Join(s.Atom.LocalStorageDirExpanded(), episode.Output)
 	log.Printf("Adding ID3v2.4 tag to %s", outputPath)
-	if err := id3v24.WriteID3v2Tag(outputPath, id3v24.TrackInfo{
-		Title:     episode.Title,
-		Album:     atom.Title,
-		Artist:    episode.Author,
-		Genre:     atom.Encoding.Genre,
-		Year:      episode.PubDate.Format("2006"),
-		CoverJPEG: path.Join(atom.LocalStorageDirExpanded(), atom.Encoding.Coverfront),
-		Chapters:  episode.Chapters,
-	}); err != nil {
+	tags, err := NewPodcastTags(&s.Atom, episode)
+	if err != nil {
+		return err
+	}
+	if err := tags.WriteID3v2Tag(outputPath); err != nil {
 		return err
 	}
 	// Update atom with the length and duration of the encoded mp3.
@@ -802,19 +994,33 @@ func EncodeMP3(tmpl *Templates, episode *Episode) error {
 	if err != nil {
 		return err
 	}
-	log.Printf("%s is %s long and %d bytes (updating %s)", episode.Output, di.Duration, di.Length, specFile)
+	log.Printf("%s is %s long and %d bytes (updating %s)", episode.Output, di.Duration, di.Length, s.SpecFile)
 	episode.Length = di.Length
 	episode.Duration.Duration = di.TimeDuration
 	return nil
 }
 
 // EncodeMP4 encodes input to an mp4 video.
-func EncodeMP4(tmpl *Templates, episode *Episode) error {
+func (s *Show) EncodeMP4(tmpl *Templates, episode *Episode) error {
 	if episode == nil {
 		return errors.New("received nil pointer episode")
 	}
 	episode.Output = ExtensionToBaseMp4(episode.Input)
-	combined := getCombined(*episode)
+	combined := s.getCombined(*episode)
+	tags, err := NewPodcastTags(&s.Atom, episode)
+	if err != nil {
+		return err
+	}
+	duration, _, err := GetSizeAndDurationViaFFprobe(path.Join(s.Atom.LocalStorageDirExpanded(), episode.Input))
+	if err != nil {
+		return fmt.Errorf("unable to get duration and size from input file: %w", err)
+	}
+	metadataFile, err := tags.WriteFFmpegMetadataFile(duration)
+	if err != nil {
+		return fmt.Errorf("unable to generate ffmetadata file: %w", err)
+	}
+	defer os.Remove(metadataFile)
+	combined.MetadataFile = metadataFile
 	buf := &bytes.Buffer{}
 	if err := tmpl.FFmpeg.Execute(buf, combined); err != nil {
 		return err
@@ -823,12 +1029,16 @@ func EncodeMP4(tmpl *Templates, episode *Episode) error {
 	if err := Run(buf.String()); err != nil {
 		return fmt.Errorf("unable to encode to audio using external encoders (ffmpeg and lame): %w", err)
 	}
+	outputPath := path.Join(s.Atom.LocalStorageDirExpanded(), episode.Output)
+	if err := tags.VerifyFFprobe(outputPath); err != nil {
+		return err
+	}
 	// Update atom with the length and duration of the encoded mp4
-	size, duration, err := Mp4Duration(path.Join(atom.LocalStorageDirExpanded(), episode.Output))
+	size, duration, err := Mp4Duration(outputPath)
 	if err != nil {
 		return err
 	}
-	log.Printf("%s is %s long and %d bytes (updating %s)", episode.Output, duration, size, specFile)
+	log.Printf("%s is %s long and %d bytes (updating %s)", episode.Output, duration, size, s.SpecFile)
 	episode.Length = size
 	episode.Duration.Duration = duration
 	return nil
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Hooks are URLs (POSTed a JSON payload) or local commands (given the
+// payload on stdin) run on events. A failing hook is logged but does not
+// fail the command emitting the event.
+
+const (
+	EventEpisodeEncoded  string = "episode.encoded"
+	EventEpisodeUploaded string = "episode.uploaded"
+	EventFeedRendered    string = "feed.rendered"
+	EventFeedPublished   string = "feed.published"
+)
+
+type Hook struct {
+	// Events triggering the hook, all events if empty.
+	Events []string `yaml:"events,omitempty"`
+	// URL to POST the payload to.
+	URL string `yaml:"url,omitempty"`
+	// Extra request headers, environment variables in values are
+	// expanded (e.g Authorization: Bearer $TOKEN).
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Shell command run with the payload on stdin and the event name in
+	// $MKPOD_EVENT.
+	Command string `yaml:"command,omitempty"`
+}
+
+// HookPayload is the JSON document sent to hooks.
+type HookPayload struct {
+	Event   string              `json:"event"`
+	Time    time.Time           `json:"time"`
+	Target  string              `json:"target"`
+	FeedURL string              `json:"feedURL"`
+	Episode *HookPayloadEpisode `json:"episode,omitempty"`
+}
+
+type HookPayloadEpisode struct {
+	UID             int64   `json:"uid"`
+	Title           string  `json:"title"`
+	OutputURL       string  `json:"outputURL"`
+	Duration        string  `json:"duration"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Length          int64   `json:"length"`
+	Type            string  `json:"type"`
+	PubDate         string  `json:"pubDate"`
+}
+
+// Matches returns true if the hook is run on event.
+func (h *Hook) Matches(event string) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, event)
+}
+
+// validateHooks returns error if a hook is not a URL or command or has
+// an unknown event.
+func (s *Show) validateHooks() error {
+	known := []string{EventEpisodeEncoded, EventEpisodeUploaded, EventFeedRendered, EventFeedPublished}
+	for i, h := range s.Atom.Config.Hooks {
+		if (strings.TrimSpace(h.URL) == "") == (strings.TrimSpace(h.Command) == "") {
+			return fmt.Errorf("hook %d in %s must have either url or command", i+1, s.SpecFile)
+		}
+		for _, event := range h.Events {
+			if !slices.Contains(known, event) {
+				return fmt.Errorf("hook %d in %s has unknown event %q (must be one of %s)", i+1, s.SpecFile, event, strings.Join(known, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// newHookPayload returns the payload of event for target and episode
+// (nil for feed events).
+func (s *Show) newHookPayload(event string, target FeedTarget, episode *Episode) HookPayload {
+	payload := HookPayload{
+		Event:   event,
+		Time:    time.Now().UTC(),
+		Target:  target.Name,
+		FeedURL: target.URL(target.Key(s.Atom.Atom)),
+	}
+	if episode != nil {
+		episodeTarget := s.Atom.EpisodeTarget(episode)
+		payload.Episode = &HookPayloadEpisode{
+			UID:             episode.UID,
+			Title:           episode.Title,
+			OutputURL:       episodeTarget.URL(episodeTarget.Key(episode.Output)),
+			Duration:        episode.Duration.String(),
+			DurationSeconds: episode.Duration.Seconds(),
+			Length:          episode.Length,
+			Type:            episode.Type,
+		}
+		if !episode.PubDate.IsZero() {
+			payload.Episode.PubDate = episode.PubDate.String()
+		}
+	}
+	return payload
+}
+
+// runHooks runs every hook matching event with the payload of episode
+// (nil for feed events) in target. Nothing is run in dry-run mode.
+func (s *Show) runHooks(event string, target FeedTarget, episode *Episode) {
+	if s.opts.DryRun {
+		return
+	}
+	var matching []Hook
+	for _, h := range s.Atom.Config.Hooks {
+		if h.Matches(event) {
+			matching = append(matching, h)
+		}
+	}
+	if len(matching) == 0 {
+		return
+	}
+	payload, err := json.Marshal(s.newHookPayload(event, target, episode))
+	if err != nil {
+		log.Printf("ERROR: unable to marshal %s payload: %v", event, err)
+		return
+	}
+	notifier := NewNotifier(s.Atom.Config.Notify)
+	for _, h := range matching {
+		var err error
+		if strings.TrimSpace(h.URL) != "" {
+			err = h.post(notifier, event, payload)
+		} else {
+			err = h.run(event, payload)
+		}
+		if err != nil {
+			log.Printf("ERROR: %s hook failed: %v", event, err)
+		}
+	}
+}
+
+// post sends payload to the URL of the hook with retries.
+func (h *Hook) post(n *Notifier, event string, payload []byte) error {
+	return n.do(fmt.Sprintf("%s hook %s", event, h.URL), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Mkpod-Event", event)
+		for k, v := range h.Headers {
+			req.Header.Set(k, os.ExpandEnv(v))
+		}
+		return req, nil
+	})
+}
+
+// run runs the command of the hook with payload on stdin.
+func (h *Hook) run(event string, payload []byte) error {
+	log.Printf("Executing %s hook: %s", event, h.Command)
+	cmd := exec.Command(shell, shellCommandOption, h.Command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "MKPOD_EVENT="+event)
+	return cmd.Run()
+}
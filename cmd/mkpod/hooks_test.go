@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunHooks(t *testing.T) {
+	s := NewShow("", "")
+
+	payloads := make(chan HookPayload, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if event := r.Header.Get("X-Mkpod-Event"); event != EventEpisodeUploaded {
+			t.Errorf("expected X-Mkpod-Event %s, got %q", EventEpisodeUploaded, event)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("expected expanded Authorization header, got %q", auth)
+		}
+		var payload HookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		payloads <- payload
+	}))
+	defer server.Close()
+	t.Setenv("HOOK_TOKEN", "secret")
+
+	commandOutput := filepath.Join(t.TempDir(), "payload.json")
+	s.Atom = Atom{Atom: "podcast.rss"}
+	s.Atom.Config.BaseURL = "https://mypodbucket.s3.eu-north-1.amazonaws.com"
+	s.Atom.Config.Hooks = []Hook{
+		{
+			Events:  []string{EventEpisodeUploaded},
+			URL:     server.URL,
+			Headers: map[string]string{"Authorization": "Bearer $HOOK_TOKEN"},
+		},
+		{
+			Events:  []string{EventFeedPublished},
+			Command: "cat > " + commandOutput,
+		},
+	}
+	if err := s.validateHooks(); err != nil {
+		t.Fatal(err)
+	}
+	episode := &Episode{
+		UID:      16,
+		Title:    "Reservkraft",
+		Output:   "qzj016.mp3",
+		Length:   1234,
+		Duration: ItunesDuration{90 * time.Second},
+	}
+
+	s.runHooks(EventEpisodeUploaded, s.Atom.ProductionTarget(), episode)
+	select {
+	case payload := <-payloads:
+		if payload.Episode == nil {
+			t.Fatal("expected episode in payload")
+		}
+		if payload.FeedURL != "https://mypodbucket.s3.eu-north-1.amazonaws.com/podcast.rss" {
+			t.Errorf("unexpected feed url %s", payload.FeedURL)
+		}
+		if payload.Episode.OutputURL != "https://mypodbucket.s3.eu-north-1.amazonaws.com/qzj016.mp3" || payload.Episode.Length != 1234 || payload.Episode.DurationSeconds != 90 {
+			t.Errorf("unexpected episode in payload %+v", payload.Episode)
+		}
+	default:
+		t.Fatal("expected url hook to be called")
+	}
+
+	s.runHooks(EventFeedPublished, s.Atom.ProductionTarget(), nil)
+	f, err := os.Open(commandOutput)
+	if err != nil {
+		t.Fatalf("expected command hook to write payload: %v", err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload HookPayload
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != EventFeedPublished || payload.Episode != nil {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	if len(payloads) != 0 {
+		t.Error("expected url hook not to be called on feed.published")
+	}
+
+	s.Atom.Config.Hooks = append(s.Atom.Config.Hooks, Hook{Events: []string{"episode.deleted"}, URL: server.URL})
+	if err := s.validateHooks(); err == nil {
+		t.Error("expected error on unknown event")
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/net/html"
+)
+
+// The import command parses the rss feed of a podcast hosted elsewhere
+// into a new spec. Channel fields map to the atom and items to episodes
+// (keeping their guid so that subscribers do not see the episodes as
+// new). Enclosures and artwork can be downloaded into localStorageDir
+// as masters, the episodes are then encoded and uploaded to the output
+// bucket by mkpod encode -a.
+
+const defaultImportTTL int = 60
+
+func (s *Show) importer(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		log.Fatal("You need to specify the URL or file of the feed to import as argument to this command")
+	}
+	s.opts.Force = c.Bool("force")
+
+	unlock, err := s.lockSpec()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(s.SpecFile); err == nil {
+		if !s.opts.doAction("%s exists, overwrite it with the imported feed?", s.SpecFile) {
+			return nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	b, err := readFeed(c.Args().First())
+	if err != nil {
+		return err
+	}
+	var rss Rss
+	if err := xml.Unmarshal(b, &rss); err != nil {
+		return fmt.Errorf("unable to parse %s: %w", c.Args().First(), err)
+	}
+	s.Atom = importAtom(&rss)
+	s.Atom.Config.LocalStorageDir = c.String("local-storage-dir")
+	log.Printf("Imported %q with %d episodes", s.Atom.Title, len(s.Atom.Episodes))
+
+	if c.Bool("download") {
+		if err := s.createLocalStorageDir(); err != nil {
+			return err
+		}
+		if err := s.downloadImported(&rss); err != nil {
+			return err
+		}
+	}
+
+	out, err := s.Atom.Yaml()
+	if err != nil {
+		return fmt.Errorf("unable to marshall yaml: %w", err)
+	}
+	if err := s.writeSpec(out); err != nil {
+		return err
+	}
+	log.Printf("Wrote %s, complete the config section and run mkpod encode -a to encode and upload the episodes", s.SpecFile)
+	return nil
+}
+
+// readFeed returns the content of feed, a http(s) URL or a file.
+func readFeed(feed string) ([]byte, error) {
+	if !strings.HasPrefix(feed, "http://") && !strings.HasPrefix(feed, "https://") {
+		return os.ReadFile(feed)
+	}
+	resp, err := httpGet(feed)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// httpGet returns the response of a GET request to u, error if the
+// status is not 2xx.
+func httpGet(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", notifyUserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s responded %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+// importAtom maps the channel of rss to an atom and its items to
+// episodes.
+func importAtom(rss *Rss) Atom {
+	ch := &rss.Channel
+	a := Atom{
+		Atom:        defaultPodcastRSS,
+		Title:       strings.TrimSpace(ch.Title),
+		TTL:         ch.Ttl,
+		Language:    strings.TrimSpace(ch.Language),
+		Copyright:   strings.TrimSpace(ch.Copyright),
+		WebMaster:   strings.TrimSpace(ch.WebMaster),
+		Description: HTMLToMarkdown(ch.Description),
+		Subtitle:    strings.TrimSpace(ch.Subtitle),
+		OwnerName:   strings.TrimSpace(ch.Owner.Name),
+		OwnerEmail:  strings.TrimSpace(ch.Owner.Email),
+		Author:      strings.TrimSpace(ch.Author),
+		Explicit:    importExplicit(ch.Explicit),
+		Keywords:    strings.TrimSpace(ch.Keywords),
+	}
+	if a.TTL < 1 {
+		a.TTL = defaultImportTTL
+	}
+	for _, l := range ch.Link {
+		switch {
+		case l.Href == "" && strings.TrimSpace(l.Text) != "":
+			a.Link = strings.TrimSpace(l.Text)
+		case l.Rel == "self" && l.Href != "":
+			// Keep the name of the feed and serve the episodes from
+			// the same location until config.baseURL is changed.
+			a.Atom = urlBase(l.Href)
+			if i := strings.LastIndex(l.Href, "/"); i > 0 {
+				a.Config.BaseURL = l.Href[:i]
+			}
+		}
+	}
+	a.PubDate.Time = importTime(ch.PubDate)
+	a.LastBuildDate.Time = importTime(ch.LastBuildDate)
+	a.Config.Image = strings.TrimSpace(ch.Image.Href)
+	if a.Config.Image == "" {
+		a.Config.Image = strings.TrimSpace(ch.Image.URL)
+	}
+	a.Encoding.Language = a.Language
+	for _, c := range ch.Category {
+		category := Category{Name: c.AttrText}
+		if category.Name == "" {
+			category.Name = strings.TrimSpace(c.Text)
+		}
+		for _, sub := range c.Category {
+			category.Subcategories = append(category.Subcategories, sub.AttrText)
+		}
+		if category.Name != "" {
+			a.Categories = append(a.Categories, category)
+		}
+	}
+
+	for i, item := range ch.Item {
+		e := Episode{
+			Title:    strings.TrimSpace(item.Title),
+			Link:     strings.TrimSpace(item.Link),
+			Author:   strings.TrimSpace(item.Author),
+			Explicit: importExplicit(item.Explicit),
+			Subtitle: strings.TrimSpace(item.Subtitle),
+			GUID:     strings.TrimSpace(item.Guid.Text),
+			Input:    urlBase(item.Enclosure.URL),
+			Image:    urlBase(item.Image.Href),
+		}
+		e.PubDate.Time = importTime(item.PubDate)
+		// Items are listed latest first, the oldest is uid 1 unless the
+		// feed has itunes:episode.
+		e.UID = int64(len(ch.Item) - i)
+		if uid, err := strconv.ParseInt(strings.TrimSpace(item.Episode), 10, 64); err == nil && uid > 0 {
+			e.UID = uid
+		}
+		if d, err := ParseItunesDuration(item.Duration); err != nil {
+			log.Printf("WARNING: Unable to parse duration %q of %q: %v", item.Duration, e.Title, err)
+		} else {
+			e.Duration.Duration = d
+		}
+		switch {
+		case strings.TrimSpace(item.Encoded) != "":
+			e.Description = HTMLToMarkdown(item.Encoded)
+		case strings.TrimSpace(item.Description) != "":
+			e.Description = HTMLToMarkdown(item.Description)
+		default:
+			e.Description = HTMLToMarkdown(item.Summary)
+		}
+		a.Episodes = append(a.Episodes, e)
+	}
+	return a
+}
+
+// downloadImported downloads the enclosures and artwork of rss into
+// localStorageDir.
+func (s *Show) downloadImported(rss *Rss) error {
+	downloads := map[string]string{}
+	if image := s.Atom.Config.Image; image != "" {
+		s.Atom.Encoding.Coverfront = urlBase(image)
+		downloads[s.Atom.Encoding.Coverfront] = image
+	}
+	for _, item := range rss.Channel.Item {
+		for _, u := range []string{item.Enclosure.URL, item.Image.Href} {
+			if strings.TrimSpace(u) != "" {
+				downloads[urlBase(u)] = strings.TrimSpace(u)
+			}
+		}
+	}
+	for name, u := range downloads {
+		file := path.Join(s.Atom.LocalStorageDirExpanded(), name)
+		if _, err := os.Stat(file); err == nil {
+			log.Printf("Will not download %s as %s exists", u, file)
+			continue
+		}
+		if err := downloadURL(u, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadURL downloads u into file through a temporary file.
+func downloadURL(u string, file string) error {
+	resp, err := httpGet(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	tmp := file + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	log.Printf("Downloading %s to %s", u, file)
+	progress := NewProgress(path.Base(file), resp.ContentLength, 0)
+	progress.Start()
+	_, err = io.Copy(f, io.TeeReader(resp.Body, progressCounter{progress}))
+	progress.Stop()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to download %s: %w", u, err)
+	}
+	return os.Rename(tmp, file)
+}
+
+// progressCounter adds everything written to it to a Progress.
+type progressCounter struct {
+	p *Progress
+}
+
+func (c progressCounter) Write(b []byte) (int, error) {
+	c.p.Add(int64(len(b)))
+	return len(b), nil
+}
+
+// collapseSpace replaces every run of white space in s with a single
+// space.
+func collapseSpace(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" {
+			return " "
+		}
+		return ""
+	}
+	collapsed := strings.Join(fields, " ")
+	if unicode.IsSpace(rune(s[0])) {
+		collapsed = " " + collapsed
+	}
+	if unicode.IsSpace(rune(s[len(s)-1])) {
+		collapsed += " "
+	}
+	return collapsed
+}
+
+// urlBase returns the unescaped last path element of u without query,
+// empty string if there is none.
+func urlBase(u string) string {
+	u = strings.TrimSpace(u)
+	if u == "" {
+		return ""
+	}
+	p := u
+	if parsed, err := url.Parse(u); err == nil {
+		p = parsed.Path
+	}
+	base := path.Base(p)
+	if base == "." || base == "/" {
+		return ""
+	}
+	return base
+}
+
+// importTime parses an rss date, zero time if it can not be parsed.
+func importTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	if s != "" {
+		log.Printf("WARNING: Unable to parse date %q", s)
+	}
+	return time.Time{}
+}
+
+func importExplicit(s string) ItunesExplicit {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "yes", "true", "explicit":
+		return ItunesExplicit{S: "yes"}
+	default:
+		return ItunesExplicit{S: "no"}
+	}
+}
+
+// ParseItunesDuration parses an itunes:duration in either of the
+// formats HH:MM:SS, MM:SS or seconds. Empty string is zero duration.
+func ParseItunesDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("duration must be HH:MM:SS, MM:SS or seconds, not %s", s)
+	}
+	var d time.Duration
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("duration must be HH:MM:SS, MM:SS or seconds, not %s", s)
+		}
+		d = d*60 + time.Duration(v*float64(time.Second))
+	}
+	return d.Round(time.Second), nil
+}
+
+// HTMLToMarkdown converts the html of a description to markdown.
+// Paragraphs, line breaks, emphasis, links, lists, headings, code and
+// block quotes are kept, other elements are reduced to their text.
+func HTMLToMarkdown(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "<") {
+		return html.UnescapeString(s)
+	}
+	var out strings.Builder
+	var links []string
+	var lists []int
+	var pre bool
+	newline := func(n int) {
+		text := out.String()
+		trailing := len(text) - len(strings.TrimRight(text, "\n"))
+		if len(text) == 0 {
+			return
+		}
+		if trimmed := strings.TrimRight(text, " "); len(trimmed) != len(text) {
+			out.Reset()
+			out.WriteString(trimmed)
+		}
+		for ; trailing < n; trailing++ {
+			out.WriteString("\n")
+		}
+	}
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+		switch tt {
+		case html.TextToken:
+			text := tok.Data
+			if !pre {
+				text = collapseSpace(text)
+				if cur := out.String(); cur == "" || strings.HasSuffix(cur, " ") || strings.HasSuffix(cur, "\n") {
+					text = strings.TrimLeft(text, " ")
+				}
+			}
+			out.WriteString(text)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch tok.Data {
+			case "p", "div":
+				newline(2)
+			case "br":
+				out.WriteString("  \n")
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				newline(2)
+				out.WriteString(strings.Repeat("#", int(tok.Data[1]-'0')) + " ")
+			case "strong", "b":
+				out.WriteString("**")
+			case "em", "i":
+				out.WriteString("*")
+			case "code":
+				if !pre {
+					out.WriteString("`")
+				}
+			case "pre":
+				newline(2)
+				out.WriteString("```\n")
+				pre = true
+			case "blockquote":
+				newline(2)
+				out.WriteString("> ")
+			case "ul":
+				newline(2)
+				lists = append(lists, 0)
+			case "ol":
+				newline(2)
+				lists = append(lists, 1)
+			case "li":
+				newline(1)
+				indent := strings.Repeat("  ", max(len(lists)-1, 0))
+				if len(lists) > 0 && lists[len(lists)-1] > 0 {
+					fmt.Fprintf(&out, "%s%d. ", indent, lists[len(lists)-1])
+					lists[len(lists)-1]++
+				} else {
+					out.WriteString(indent + "- ")
+				}
+			case "a":
+				href := ""
+				for _, attr := range tok.Attr {
+					if attr.Key == "href" {
+						href = attr.Val
+					}
+				}
+				links = append(links, href)
+				if href != "" {
+					out.WriteString("[")
+				}
+			case "img":
+				var src, alt string
+				for _, attr := range tok.Attr {
+					switch attr.Key {
+					case "src":
+						src = attr.Val
+					case "alt":
+						alt = attr.Val
+					}
+				}
+				if src != "" {
+					fmt.Fprintf(&out, "![%s](%s)", alt, src)
+				}
+			}
+		case html.EndTagToken:
+			switch tok.Data {
+			case "p", "div", "blockquote", "h1", "h2", "h3", "h4", "h5", "h6":
+				newline(2)
+			case "strong", "b":
+				out.WriteString("**")
+			case "em", "i":
+				out.WriteString("*")
+			case "code":
+				if !pre {
+					out.WriteString("`")
+				}
+			case "pre":
+				newline(1)
+				out.WriteString("```")
+				newline(2)
+				pre = false
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				newline(2)
+			case "a":
+				if len(links) > 0 {
+					if href := links[len(links)-1]; href != "" {
+						fmt.Fprintf(&out, "](%s)", href)
+					}
+					links = links[:len(links)-1]
+				}
+			}
+		}
+	}
+	return strings.TrimSpace(out.String())
+}
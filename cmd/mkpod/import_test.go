@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+const importTestFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+  <channel>
+    <atom:link href="https://oldhost.example/feeds/qzj.xml" rel="self" type="application/rss+xml"/>
+    <title>QZJ</title>
+    <link>https://qzj.se</link>
+    <language>sv</language>
+    <pubDate>Mon, 21 Mar 2022 22:37:44 +0000</pubDate>
+    <description><![CDATA[<p>A podcast about <b>radio</b>.</p>]]></description>
+    <itunes:author>SA6MWA</itunes:author>
+    <itunes:owner>
+      <itunes:name>SA6MWA</itunes:name>
+      <itunes:email>sa6mwa@example.com</itunes:email>
+    </itunes:owner>
+    <itunes:explicit>false</itunes:explicit>
+    <itunes:image href="https://oldhost.example/art/cover.jpg"/>
+    <itunes:category text="Technology">
+      <itunes:category text="Tech News"/>
+    </itunes:category>
+    <itunes:category text="Leisure"/>
+    <item>
+      <guid isPermaLink="false">oldhost-episode-2</guid>
+      <title>Second</title>
+      <pubDate>Tue, 22 Mar 2022 10:00:00 +0000</pubDate>
+      <itunes:duration>1:02:03</itunes:duration>
+      <description>Plain</description>
+      <content:encoded><![CDATA[<p>See <a href="https://qzj.se">qzj.se</a></p><ul><li>one</li><li>two</li></ul>]]></content:encoded>
+      <enclosure url="https://oldhost.example/media/qzj002%20final.mp3?x=1" type="audio/mpeg" length="1234"/>
+      <itunes:image href="https://oldhost.example/art/qzj002.jpg"/>
+    </item>
+    <item>
+      <guid>oldhost-episode-1</guid>
+      <title>First</title>
+      <pubDate>Mon, 21 Mar 2022 22:37:44 +0000</pubDate>
+      <itunes:duration>754</itunes:duration>
+      <itunes:explicit>yes</itunes:explicit>
+      <description>First &amp; foremost</description>
+      <enclosure url="https://oldhost.example/media/qzj001.mp3" type="audio/mpeg" length="5678"/>
+    </item>
+  </channel>
+</rss>`
+
+func TestImportAtom(t *testing.T) {
+	var rss Rss
+	if err := xml.Unmarshal([]byte(importTestFeed), &rss); err != nil {
+		t.Fatal(err)
+	}
+	a := importAtom(&rss)
+	if a.Atom != "qzj.xml" || a.Config.BaseURL != "https://oldhost.example/feeds" || a.Link != "https://qzj.se" {
+		t.Errorf("unexpected atom %s, baseURL %s or link %s", a.Atom, a.Config.BaseURL, a.Link)
+	}
+	if a.Description != "A podcast about **radio**." || a.Config.Image != "https://oldhost.example/art/cover.jpg" {
+		t.Errorf("unexpected description %q or image %s", a.Description, a.Config.Image)
+	}
+	if a.OwnerEmail != "sa6mwa@example.com" || a.Explicit.S != "no" || a.TTL != defaultImportTTL {
+		t.Errorf("unexpected owner email %s, explicit %s or ttl %d", a.OwnerEmail, a.Explicit.S, a.TTL)
+	}
+	if len(a.Categories) != 2 || a.Categories[0].Name != "Technology" || len(a.Categories[0].Subcategories) != 1 {
+		t.Errorf("unexpected categories %+v", a.Categories)
+	}
+	if len(a.Episodes) != 2 {
+		t.Fatalf("expected 2 episodes, got %d", len(a.Episodes))
+	}
+	second, first := a.Episodes[0], a.Episodes[1]
+	if second.UID != 2 || first.UID != 1 {
+		t.Errorf("expected uids 2 and 1, got %d and %d", second.UID, first.UID)
+	}
+	if second.GUID != "oldhost-episode-2" || first.GUID != "oldhost-episode-1" {
+		t.Errorf("expected guids to be preserved, got %s and %s", second.GUID, first.GUID)
+	}
+	if second.Duration.Duration != time.Hour+2*time.Minute+3*time.Second || first.Duration.Duration != 754*time.Second {
+		t.Errorf("unexpected durations %s and %s", second.Duration, first.Duration)
+	}
+	if second.Input != "qzj002 final.mp3" || second.Image != "qzj002.jpg" {
+		t.Errorf("unexpected input %q or image %q", second.Input, second.Image)
+	}
+	if expected := "See [qzj.se](https://qzj.se)\n\n- one\n- two"; second.Description != expected {
+		t.Errorf("expected description %q, got %q", expected, second.Description)
+	}
+	if first.Description != "First & foremost" || first.Explicit.S != "yes" {
+		t.Errorf("unexpected description %q or explicit %s", first.Description, first.Explicit.S)
+	}
+	if !second.PubDate.Equal(time.Date(2022, 3, 22, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected pubDate %s", second.PubDate)
+	}
+
+	s := NewShow("", "")
+	s.Atom = a
+	if got := s.Atom.EpisodeGUID(&s.Atom.Episodes[0]); got != "oldhost-episode-2" {
+		t.Errorf("expected imported guid in feed, got %s", got)
+	}
+}
+
+func TestParseItunesDuration(t *testing.T) {
+	for input, expected := range map[string]time.Duration{
+		"":         0,
+		"754":      754 * time.Second,
+		"12:34":    12*time.Minute + 34*time.Second,
+		"01:02:03": time.Hour + 2*time.Minute + 3*time.Second,
+		"90.6":     91 * time.Second,
+	} {
+		got, err := ParseItunesDuration(input)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("%q: expected %s, got %s", input, expected, got)
+		}
+	}
+	for _, input := range []string{"1:2:3:4", "abc", "-5"} {
+		if _, err := ParseItunesDuration(input); err == nil {
+			t.Errorf("%q: expected error", input)
+		}
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	for input, expected := range map[string]string{
+		"Plain &amp; simple":                                                  "Plain & simple",
+		"<p>One</p>\n<p>Two <em>three</em></p>":                               "One\n\nTwo *three*",
+		"Line<br>break":                                                       "Line  \nbreak",
+		"<h2>Title</h2><ol><li>a</li><li>b</li></ol>":                         "## Title\n\n1. a\n2. b",
+		"<pre><code>x := 1\n</code></pre>":                                    "```\nx := 1\n```",
+		`<p>Hello <a href="https://example.com">world</a> <code>x</code></p>`: "Hello [world](https://example.com) `x`",
+	} {
+		if got := HTMLToMarkdown(input); got != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, got)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sa6mwa/id3v24"
+	"github.com/urfave/cli/v2"
+)
+
+// The ls and show commands print the episodes of the spec (ls as a
+// table, show one episode in full) without reading yaml, or as json for
+// scripting. Nothing is fetched from the buckets, whether an episode is
+// encoded is decided from its output field.
+
+const (
+	episodeStatePublished string = "published"
+	episodeStateScheduled string = "scheduled"
+	episodeStateStaged    string = "staged"
+)
+
+// EpisodeListing is an episode as listed by ls.
+type EpisodeListing struct {
+	UID      int64     `json:"uid"`
+	Title    string    `json:"title"`
+	PubDate  time.Time `json:"pubDate"`
+	State    string    `json:"state"`
+	Season   int       `json:"season,omitempty"`
+	Format   string    `json:"format"`
+	Duration string    `json:"duration"`
+	Length   int64     `json:"length"`
+	Encoded  bool      `json:"encoded"`
+}
+
+// EpisodeDetails is an episode as shown by show.
+type EpisodeDetails struct {
+	EpisodeListing
+	Link         string           `json:"link"`
+	GUID         string           `json:"guid"`
+	Author       string           `json:"author"`
+	Subtitle     string           `json:"subtitle"`
+	Description  string           `json:"description"`
+	Type         string           `json:"type"`
+	Input        string           `json:"input"`
+	Output       string           `json:"output"`
+	EnclosureURL string           `json:"enclosureURL,omitempty"`
+	ImageURL     string           `json:"imageURL,omitempty"`
+	Transcript   string           `json:"transcript,omitempty"`
+	Chapters     []id3v24.Chapter `json:"chapters,omitempty"`
+}
+
+// EpisodeState returns whether episode is published, scheduled (pubDate
+// after now) or staged.
+func (a *Atom) EpisodeState(episode *Episode, now time.Time) string {
+	switch {
+	case episode.Staged:
+		return episodeStateStaged
+	case a.ProductionTarget().Includes(episode, now):
+		return episodeStatePublished
+	default:
+		return episodeStateScheduled
+	}
+}
+
+// episodeListing returns episode as listed by ls.
+func (a *Atom) episodeListing(episode *Episode, now time.Time) EpisodeListing {
+	format := episode.Format
+	if strings.TrimSpace(format) == "" && strings.TrimSpace(episode.Output) != "" {
+		format = strings.TrimPrefix(path.Ext(episode.Output), ".")
+	}
+	return EpisodeListing{
+		UID:      episode.UID,
+		Title:    episode.Title,
+		PubDate:  episode.PubDate.Time,
+		State:    a.EpisodeState(episode, now),
+		Season:   episode.Season,
+		Format:   format,
+		Duration: episode.Duration.String(),
+		Length:   episode.Length,
+		Encoded:  strings.TrimSpace(episode.Output) != "",
+	}
+}
+
+// episodeDetails returns episode as shown by show.
+func (a *Atom) episodeDetails(episode *Episode, now time.Time) EpisodeDetails {
+	d := EpisodeDetails{
+		EpisodeListing: a.episodeListing(episode, now),
+		Link:           a.EpisodeLink(episode),
+		GUID:           a.EpisodeGUID(episode),
+		Author:         episode.Author,
+		Subtitle:       episode.Subtitle,
+		Description:    episode.Description,
+		Type:           episode.Type,
+		Input:          episode.Input,
+		Output:         episode.Output,
+		Transcript:     episode.Transcript,
+		Chapters:       episode.Chapters,
+	}
+	if strings.TrimSpace(episode.Output) != "" {
+		d.EnclosureURL = a.EpisodeURL(episode, episode.Output)
+	}
+	if strings.TrimSpace(episode.Image) != "" {
+		d.ImageURL = a.EpisodeURL(episode, episode.Image)
+	}
+	return d
+}
+
+// listEpisodes returns the episodes of the atom matching the filters of
+// the ls command.
+func (s *Show) listEpisodes(c *cli.Context, now time.Time) []EpisodeListing {
+	listings := []EpisodeListing{}
+	for i := range s.Atom.Episodes {
+		l := s.Atom.episodeListing(&s.Atom.Episodes[i], now)
+		switch {
+		case c.Bool("unencoded") && l.Encoded,
+			c.Bool("scheduled") && l.State != episodeStateScheduled,
+			c.Bool("published") && l.State != episodeStatePublished,
+			c.Bool("staged") && l.State != episodeStateStaged,
+			c.IsSet("season") && l.Season != c.Int("season"):
+			continue
+		}
+		listings = append(listings, l)
+	}
+	return listings
+}
+
+func (s *Show) lister(c *cli.Context) error {
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+	listings := s.listEpisodes(c, time.Now())
+	if c.Bool("json") {
+		return writeJSON(os.Stdout, listings)
+	}
+	return writeEpisodeTable(os.Stdout, listings)
+}
+
+func (s *Show) showEpisode(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("expected the UID of one episode as argument")
+	}
+	uid, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("must specify the UID integer of the episode to show: %w", err)
+	}
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+	idx := s.Atom.ContainsEpisode(uid)
+	if idx < 0 {
+		return fmt.Errorf("there is no episode with UID %d in %s", uid, s.SpecFile)
+	}
+	details := s.Atom.episodeDetails(&s.Atom.Episodes[idx], time.Now())
+	if c.Bool("json") {
+		return writeJSON(os.Stdout, details)
+	}
+	return details.WriteText(os.Stdout)
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeEpisodeTable writes listings as a table to w.
+func writeEpisodeTable(w io.Writer, listings []EpisodeListing) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "UID\tTITLE\tPUBDATE\tSTATE\tSEASON\tFORMAT\tDURATION\tSIZE\tENCODED")
+	for _, l := range listings {
+		season := ""
+		if l.Season != 0 {
+			season = strconv.Itoa(l.Season)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", l.UID, l.Title, l.PubDate.Format("2006-01-02 15:04"), l.State, season, l.Format, l.Duration, humanBytes(l.Length), yesNo(l.Encoded))
+	}
+	return tw.Flush()
+}
+
+// WriteText writes the episode as human readable text to w, the
+// chapters as they are rendered in the description of the feed.
+func (d *EpisodeDetails) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, field := range [][2]string{
+		{"UID", strconv.FormatInt(d.UID, 10)},
+		{"Title", d.Title},
+		{"Subtitle", d.Subtitle},
+		{"Author", d.Author},
+		{"PubDate", d.PubDate.Format(time.RFC1123Z)},
+		{"State", d.State},
+		{"Season", strconv.Itoa(d.Season)},
+		{"Format", d.Format},
+		{"Duration", d.Duration},
+		{"Size", fmt.Sprintf("%s (%d bytes)", humanBytes(d.Length), d.Length)},
+		{"Encoded", yesNo(d.Encoded)},
+		{"Type", d.Type},
+		{"Link", d.Link},
+		{"GUID", d.GUID},
+		{"Input", d.Input},
+		{"Output", d.Output},
+		{"Enclosure", d.EnclosureURL},
+		{"Image", d.ImageURL},
+		{"Transcript", d.Transcript},
+	} {
+		fmt.Fprintf(tw, "%s:\t%s\n", field[0], field[1])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if chapters := SpotifyChapters(d.Chapters); chapters != "" {
+		fmt.Fprintf(w, "\nChapters:\n%s", chapters)
+	}
+	if strings.TrimSpace(d.Description) != "" {
+		fmt.Fprintf(w, "\nDescription:\n%s\n", strings.TrimSpace(d.Description))
+	}
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sa6mwa/id3v24"
+	"github.com/urfave/cli/v2"
+)
+
+func TestListEpisodes(t *testing.T) {
+	now := time.Date(2024, 10, 23, 8, 0, 0, 0, time.UTC)
+	s := NewShow("", "")
+	s.Atom = Atom{
+		Config: Config{
+			BaseURL: "https://example.com/pod",
+			Staging: StagingConfig{BaseURL: "https://example.com/staging", Prefix: "staging"},
+		},
+		Episodes: []Episode{
+			{UID: 3, Title: "Staged", PubDate: ItunesTime{now.Add(-time.Hour)}, Output: "three.mp3", Staged: true},
+			{UID: 2, Title: "Scheduled", Season: 2, PubDate: ItunesTime{now.Add(time.Hour)}, Input: "two.wav"},
+			{UID: 1, Title: "Published", Season: 1, PubDate: ItunesTime{now.Add(-24 * time.Hour)}, Output: "one.mp3", Image: "one.jpg", Length: 23393112, Duration: ItunesDuration{90 * time.Second}, Chapters: []id3v24.Chapter{{Title: "Intro", Start: "00:00:00.000"}, {Title: "Antennas", Start: "00:01:00.000"}}},
+		},
+	}
+	for args, expected := range map[string][]int64{
+		"":                       {3, 2, 1},
+		"--unencoded":            {2},
+		"--scheduled":            {2},
+		"--published":            {1},
+		"--staged":               {3},
+		"--season 2":             {2},
+		"--season 1 --unencoded": {},
+	} {
+		set := flag.NewFlagSet("ls", flag.ContinueOnError)
+		for _, name := range []string{"unencoded", "scheduled", "published", "staged"} {
+			set.Bool(name, false, "")
+		}
+		set.Int("season", 0, "")
+		if err := set.Parse(strings.Fields(args)); err != nil {
+			t.Fatal(err)
+		}
+		var uids []int64
+		for _, l := range s.listEpisodes(cli.NewContext(cli.NewApp(), set, nil), now) {
+			uids = append(uids, l.UID)
+		}
+		if len(uids) != len(expected) {
+			t.Errorf("ls %s: expected %v, got %v", args, expected, uids)
+			continue
+		}
+		for i := range uids {
+			if uids[i] != expected[i] {
+				t.Errorf("ls %s: expected %v, got %v", args, expected, uids)
+			}
+		}
+	}
+
+	staged := s.Atom.episodeDetails(&s.Atom.Episodes[0], now)
+	if staged.State != episodeStateStaged || staged.EnclosureURL != "https://example.com/staging/staging/three.mp3" {
+		t.Errorf("expected staged enclosure URL, got %+v", staged)
+	}
+	d := s.Atom.episodeDetails(&s.Atom.Episodes[2], now)
+	if d.State != episodeStatePublished || d.Format != "mp3" || !d.Encoded || d.EnclosureURL != "https://example.com/pod/one.mp3" || d.ImageURL != "https://example.com/pod/one.jpg" {
+		t.Errorf("unexpected details %+v", d)
+	}
+	b := &bytes.Buffer{}
+	if err := d.WriteText(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Enclosure:   https://example.com/pod/one.mp3\n", "Size:        22.3 MiB (23393112 bytes)\n", "Duration:    00:01:30\n", "\nChapters:\n(00:00) Intro\n(01:00) Antennas\n"} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, b)
+		}
+	}
+	b.Reset()
+	if err := writeJSON(b, d); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"uid": 1,`, `"state": "published",`, `"enclosureURL": "https://example.com/pod/one.mp3",`, `"title": "Antennas"`} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected %s in:\n%s", expected, b)
+		}
+	}
+}
@@ -8,51 +8,34 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 	"text/template"
 	"time"
 
-	"github.com/sa6mwa/id3v24"
 	"github.com/urfave/cli/v2"
 	//"github.com/logrusorgru/aurora"
-
-	"gopkg.in/alessio/shellescape.v1"
 )
 
 //go:embed template.rss
-var rssTemplate string
+var defaultRSSTemplate string
 
 var (
-	atom                               Atom
-	specFile                           string
-	awsHandler                         AwsHandler
-	askNoQuestions                     bool       = false
-	removeRemoteMasterFile             bool       = false
-	dryRun                             bool       = false
-	lameCommandTemplate                string     = defaultLameCommandTemplate
-	ffmpegCommandTemplate              string     = defaultFFmpegCommandTemplate
-	ffmpegToAudioCommandTemplate       string     = defaultFFmpegToAudioCommandTemplate
-	ffmpegToM4ACommandTemplate         string     = defaultFFmpegToM4ACommandTemplate
-	ffmpegPreProcessingCommandTemplate string     = defaultFFmpegPreProcessingCommandTemplate
-	templates                          *Templates = &Templates{}
-	updateAtom                         bool       = false
-	processCounter                     int        = 0
+	strictDecode bool = false
 )
 
 const (
-	//defaultTemplate   string = "template.rss"
 	defaultSpec       string = "podspec.yaml"
 	defaultPodcastRSS string = "podcast.rss"
 	// The lame command template is parsed for each episode being
 	// encoded where .Atom is the full atom and .Episode is the episode
 	// currently being processed (current item in the Episodes struct
-	// slice).
+	// slice). The ID3v2.4 tag is not written by lame, it is added
+	// afterwards by NewPodcastTags for all lame and ffmpeg templates.
 	defaultLameCommandTemplate string = `{{ $PRE := "" }}{{ if ne .Atom.LocalStorageDirExpanded "" }}{{ $PRE = print .Atom.LocalStorageDirExpanded "/" }}{{ end }}{{ .Atom.LamepathExpanded }} -b {{ .Atom.Encoding.Bitrate }} {{ escape (print $PRE .Episode.Input) }} {{ escape (print $PRE .Episode.Output) }}`
 
-	// defaultLameCommandTemplate string = `{{ $PRE := "" }}{{ if ne .Atom.LocalStorageDirExpanded "" }}{{ $PRE = print .Atom.LocalStorageDirExpanded "/" }}{{ end }}{{ .Atom.LamepathExpanded }} -b {{ .Atom.Encoding.Bitrate }} --add-id3v2 --tv TLAN={{ if ne .Episode.EncodingLanguage "" }}{{ escape .Episode.EncodingLanguage }}{{ else }}{{ escape .Atom.Encoding.Language }}{{ end }} --tt {{ escape .Episode.Title }} --ta {{ escape .Atom.Author }} --tl {{ escape .Atom.Title }} --ty {{ escape (.Episode.PubDate.Format "2006") }} --tc {{ escape .Episode.Subtitle }} --tn {{ .Episode.UID }} --tg {{ escape .Atom.Encoding.Genre }} --ti {{ escape (print $PRE .Atom.Encoding.Coverfront) }} --tv WOAR={{ escape .Episode.Link }} {{ escape (print $PRE .Episode.Input) }} {{ escape (print $PRE .Episode.Output) }}`
-
-	defaultFFmpegCommandTemplate string = `{{ $PRE := ""}}{{ if ne .Atom.LocalStorageDirExpanded ""}}{{ $PRE = print .Atom.LocalStorageDirExpanded "/"}}{{ end }}{{ .Atom.FFmpegPathExpanded }} -y -i {{ escape (print $PRE .Episode.Input) }} -pix_fmt yuv420p -colorspace bt709 -color_trc bt709 -color_primaries bt709 -color_range tv -c:v libx264 -profile:v high -crf {{ .Atom.Encoding.CRF }} -maxrate 1M -bufsize 2M -preset medium -coder 1 -movflags +faststart -x264-params open-gop=0 -c:a libfdk_aac -profile:a aac_low -b:a {{ .Atom.Encoding.ABR }} {{ escape (print $PRE .Episode.Output) }}`
+	defaultFFmpegCommandTemplate string = `{{ $PRE := ""}}{{ if ne .Atom.LocalStorageDirExpanded ""}}{{ $PRE = print .Atom.LocalStorageDirExpanded "/"}}{{ end }}{{ .Atom.FFmpegPathExpanded }} -y -i {{ escape (print $PRE .Episode.Input) }} {{ if ne .MetadataFile "" }}-i {{ escape .MetadataFile }} -map_metadata 1 -map_chapters 1 {{ end }}-pix_fmt yuv420p -colorspace bt709 -color_trc bt709 -color_primaries bt709 -color_range tv -c:v libx264 -profile:v high -crf {{ .Atom.Encoding.CRF }} -maxrate 1M -bufsize 2M -preset medium -coder 1 -movflags +faststart -x264-params open-gop=0 -c:a libfdk_aac -profile:a aac_low -b:a {{ .Atom.Encoding.ABR }} {{ escape (print $PRE .Episode.Output) }}`
 
-	defaultFFmpegToAudioCommandTemplate string = `{{ $PRE := ""}}{{ if ne .Atom.LocalStorageDirExpanded ""}}{{ $PRE = print .Atom.LocalStorageDirExpanded "/"}}{{ end }}{{ .Atom.FFmpegPathExpanded }} -y -i {{ escape (print $PRE .Episode.Input) }} -vn -f wav -c:a pcm_s16le -ac 2 pipe: | {{ .Atom.LamepathExpanded }} -b {{ .Atom.Encoding.Bitrate }} --add-id3v2 --tv TLAN={{ if ne .Episode.EncodingLanguage "" }}{{ escape .Episode.EncodingLanguage }}{{ else }}{{ escape .Atom.Encoding.Language }}{{ end }} --tt {{ escape .Episode.Title }} --ta {{ escape .Atom.Author }} --tl {{ escape .Atom.Title }} --ty {{ escape (.Episode.PubDate.Format "2006") }} --tc {{ escape .Episode.Subtitle }} --tn {{ .Episode.UID }} --tg {{ escape .Atom.Encoding.Genre }} --ti {{ escape (print $PRE .Atom.Encoding.Coverfront) }} --tv WOAR={{ escape .Atom.Link }} - {{ escape (print $PRE .Episode.Output) }}`
+	defaultFFmpegToAudioCommandTemplate string = `{{ $PRE := ""}}{{ if ne .Atom.LocalStorageDirExpanded ""}}{{ $PRE = print .Atom.LocalStorageDirExpanded "/"}}{{ end }}{{ .Atom.FFmpegPathExpanded }} -y -i {{ escape (print $PRE .Episode.Input) }} -vn -f wav -c:a pcm_s16le -ac 2 pipe: | {{ .Atom.LamepathExpanded }} -b {{ .Atom.Encoding.Bitrate }} - {{ escape (print $PRE .Episode.Output) }}`
 
 	// Used to make m4a or m4b audio files. Combines the audio, conver
 	// image, and metadata with chapters into the output m4a/m4b in a
@@ -68,6 +51,12 @@ const (
 	// AntennaPod and VLC.
 	defaultFFmpegToM4ACommandTemplate string = `{{ $PRE := "" }}{{ if ne .Atom.LocalStorageDirExpanded ""}}{{ $PRE = print .Atom.LocalStorageDirExpanded "/"}}{{ end }}{{ .Atom.FFmpegPathExpanded }} -y -i {{ escape (print $PRE .Episode.Input) }} -i {{ escape (print $PRE .Atom.Encoding.Coverfront) }} -i {{ escape .MetadataFile }} -map 0:a -c:a libfdk_aac -profile:a aac_low -b:a {{ .Atom.Encoding.ABR }} -metadata:s:a:0 language={{ if ne .Episode.EncodingLanguage "" }}{{ escape .Episode.EncodingLanguage }}{{ else }}{{ escape .Atom.Encoding.Language }}{{ end }} -map 1:v -c:v mjpeg -disposition:v:0 attached_pic -metadata:s:v title="Cover" -metadata:s:v comment="Cover (front)" -map_metadata 2 -map_chapters 2 -movflags faststart {{ escape (print $PRE .Episode.Output) }}`
 
+	// Used by the retag command to replace the metadata and chapters
+	// of an already encoded mp4, m4a or m4b without re-encoding. All
+	// streams (including the attached cover) are copied as is into
+	// .TempFile which replaces the output file when successful.
+	defaultFFmpegRetagCommandTemplate string = `{{ $PRE := "" }}{{ if ne .Atom.LocalStorageDirExpanded ""}}{{ $PRE = print .Atom.LocalStorageDirExpanded "/"}}{{ end }}{{ .Atom.FFmpegPathExpanded }} -y -i {{ escape (print $PRE .Episode.Output) }} -i {{ escape .MetadataFile }} -map 0 -c copy -map_metadata 1 -map_chapters 1 -movflags +faststart {{ escape .TempFile }}`
+
 	// EQ and compression presets
 	//
 	// The settings should allow you to have a background stereo track
@@ -126,19 +115,28 @@ func main() {
 		Name:      "mkpod",
 		Usage:     "Tool to render a podcast rss feed from spec, automate mp3/mp4 encoding and publish to Amazon S3.",
 		Copyright: "Copyright SA6MWA 2022-2025 sa6mwa@gmail.com, https://github.com/sa6mwa/mkpod",
+		Flags: append([]cli.Flag{
+			&cli.BoolFlag{
+				Name:        "strict",
+				Value:       false,
+				Usage:       "Fail on unknown keys in the spec, episode files and workspace instead of warning",
+				Destination: &strictDecode,
+			},
+			environmentFlag(),
+		}, configFlags()...),
 		Commands: []*cli.Command{
 			{
 				Name:    "preprocess",
 				Aliases: []string{"pre"},
 				Usage:   "Run an audiofile (e.g a raw microphone track) through pre-processing",
-				Action:  preprocess,
-				Flags: []cli.Flag{
-					// &cli.StringFlag{
-					// 	Name:    "spec",
-					// 	Aliases: []string{"s"},
-					// 	Value:   defaultSpec,
-					// 	Usage:   "Main configuration file for generating the atom RSS",
-					// },
+				Action:  showAction((*Show).preprocess),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Configuration file to read the templates section from (optional)",
+					},
 					&cli.StringFlag{
 						Name:  "prefix",
 						Value: defaultPreProcessingPrefix,
@@ -150,14 +148,14 @@ func main() {
 						Value:   defaultPreset,
 						Usage:   "Preset for EQ, compression, limiter and similar, available: sm7b, qzj, aggressive, heavy, qzj-podmic, qzj-podmic2, none. Limiter settings (except preset \"none\") will allow you to have background audio/music -10 dB. Minus 10.01 dB in fraction is 0.3158639048423471 or 0.31586 which should produce a mix without clipping.",
 					},
-				},
+				}, showFlags()...),
 			},
 			{
 				Name:    "parse",
 				Aliases: []string{"p"},
 				Usage:   "Parse Go template using specification yaml",
-				Action:  parser,
-				Flags: []cli.Flag{
+				Action:  showAction((*Show).parser),
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "spec",
 						Aliases: []string{"s"},
@@ -170,12 +168,7 @@ func main() {
 					// 	Value:   defaultPrivate,
 					// 	Usage:   "Secondary configuration file that can be used in the template (usually not publicly checked in)",
 					// },
-					// &cli.StringFlag{
-					// 	Name:    "template",
-					// 	Aliases: []string{"t"},
-					// 	Value:   defaultTemplate,
-					// 	Usage:   "File to use as the Go template to render the atom rss+xml output",
-					// },
+					templateFlag(),
 					&cli.StringFlag{
 						Name:    "atom",
 						Aliases: []string{"o"},
@@ -188,6 +181,11 @@ func main() {
 						Value:   false,
 						Usage:   fmt.Sprintf("Upload %s to \"output\" Amazon AWS S3 bucket defined in %s", defaultPodcastRSS, defaultSpec),
 					},
+					&cli.BoolFlag{
+						Name:  "staging",
+						Value: false,
+						Usage: "Generate the staging feed (including staged episodes regardless of pubDate) and upload it to config.staging",
+					},
 					&cli.BoolFlag{
 						Name:    "force",
 						Aliases: []string{"f"},
@@ -200,14 +198,132 @@ func main() {
 						Value:   false,
 						Usage:   fmt.Sprintf("Behaves like the force option without modifying or producing anything. Will output %s to stdout instead of file", defaultPodcastRSS),
 					},
-				},
+				}, showFlags()...),
+			},
+			{
+				Name:   "new",
+				Usage:  "Add an episode to the spec, interactively or from flags",
+				Action: showAction((*Show).newEpisode),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.StringFlag{
+						Name:    "title",
+						Aliases: []string{"t"},
+						Usage:   "Title of the episode, adds the episode from the flags without asking",
+					},
+					&cli.StringFlag{
+						Name:  "subtitle",
+						Usage: "Subtitle of the episode",
+					},
+					&cli.StringFlag{
+						Name:    "description",
+						Aliases: []string{"d"},
+						Usage:   "Description of the episode in Markdown",
+					},
+					&cli.StringFlag{
+						Name:  "pub-date",
+						Value: "now",
+						Usage: "pubDate as RFC1123Z, 2006-01-02 15:04, 2006-01-02 (local time) or now",
+					},
+					&cli.StringFlag{
+						Name:    "input",
+						Aliases: []string{"i"},
+						Usage:   "Master of the episode, the key in the input bucket and path relative to localStorageDir",
+					},
+					&cli.StringFlag{
+						Name:  "image",
+						Usage: "Image of the episode",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Value: autoFormat,
+						Usage: "Format of the episode (" + strings.Join(episodeFormats, ", ") + ")",
+					},
+					&cli.Int64Flag{
+						Name:  "uid",
+						Usage: "UID of the episode instead of the next free UID",
+					},
+					&cli.BoolFlag{
+						Name:    "upload",
+						Aliases: []string{"u"},
+						Value:   false,
+						Usage:   "Upload the master from localStorageDir to the input bucket",
+					},
+				}, showFlags()...),
+			},
+			{
+				Name:   "ls",
+				Usage:  "List the episodes with their state, format, duration, size and whether they are encoded",
+				Action: showAction((*Show).lister),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.BoolFlag{
+						Name:  "unencoded",
+						Value: false,
+						Usage: "Only list episodes without an output file",
+					},
+					&cli.BoolFlag{
+						Name:  "scheduled",
+						Value: false,
+						Usage: "Only list episodes with a pubDate in the future",
+					},
+					&cli.BoolFlag{
+						Name:  "published",
+						Value: false,
+						Usage: "Only list episodes in the production feed",
+					},
+					&cli.BoolFlag{
+						Name:  "staged",
+						Value: false,
+						Usage: "Only list staged episodes",
+					},
+					&cli.IntFlag{
+						Name:  "season",
+						Usage: "Only list episodes of season",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Value: false,
+						Usage: "Output the episodes as json",
+					},
+				}, showFlags()...),
+			},
+			{
+				Name:      "show",
+				Aliases:   []string{"episode", "info"},
+				Usage:     "Print one episode in full with its chapters and enclosure URL",
+				ArgsUsage: "<uid>",
+				Action:    showAction((*Show).showEpisode),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Value: false,
+						Usage: "Output the episode as json",
+					},
+				}, showFlags()...),
 			},
 			{
 				Name:    "encode",
 				Aliases: []string{"e"},
 				Usage:   fmt.Sprintf("Encode and upload single or all output files in %s", defaultSpec),
-				Action:  encoder,
-				Flags: []cli.Flag{
+				Action:  showAction((*Show).encoder),
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "spec",
 						Aliases: []string{"s"},
@@ -226,26 +342,329 @@ func main() {
 					// 	Value:   defaultPrivate,
 					// 	Usage:   "Secondary configuration file that can be used in the template (usually not publicly checked in)",
 					// },
-					// &cli.StringFlag{
-					// 	Name:    "template",
-					// 	Aliases: []string{"t"},
-					// 	Value:   defaultTemplate,
-					// 	Usage:   "File to use as the Go template to render the atom rss+xml output",
-					// },
+					templateFlag(),
 					&cli.BoolFlag{
 						Name:    "all",
 						Aliases: []string{"a"},
 						Value:   false,
 						Usage:   "Encode any episode with an empty output filename, missing duration or missing length",
 					},
+					&cli.BoolFlag{
+						Name:  "production",
+						Value: false,
+						Usage: "Upload straight to the output bucket even if config.staging is configured",
+					},
 					&cli.BoolFlag{
 						Name:    "force",
 						Aliases: []string{"f"},
 						Value:   false,
 						Usage:   fmt.Sprintf("Do not ask whether to re-encode, just do it. Combined with the the \"all\" flag, all episodes in %s will be re-encoded", defaultSpec),
 					},
+				}, showFlags()...),
+			},
+			{
+				Name:      "retag",
+				Usage:     "Rewrite metadata and chapters of already encoded output files without re-encoding",
+				ArgsUsage: "[uid...]",
+				Action:    showAction((*Show).retagger),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.BoolFlag{
+						Name:    "all",
+						Aliases: []string{"a"},
+						Value:   false,
+						Usage:   "Retag every episode that has an output file",
+					},
+					&cli.BoolFlag{
+						Name:    "force",
+						Aliases: []string{"f"},
+						Value:   false,
+						Usage:   "Do not ask whether to retag and upload, just do it",
+					},
+				}, showFlags()...),
+			},
+			{
+				Name:      "promote",
+				Usage:     "Copy staged episodes to the output bucket and publish the production feed",
+				ArgsUsage: "[uid...]",
+				Action:    showAction((*Show).promoter),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.BoolFlag{
+						Name:    "all",
+						Aliases: []string{"a"},
+						Value:   false,
+						Usage:   "Promote every staged episode",
+					},
+					&cli.BoolFlag{
+						Name:  "keep-staged",
+						Value: false,
+						Usage: "Do not remove the staged objects after promotion",
+					},
+					&cli.BoolFlag{
+						Name:    "force",
+						Aliases: []string{"f"},
+						Value:   false,
+						Usage:   "Do not ask whether to promote, just do it",
+					},
+				}, showFlags()...),
+			},
+			{
+				Name:   "serve-schedule",
+				Usage:  "Run until stopped, publishing the feed each time a future-dated episode reaches its pubDate",
+				Action: serveSchedules,
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.DurationFlag{
+						Name:  "poll",
+						Value: defaultSchedulePoll,
+						Usage: "How often to check if the spec has changed",
+					},
+					&cli.StringFlag{
+						Name:  "notify-command",
+						Usage: "Shell command to run after a scheduled episode has been published",
+					},
+				}, showFlags()...),
+			},
+			{
+				Name:      "import",
+				Usage:     "Generate a spec from the rss feed of an existing podcast",
+				ArgsUsage: "<feed-url-or-file>",
+				Action:    showAction((*Show).importer),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Configuration file to write",
+					},
+					&cli.BoolFlag{
+						Name:    "download",
+						Aliases: []string{"d"},
+						Value:   false,
+						Usage:   "Download enclosures and artwork into localStorageDir as masters",
+					},
+					&cli.StringFlag{
+						Name:    "local-storage-dir",
+						Aliases: []string{"l"},
+						Value:   ".",
+						Usage:   "localStorageDir of the generated spec",
+					},
+					&cli.BoolFlag{
+						Name:    "force",
+						Aliases: []string{"f"},
+						Value:   false,
+						Usage:   "Overwrite an existing spec without asking",
+					},
+				}, showFlags()...),
+			},
+			{
+				Name:  "templates",
+				Usage: "Manage the rss and command templates",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "dump",
+						Usage:  "Write the embedded default templates to files for editing",
+						Action: templatesDump,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "dir",
+								Aliases: []string{"d"},
+								Value:   "templates",
+								Usage:   "Directory to write the templates to",
+							},
+							&cli.BoolFlag{
+								Name:    "force",
+								Aliases: []string{"f"},
+								Value:   false,
+								Usage:   "Overwrite existing files without asking",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "migrate",
+				Usage: "Migrate the layout of the spec",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "split",
+						Usage:  "Move the episodes of the spec into a file each in episodesDir",
+						Action: showAction((*Show).migrateSplit),
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{
+								Name:    "spec",
+								Aliases: []string{"s"},
+								Value:   defaultSpec,
+								Usage:   "Main configuration file for generating the atom RSS",
+							},
+							&cli.StringFlag{
+								Name:    "dir",
+								Aliases: []string{"d"},
+								Value:   defaultEpisodesDir,
+								Usage:   "Directory (relative to the spec) to write the episode files to, episodesDir if set",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Value: episodeFormatMarkdown,
+								Usage: "Format of the episode files, md (yaml front matter and the description as Markdown) or yaml",
+							},
+							&cli.BoolFlag{
+								Name:    "force",
+								Aliases: []string{"f"},
+								Value:   false,
+								Usage:   "Overwrite existing files without asking",
+							},
+						}, showFlags()...),
+					},
 				},
 			},
+			{
+				Name:   "config",
+				Usage:  "Print the effective config settings and where each one came from",
+				Action: showAction((*Show).configShow),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Value: false,
+						Usage: "Output the settings as json",
+					},
+				}, showFlags()...),
+			},
+			{
+				Name:   "schema",
+				Usage:  "Print the JSON Schema of the spec for editors to autocomplete and validate it",
+				Action: schema,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "type",
+						Aliases: []string{"t"},
+						Value:   schemaTypeSpec,
+						Usage:   "Schema of the spec or of an episode file (" + schemaTypeSpec + " or " + schemaTypeEpisode + ")",
+					},
+				},
+			},
+			{
+				Name:   "site",
+				Usage:  "Render a static website with an index, archive and one page per published episode",
+				Action: showAction((*Show).siter),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.StringFlag{
+						Name:    "output-dir",
+						Aliases: []string{"o"},
+						Value:   defaultSiteOutputDir,
+						Usage:   "Directory to render the site into (overrides config.site.outputDir)",
+					},
+					&cli.StringFlag{
+						Name:    "templates",
+						Aliases: []string{"t"},
+						Usage:   "Directory with templates overriding the embedded ones (overrides config.site.templates)",
+					},
+					&cli.BoolFlag{
+						Name:    "upload",
+						Aliases: []string{"u"},
+						Value:   false,
+						Usage:   "Upload the site to the \"output\" bucket under config.site.prefix",
+					},
+					&cli.BoolFlag{
+						Name:    "force",
+						Aliases: []string{"f"},
+						Value:   false,
+						Usage:   "Do not ask whether to upload, just do it",
+					},
+				}, showFlags()...),
+			},
+			{
+				Name:   "status",
+				Usage:  fmt.Sprintf("Compare the input and output buckets with %s and report drift", defaultSpec),
+				Action: showAction((*Show).status),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   "table",
+						Usage:   "Output format, table or json",
+					},
+					&cli.BoolFlag{
+						Name:  "exit-code",
+						Value: false,
+						Usage: "Exit with status 1 if any drift is found (e.g for a nightly job)",
+					},
+				}, showFlags()...),
+			},
+			{
+				Name:   "prune",
+				Usage:  fmt.Sprintf("Remove objects in the output bucket and files in localStorageDir not referenced by %s", defaultSpec),
+				Action: showAction((*Show).pruner),
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "spec",
+						Aliases: []string{"s"},
+						Value:   defaultSpec,
+						Usage:   "Main configuration file for generating the atom RSS",
+					},
+					&cli.StringSliceFlag{
+						Name:    "keep",
+						Aliases: []string{"k"},
+						Usage:   "Glob pattern of keys or local files to never remove (in addition to config.pruneKeep), can be repeated",
+					},
+					&cli.BoolFlag{
+						Name:  "local-only",
+						Value: false,
+						Usage: "Only prune files in localStorageDir",
+					},
+					&cli.BoolFlag{
+						Name:  "remote-only",
+						Value: false,
+						Usage: "Only prune objects in the output bucket",
+					},
+					&cli.BoolFlag{
+						Name:    "force",
+						Aliases: []string{"f"},
+						Value:   false,
+						Usage:   "Do not ask whether to remove, just do it",
+					},
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Aliases: []string{"n"},
+						Value:   false,
+						Usage:   "Only list what would be removed",
+					},
+				}, showFlags()...),
+			},
 		},
 	}
 	err := app.Run(os.Args)
@@ -254,27 +673,24 @@ func main() {
 	}
 }
 
-func preprocess(c *cli.Context) error {
+func (s *Show) preprocess(c *cli.Context) error {
 	var err error
 
-	// specFile = c.String("spec")
-	// err = loadConfig()
-	// if err != nil {
-	// 	return err
-	// }
-
-	funcMap := template.FuncMap{
-		"escape": func(s string) string {
-			return shellescape.Quote(s)
-		},
+	// The spec is optional, it is only loaded for its templates section.
+	if _, err := os.Stat(s.SpecFile); err == nil {
+		if err := s.loadConfig(); err != nil {
+			return err
+		}
 	}
 
+	funcMap := commandFuncMap()
+
 	if c.Args().Len() == 0 {
 		log.Fatal("You need to specify at least one audiofile as argument(s) to this command")
 	}
 
 	// Parse Go template
-	templates.FFmpegPreProcessing, err = template.New("ffmpegPreProcessing").Funcs(funcMap).Parse(ffmpegPreProcessingCommandTemplate)
+	s.templates.FFmpegPreProcessing, err = template.New("ffmpegPreProcessing").Funcs(funcMap).Parse(s.ffmpegPreProcessingCommandTemplate)
 	if err != nil {
 		return err
 	}
@@ -289,7 +705,7 @@ func preprocess(c *cli.Context) error {
 			},
 		}
 		buf := &bytes.Buffer{}
-		err = templates.FFmpegPreProcessing.Execute(buf, combined)
+		err = s.templates.FFmpegPreProcessing.Execute(buf, combined)
 		if err != nil {
 			return err
 		}
@@ -306,243 +722,224 @@ func preprocess(c *cli.Context) error {
 	return nil
 }
 
-func parser(c *cli.Context) error {
+func (s *Show) parser(c *cli.Context) error {
 	var err error
 
-	specFile = c.String("spec")
 	//privateFile = c.String("private")
-	//templateFile = c.String("template")
-	askNoQuestions = c.Bool("force")
-	dryRun = c.Bool("dry-run")
+	s.opts.Force = c.Bool("force")
+	s.opts.DryRun = c.Bool("dry-run")
 
-	err = loadConfig()
+	unlock, err := s.lockSpec()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	if c.Bool("upload") {
-		log.Printf("About to generate %s and upload to S3 bucket %s", atom.Atom, atom.Config.Aws.Buckets.Output)
-	} else {
-		log.Printf("About to generate %s", atom.Atom)
+	err = s.loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := s.setTemplateFlags(c.StringSlice("template")); err != nil {
+		return err
+	}
+	err = s.validateStaging()
+	if err != nil {
+		return err
 	}
 
-	funcMap := template.FuncMap{
-		"escape": func(s string) string {
-			return shellescape.Quote(s)
-		},
-		"timeNow": func() time.Time {
-			return time.Now()
-		},
-		"isAfter": func(t1 time.Time, t2 time.Time) bool {
-			if t1.IsZero() || t2.IsZero() {
-				return false
-			}
-			return (t1 == t2 || t1.After(t2))
-		},
-		"markdown": func(s string) string {
-			return MarkdownToHTML(s)
-		},
-		"spotifyChapters": func(chapters []id3v24.Chapter) string {
-			var output string
-			chaps := SpotifyChapters(chapters)
-			if len([]rune(chaps)) > 0 {
-				output = "\n<pre>\n"
-				output += chaps
-				output += "</pre>\n"
-			}
-			return output
-		},
+	target := s.Atom.ProductionTarget()
+	if c.Bool("staging") {
+		if !s.Atom.Config.StagingEnabled() {
+			return fmt.Errorf("config.staging is not configured in %s", s.SpecFile)
+		}
+		target = s.Atom.StagingTarget()
 	}
+	feedFile := s.localFeedFile(target)
+	feedKey := target.Key(s.Atom.Atom)
 
-	//t, err := template.ParseFiles(templateFile)
-	t, err := template.New("template.rss").Funcs(funcMap).Parse(rssTemplate)
+	if c.Bool("upload") {
+		log.Printf("About to generate %s and upload to s3://%s", feedFile, path.Join(target.Bucket, feedKey))
+	} else {
+		log.Printf("About to generate %s", feedFile)
+	}
+
+	t, err := s.feedTemplate(target)
 	if err != nil {
 		return err
 	}
 
 	// We need an AWS session and localStorageDir prior to calling validateAtom().
-	awsHandler.NewSession()
-	err = createLocalStorageDir()
+	s.Aws.NewSession()
+	err = s.createLocalStorageDir()
 	if err != nil {
 		return err
 	}
-	err = validateAtom()
+	err = s.validateAtom()
 	if err != nil {
 		return err
 	}
 
-	if doAction("Refresh lastBuildDate (will update %s and optionally %s)?", atom.Atom, specFile) {
-		atom.LastBuildDate.Time = time.Now().UTC()
-		updateAtom = true
+	if s.opts.doAction("Refresh lastBuildDate (will update %s and optionally %s)?", s.Atom.Atom, s.SpecFile) {
+		s.Atom.LastBuildDate.Time = time.Now().UTC()
+		s.updateAtom = true
 	}
 
-	if updateAtom {
-		if doAction("Fields in the atom has changed, re-write %s?", specFile) {
-			atom.LastBuildDate.Time = time.Now().UTC()
-			f, err := os.Create(specFile)
-			if err != nil {
-				return fmt.Errorf("unable to re-write %s: %w", specFile, err)
-			}
-			defer f.Close()
-			b, err := atom.Yaml()
-			if err != nil {
-				return fmt.Errorf("unable to marshall yaml: %w", err)
-			}
-			_, err = f.Write(b)
-			if err != nil {
-				return fmt.Errorf("unable to re-write %s: %w", specFile, err)
-			}
-		}
+	if err := s.rewriteSpec(); err != nil {
+		return err
 	}
 
 	switch {
-	case dryRun && isTerminal() && yes("Write %s to stdout?", atom.Atom):
+	case s.opts.DryRun && isTerminal() && yes("Write %s to stdout?", feedFile):
 		fallthrough
-	case dryRun && !isTerminal():
+	case s.opts.DryRun && !isTerminal():
 		fallthrough
-	case !dryRun:
+	case !s.opts.DryRun:
 		f := os.Stdout
-		if !dryRun {
-			f, err = os.Create(atom.Atom)
+		if !s.opts.DryRun {
+			f, err = os.Create(feedFile)
 			if err != nil {
 				return err
 			}
 		}
 		//log.Printf("Parsing template %s to %s", templateFile, f.Name())
 		log.Printf("Parsing rss template to %s", f.Name())
-		err = t.Execute(f, Combined{Atom: &atom})
+		err = t.Execute(f, Combined{Atom: &s.Atom})
 		if err != nil {
-			if !dryRun {
+			if !s.opts.DryRun {
 				f.Close()
 			}
 			return err
 		}
-		if !dryRun {
+		if !s.opts.DryRun {
 			err = f.Close()
 			if err != nil {
 				return err
 			}
 		}
-		log.Printf("Successfully generated %s", atom.Atom)
+		log.Printf("Successfully generated %s", feedFile)
+		if !s.opts.DryRun {
+			if err := s.writeAlternateFeeds(target); err != nil {
+				return err
+			}
+		}
+		s.runHooks(EventFeedRendered, target, nil)
 	}
 
-	if err := awsHandler.Diff(atom.Config.Aws.Buckets.Output, atom.Atom, atom.Atom); err != nil {
+	if err := s.Aws.Diff(target.Bucket, feedKey, feedFile); err != nil {
 		return err
 	}
 
 	// Upload atom file to output S3 bucket.
 	if c.Bool("upload") {
-		if doAction("Upload new %s?", atom.Atom) {
-			if !dryRun {
-				err = awsHandler.Upload(atom.Config.Aws.Buckets.Output, atom.Atom, "text/xml", atom.Atom)
+		if s.opts.doAction("Upload new %s?", feedFile) {
+			if !s.opts.DryRun {
+				err = s.uploadAlternateFeeds(target)
+				if err != nil {
+					return err
+				}
+				err = s.uploadFeed(target, feedFile)
 				if err != nil {
 					return err
 				}
 			} else {
-				log.Printf("Uploading %s to s3://%s", atom.Atom, path.Join(atom.Config.Aws.Buckets.Output, atom.Atom))
+				log.Printf("Uploading %s to s3://%s", feedFile, path.Join(target.Bucket, feedKey))
 			}
 		}
 	}
 	return nil
 }
 
-func encoder(c *cli.Context) error {
+func (s *Show) encoder(c *cli.Context) error {
 	var err error
 
 	if c.Args().Len() == 0 && !c.Bool("all") {
 		log.Fatal("You need to select one or several episode UIDs to encode as argument(s) to this command or use the all-option -a")
 	}
 
-	specFile = c.String("spec")
 	//privateFile = c.String("private")
-	//templateFile = c.String("template")
-	askNoQuestions = c.Bool("force")
-	removeRemoteMasterFile = c.Bool("remove-remote-master")
+	s.opts.Force = c.Bool("force")
+	s.opts.RemoveRemoteMaster = c.Bool("remove-remote-master")
 
-	err = loadConfig()
+	unlock, err := s.lockSpec()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	awsHandler.NewSession()
-
-	err = createLocalStorageDir()
+	err = s.loadConfig()
 	if err != nil {
 		return err
 	}
-	err = basicAtomValidation()
+	if err := s.setTemplateFlags(c.StringSlice("template")); err != nil {
+		return err
+	}
+	err = s.validateStaging()
 	if err != nil {
 		return err
 	}
+	s.opts.ToStaging = s.Atom.Config.StagingEnabled() && !c.Bool("production")
 
-	// Go template FuncMap, add escape function using
-	// gopkg.in/alessio/shellescape.v1.
-	funcMap := template.FuncMap{
-		"escape": func(s string) string {
-			return shellescape.Quote(s)
-		},
-		"markdown": func(s string) string {
-			return MarkdownToHTML(s)
-		},
+	s.Aws.NewSession()
+
+	err = s.createLocalStorageDir()
+	if err != nil {
+		return err
+	}
+	err = s.basicAtomValidation()
+	if err != nil {
+		return err
+	}
+	// Report on masters requested to be restored from archive by a
+	// previous run.
+	err = s.logPendingRestores()
+	if err != nil {
+		return err
 	}
 
+	funcMap := commandFuncMap()
+
 	// Parse Go templates (except the pre-processing command template)
-	templates.Lame, err = template.New("lame").Funcs(funcMap).Parse(lameCommandTemplate)
+	s.templates.Lame, err = template.New("lame").Funcs(funcMap).Parse(s.lameCommandTemplate)
 	if err != nil {
 		return err
 	}
-	templates.FFmpeg, err = template.New("ffmpeg").Funcs(funcMap).Parse(ffmpegCommandTemplate)
+	s.templates.FFmpeg, err = template.New("ffmpeg").Funcs(funcMap).Parse(s.ffmpegCommandTemplate)
 	if err != nil {
 		return err
 	}
-	templates.FFmpegToLame, err = template.New("ffmpegToLame").Funcs(funcMap).Parse(ffmpegToAudioCommandTemplate)
+	s.templates.FFmpegToLame, err = template.New("ffmpegToLame").Funcs(funcMap).Parse(s.ffmpegToAudioCommandTemplate)
 	if err != nil {
 		return err
 	}
-	templates.FFmpegM4A, err = template.New("ffmpegM4A").Funcs(funcMap).Parse(ffmpegToM4ACommandTemplate)
+	s.templates.FFmpegM4A, err = template.New("ffmpegM4A").Funcs(funcMap).Parse(s.ffmpegToM4ACommandTemplate)
 	if err != nil {
 		return err
 	}
 
 	if c.Bool("all") {
-		err = processAllEpisodes(templates, askNoQuestions)
+		err = s.processAllEpisodes(s.templates, s.opts.Force)
 		if err != nil {
 			return err
 		}
 	} else {
-		err = processEpisodes(templates, c.Args().Slice(), askNoQuestions)
+		err = s.processEpisodes(s.templates, c.Args().Slice(), s.opts.Force)
 		if err != nil {
 			return err
 		}
 	}
 
-	if processCounter == 0 {
+	if s.processCounter == 0 {
 		log.Printf("No episode was processed")
 	} else {
 		plural := ""
-		if processCounter > 1 {
+		if s.processCounter > 1 {
 			plural = "s"
 		}
-		log.Printf("Processed %d episode%s", processCounter, plural)
+		log.Printf("Processed %d episode%s", s.processCounter, plural)
 	}
 
-	if updateAtom {
-		if doAction("Fields in the atom has changed, re-write %s?", specFile) {
-			atom.LastBuildDate.Time = time.Now().UTC()
-			f, err := os.Create(specFile)
-			if err != nil {
-				return fmt.Errorf("unable to re-write %s: %w", specFile, err)
-			}
-			defer f.Close()
-			b, err := atom.Yaml()
-			if err != nil {
-				return fmt.Errorf("unable to marshall yaml: %w", err)
-			}
-			_, err = f.Write(b)
-			if err != nil {
-				return fmt.Errorf("unable to re-write %s: %w", specFile, err)
-			}
-		}
+	if err := s.rewriteSpec(); err != nil {
+		return err
 	}
 	return nil
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/urfave/cli/v2"
+)
+
+// Episodes are added with mkpod new, interactively by answering a survey
+// (the description is written in $EDITOR) or from flags when --title is
+// given. The episode gets the next free uid and is inserted at the top
+// of the episodes in the spec, or written to a file of its own in
+// episodesDir if the spec has one.
+
+const autoFormat string = "auto"
+
+// episodeFormats are the values of the format field of an episode, auto
+// (empty) decides from the content type of the master.
+var episodeFormats = []string{autoFormat, "audio", "video", "mp3", "mp4", "m4a", "m4b"}
+
+// newEpisodeAnswers are the fields of a new episode as entered.
+type newEpisodeAnswers struct {
+	Title       string
+	Subtitle    string
+	Description string
+	PubDate     string
+	Input       string
+	Image       string
+	Format      string
+}
+
+// NextUID returns the uid following the highest uid in the atom.
+func (a *Atom) NextUID() int64 {
+	uid := int64(1)
+	for _, e := range a.Episodes {
+		if e.UID >= uid {
+			uid = e.UID + 1
+		}
+	}
+	return uid
+}
+
+// parsePubDate parses a publication date as RFC1123Z (as in the spec),
+// 2006-01-02 15:04 or 2006-01-02 in local time, or now if empty or
+// "now".
+func parsePubDate(str string) (ItunesTime, error) {
+	str = strings.TrimSpace(str)
+	if str == "" || strings.EqualFold(str, "now") {
+		return ItunesTime{time.Now().UTC().Truncate(time.Second)}, nil
+	}
+	if t, err := time.Parse(time.RFC1123Z, str); err == nil {
+		return ItunesTime{t}, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return ItunesTime{t}, nil
+		}
+	}
+	return ItunesTime{}, fmt.Errorf("unable to parse pubDate %q, expected %s, 2006-01-02 15:04, 2006-01-02 or now", str, time.RFC1123Z)
+}
+
+// askNewEpisode asks for the fields of a new episode, the answers given
+// as flags are the defaults.
+func askNewEpisode(answers *newEpisodeAnswers) error {
+	format := answers.Format
+	if format == "" {
+		format = autoFormat
+	}
+	questions := []*survey.Question{
+		{
+			Name:     "title",
+			Prompt:   &survey.Input{Message: "Title:", Default: answers.Title},
+			Validate: survey.Required,
+		},
+		{
+			Name:   "subtitle",
+			Prompt: &survey.Input{Message: "Subtitle:", Default: answers.Subtitle},
+		},
+		{
+			Name: "description",
+			Prompt: &survey.Editor{
+				Message:       "Description (Markdown):",
+				Default:       answers.Description,
+				HideDefault:   true,
+				AppendDefault: true,
+				FileName:      "*.md",
+			},
+		},
+		{
+			Name: "pubDate",
+			Prompt: &survey.Input{
+				Message: "Publication date:",
+				Default: answers.PubDate,
+				Help:    "RFC1123Z (" + time.RFC1123Z + "), 2006-01-02 15:04, 2006-01-02 or now, a future date schedules the episode",
+			},
+			Validate: func(ans interface{}) error {
+				_, err := parsePubDate(fmt.Sprint(ans))
+				return err
+			},
+		},
+		{
+			Name: "input",
+			Prompt: &survey.Input{
+				Message: "Master:",
+				Default: answers.Input,
+				Help:    "Key of the master in the input bucket, also its path relative to localStorageDir",
+			},
+		},
+		{
+			Name:   "image",
+			Prompt: &survey.Input{Message: "Image:", Default: answers.Image},
+		},
+		{
+			Name:   "format",
+			Prompt: &survey.Select{Message: "Format:", Options: episodeFormats, Default: format},
+		},
+	}
+	return survey.Ask(questions, answers)
+}
+
+// newEpisode adds an episode from the answers to the survey or the flags.
+func (s *Show) newEpisode(c *cli.Context) error {
+	interactive := !c.IsSet("title")
+	if interactive && !isTerminal() {
+		return errors.New("stdout is not a terminal, give the episode with --title and the other flags")
+	}
+
+	unlock, err := s.lockSpec()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+
+	uid := s.Atom.NextUID()
+	if c.IsSet("uid") {
+		uid = c.Int64("uid")
+		if s.Atom.ContainsEpisode(uid) >= 0 {
+			return fmt.Errorf("there is already an episode with UID %d in %s", uid, s.SpecFile)
+		}
+	}
+
+	answers := newEpisodeAnswers{
+		Title:       c.String("title"),
+		Subtitle:    c.String("subtitle"),
+		Description: c.String("description"),
+		PubDate:     c.String("pub-date"),
+		Input:       c.String("input"),
+		Image:       c.String("image"),
+		Format:      c.String("format"),
+	}
+	if interactive {
+		log.Printf("New episode with UID %d in %s", uid, s.SpecFile)
+		if err := askNewEpisode(&answers); err != nil {
+			return err
+		}
+	}
+
+	episode, err := s.newEpisodeFrom(uid, answers)
+	if err != nil {
+		return err
+	}
+	if err := s.addEpisode(episode); err != nil {
+		return err
+	}
+
+	if episode.Input == "" {
+		return nil
+	}
+	if c.Bool("upload") || (interactive && !c.IsSet("upload") && yes("Upload master %s to s3://%s?", episode.Input, path.Join(s.Atom.Config.Aws.Buckets.Input, episode.Input))) {
+		return s.uploadMaster(episode.Input)
+	}
+	return nil
+}
+
+// newEpisodeFrom returns the episode with uid from answers.
+func (s *Show) newEpisodeFrom(uid int64, answers newEpisodeAnswers) (Episode, error) {
+	title := strings.TrimSpace(answers.Title)
+	if title == "" {
+		return Episode{}, errors.New("the episode needs a title")
+	}
+	format := strings.ToLower(strings.TrimSpace(answers.Format))
+	valid := false
+	for _, f := range episodeFormats {
+		if format == f {
+			valid = true
+		}
+	}
+	if format != "" && !valid {
+		return Episode{}, fmt.Errorf("unknown format %q, expected one of %s", answers.Format, strings.Join(episodeFormats, ", "))
+	}
+	if format == autoFormat {
+		format = ""
+	}
+	pubDate, err := parsePubDate(answers.PubDate)
+	if err != nil {
+		return Episode{}, err
+	}
+	return Episode{
+		UID:         uid,
+		Title:       title,
+		PubDate:     pubDate,
+		Author:      s.Atom.Author,
+		Subtitle:    strings.TrimSpace(answers.Subtitle),
+		Description: strings.TrimSpace(answers.Description),
+		Input:       strings.TrimSpace(answers.Input),
+		Image:       strings.TrimSpace(answers.Image),
+		Format:      format,
+	}, nil
+}
+
+// addEpisode inserts episode at the top of the episodes of the spec and
+// writes the spec, or writes it to a file in episodesDir if set.
+func (s *Show) addEpisode(episode Episode) error {
+	if dir := strings.TrimSpace(s.Atom.EpisodesDir); dir != "" {
+		file := filepath.Join(s.specRelative(dir), s.Atom.episodeFileName(&episode, episodeFormatMarkdown))
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("%s already exists, not adding episode %d", file, episode.UID)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		b, err := newEpisodeFile(&episode, episodeFormatMarkdown)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(file, b, 0644); err != nil {
+			return err
+		}
+		log.Printf("Wrote episode %d to %s", episode.UID, file)
+		return nil
+	}
+	s.Atom.Episodes = append([]Episode{episode}, s.Atom.Episodes...)
+	b, err := s.specBytes()
+	if err != nil {
+		return err
+	}
+	if err := s.writeSpec(b); err != nil {
+		return err
+	}
+	log.Printf("Added episode %d to %s", episode.UID, s.SpecFile)
+	return nil
+}
+
+// uploadMaster uploads the master key in localStorageDir to the input
+// bucket.
+func (s *Show) uploadMaster(key string) error {
+	file := path.Join(s.Atom.LocalStorageDirExpanded(), key)
+	contentType, err := GetFileContentType(file)
+	if err != nil {
+		return err
+	}
+	s.Aws.NewSession()
+	return s.Aws.Upload(ObjectKindMasters, s.Atom.Config.Aws.Buckets.Input, key, contentType, file)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func newTestContext(t *testing.T, spec string, args ...string) *cli.Context {
+	set := flag.NewFlagSet("new", flag.ContinueOnError)
+	set.String("spec", spec, "")
+	for _, name := range []string{"title", "subtitle", "description", "input", "image"} {
+		set.String(name, "", "")
+	}
+	set.String("pub-date", "now", "")
+	set.String("format", autoFormat, "")
+	set.Int64("uid", 0, "")
+	set.Bool("upload", false, "")
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func TestNewEpisode(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "podspec.yaml")
+	content := "atom: podcast.rss\nauthor: SA6MWA\nepisodes:\n# Newest first\n- uid: 7\n  title: Seven\n- uid: 3\n  title: Three\n"
+	if err := os.WriteFile(spec, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewShow("", spec)
+	if err := s.newEpisode(newTestContext(t, spec, "--title", "Eight", "--pub-date", "Wed, 23 Oct 2024 08:00:00 +0000", "--input", "masters/eight.wav", "--format", "audio", "--description", "Show *notes*.")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "atom: podcast.rss\nauthor: SA6MWA\nepisodes:\n# Newest first\n- uid: 8\n  title: Eight\n  pubDate: Wed, 23 Oct 2024 08:00:00 +0000\n") || !strings.HasSuffix(string(b), "  format: audio\n- uid: 7\n  title: Seven\n- uid: 3\n  title: Three\n") {
+		t.Errorf("expected episode 8 at the top of the episodes, got:\n%s", b)
+	}
+
+	s = NewShow("", spec)
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	e := s.Atom.Episodes[0]
+	if e.UID != 8 || e.Author != "SA6MWA" || e.Input != "masters/eight.wav" || e.Format != "audio" || e.Description != "Show *notes*." {
+		t.Errorf("unexpected episode %+v", e)
+	}
+
+	if err := NewShow("", spec).newEpisode(newTestContext(t, spec, "--title", "Again", "--uid", "3")); err == nil || !strings.Contains(err.Error(), "UID 3") {
+		t.Errorf("expected error for taken uid, got %v", err)
+	}
+	if err := NewShow("", spec).newEpisode(newTestContext(t, spec, "--title", "Bad", "--format", "ogg")); err == nil {
+		t.Error("expected error for unknown format")
+	}
+
+	// With episodesDir the episode is written to a file of its own.
+	content = "atom: podcast.rss\nepisodesDir: episodes\n"
+	if err := os.WriteFile(spec, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewShow("", spec).newEpisode(newTestContext(t, spec, "--title", "First", "--description", "Notes.")); err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(filepath.Join(dir, "episodes", "episode-1.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "---\nuid: 1\ntitle: First\n") || !strings.HasSuffix(string(b), "---\nNotes.\n") {
+		t.Errorf("unexpected episode file:\n%s", b)
+	}
+	if b, err := os.ReadFile(spec); err != nil || string(b) != content {
+		t.Errorf("expected spec to be unchanged, got:\n%s", b)
+	}
+}
+
+func TestParsePubDate(t *testing.T) {
+	for str, expected := range map[string]time.Time{
+		"Wed, 23 Oct 2024 08:00:00 +0000": time.Date(2024, 10, 23, 8, 0, 0, 0, time.UTC),
+		"2024-10-23 08:00":                time.Date(2024, 10, 23, 8, 0, 0, 0, time.Local),
+		"2024-10-23":                      time.Date(2024, 10, 23, 0, 0, 0, 0, time.Local),
+	} {
+		pubDate, err := parsePubDate(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pubDate.Equal(expected) {
+			t.Errorf("expected %s to be %s, got %s", str, expected, pubDate)
+		}
+	}
+	if pubDate, err := parsePubDate("now"); err != nil || time.Since(pubDate.Time) > time.Minute {
+		t.Errorf("expected now, got %s (%v)", pubDate, err)
+	}
+	if _, err := parsePubDate("tomorrow"); err == nil {
+		t.Error("expected error for tomorrow")
+	}
+}
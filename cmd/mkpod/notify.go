@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Notifications sent after the production feed has been uploaded with
+// new content. A WebSub (PubSubHubbub) publish ping is sent to each
+// configured hub (the hubs are also advertised in the feed as
+// <atom:link rel="hub">) and the Podping HTTP endpoint is called with
+// the feed URL. Notifications are retried with exponential backoff, a
+// notification that fails is logged but does not fail the publish.
+
+const (
+	defaultNotifyRetries int           = 3
+	defaultNotifyBackoff time.Duration = 2 * time.Second
+	defaultNotifyTimeout time.Duration = 30 * time.Second
+	notifyUserAgent      string        = "mkpod (+https://github.com/sa6mwa/mkpod)"
+)
+
+type NotifyConfig struct {
+	WebSub  WebSubConfig  `yaml:"websub,omitempty"`
+	Podping PodpingConfig `yaml:"podping,omitempty"`
+	// Number of retries of a failed notification (default 3).
+	Retries int `yaml:"retries,omitempty"`
+}
+
+type WebSubConfig struct {
+	// Hub URLs, e.g https://pubsubhubbub.appspot.com/
+	Hubs []string `yaml:"hubs,omitempty"`
+}
+
+type PodpingConfig struct {
+	// Endpoint, e.g https://podping.cloud/
+	URL string `yaml:"url,omitempty"`
+	// Sent as the Authorization header. Environment variables are
+	// expanded (e.g $PODPING_TOKEN) to keep the token out of the spec.
+	Token string `yaml:"token,omitempty"`
+	// Optional reason (update, live, liveEnd) and medium (podcast,
+	// music, video, ...).
+	Reason string `yaml:"reason,omitempty"`
+	Medium string `yaml:"medium,omitempty"`
+}
+
+// Notifier sends notifications over HTTP with retries.
+type Notifier struct {
+	Client  *http.Client
+	Retries int
+	// Delay before the first retry, doubled for every retry.
+	Backoff time.Duration
+}
+
+// NewNotifier returns a Notifier configured from cfg.
+func NewNotifier(cfg NotifyConfig) *Notifier {
+	retries := cfg.Retries
+	if retries < 1 {
+		retries = defaultNotifyRetries
+	}
+	return &Notifier{
+		Client:  &http.Client{Timeout: defaultNotifyTimeout},
+		Retries: retries,
+		Backoff: defaultNotifyBackoff,
+	}
+}
+
+// WebSubPublish notifies hub that the content of feedURL has changed.
+func (n *Notifier) WebSubPublish(hub string, feedURL string) error {
+	form := url.Values{
+		"hub.mode": {"publish"},
+		"hub.url":  {feedURL},
+	}
+	return n.do("WebSub hub "+hub, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, hub, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+}
+
+// Podping notifies the Podping endpoint of cfg that the content of
+// feedURL has changed.
+func (n *Notifier) Podping(cfg PodpingConfig, feedURL string) error {
+	endpoint, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("bad podping url: %w", err)
+	}
+	query := endpoint.Query()
+	query.Set("url", feedURL)
+	if cfg.Reason != "" {
+		query.Set("reason", cfg.Reason)
+	}
+	if cfg.Medium != "" {
+		query.Set("medium", cfg.Medium)
+	}
+	endpoint.RawQuery = query.Encode()
+	token := os.ExpandEnv(cfg.Token)
+	return n.do("Podping "+cfg.URL, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, endpoint.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		return req, nil
+	})
+}
+
+// do sends the request returned by newRequest until it succeeds (2xx),
+// fails with a status that will not change by retrying (4xx except 408
+// and 429) or the retries are exhausted.
+func (n *Notifier) do(name string, newRequest func() (*http.Request, error)) error {
+	backoff := n.Backoff
+	var err error
+	for attempt := 0; attempt <= n.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying %s in %s (%v)", name, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var req *http.Request
+		req, err = newRequest()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", notifyUserAgent)
+		var resp *http.Response
+		resp, err = n.Client.Do(req)
+		if err != nil {
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			log.Printf("Notified %s (%s)", name, resp.Status)
+			return nil
+		}
+		err = fmt.Errorf("%s responded %s: %s", name, resp.Status, strings.TrimSpace(string(body)))
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return err
+		}
+	}
+	return err
+}
+
+// notifyFeedPublished sends the configured notifications for feedURL
+// and logs the result of each.
+func (s *Show) notifyFeedPublished(feedURL string) {
+	cfg := s.Atom.Config.Notify
+	if len(cfg.WebSub.Hubs) == 0 && strings.TrimSpace(cfg.Podping.URL) == "" {
+		return
+	}
+	n := NewNotifier(cfg)
+	for _, hub := range cfg.WebSub.Hubs {
+		if err := n.WebSubPublish(hub, feedURL); err != nil {
+			log.Printf("ERROR: WebSub publish of %s failed: %v", feedURL, err)
+		}
+	}
+	if strings.TrimSpace(cfg.Podping.URL) != "" {
+		if err := n.Podping(cfg.Podping, feedURL); err != nil {
+			log.Printf("ERROR: Podping of %s failed: %v", feedURL, err)
+		}
+	}
+}
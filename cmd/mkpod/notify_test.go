@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testFeedURL = "https://mypodbucket.s3.eu-north-1.amazonaws.com/podcast.rss"
+
+func testNotifier() *Notifier {
+	return &Notifier{
+		Client:  &http.Client{Timeout: time.Second},
+		Retries: 2,
+		Backoff: time.Millisecond,
+	}
+}
+
+func TestWebSubPublish(t *testing.T) {
+	var attempts atomic.Int32
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt to exercise the retry.
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if mode := r.PostForm.Get("hub.mode"); mode != "publish" {
+			t.Errorf("expected hub.mode publish, got %q", mode)
+		}
+		if u := r.PostForm.Get("hub.url"); u != testFeedURL {
+			t.Errorf("expected hub.url %s, got %q", testFeedURL, u)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hub.Close()
+
+	if err := testNotifier().WebSubPublish(hub.URL, testFeedURL); err != nil {
+		t.Fatal(err)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+}
+
+func TestPodping(t *testing.T) {
+	t.Setenv("PODPING_TOKEN", "secret")
+	var attempts atomic.Int32
+	podping := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if auth := r.Header.Get("Authorization"); auth != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if u := r.URL.Query().Get("url"); u != testFeedURL {
+			t.Errorf("expected url %s, got %q", testFeedURL, u)
+		}
+		if reason := r.URL.Query().Get("reason"); reason != "update" {
+			t.Errorf("expected reason update, got %q", reason)
+		}
+		w.Write([]byte("Success!"))
+	}))
+	defer podping.Close()
+
+	cfg := PodpingConfig{URL: podping.URL + "/", Token: "$PODPING_TOKEN", Reason: "update"}
+	if err := testNotifier().Podping(cfg, testFeedURL); err != nil {
+		t.Fatal(err)
+	}
+
+	// Client errors are not retried.
+	attempts.Store(0)
+	cfg.Token = "wrong"
+	if err := testNotifier().Podping(cfg, testFeedURL); err == nil {
+		t.Error("expected error with wrong token")
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Upload policies set object properties (Cache-Control,
+// Content-Disposition, storage class, ACL, server-side encryption and
+// custom metadata) depending on what kind of object is uploaded. They
+// are configured under config.uploadPolicies in the spec, e.g:
+//
+//	config:
+//	  uploadPolicies:
+//	    feed:
+//	      cacheControl: max-age=300
+//	    media:
+//	      cacheControl: public, max-age=31536000, immutable
+
+// ObjectKind is the kind of object being uploaded.
+type ObjectKind string
+
+const (
+	ObjectKindFeed        ObjectKind = "feed"
+	ObjectKindMedia       ObjectKind = "media"
+	ObjectKindArtwork     ObjectKind = "artwork"
+	ObjectKindChapters    ObjectKind = "chapters"
+	ObjectKindTranscripts ObjectKind = "transcripts"
+	ObjectKindMasters     ObjectKind = "masters"
+	ObjectKindSite        ObjectKind = "site"
+
+	// Masters uploaded from localStorageDir to the input bucket are
+	// rarely read and default to Glacier Instant Retrieval.
+	defaultMastersStorageClass string = "GLACIER_IR"
+)
+
+type UploadPolicy struct {
+	CacheControl         string            `yaml:"cacheControl,omitempty"`
+	ContentDisposition   string            `yaml:"contentDisposition,omitempty"`
+	StorageClass         string            `yaml:"storageClass,omitempty"`
+	ACL                  string            `yaml:"acl,omitempty"`
+	ServerSideEncryption string            `yaml:"serverSideEncryption,omitempty"`
+	Metadata             map[string]string `yaml:"metadata,omitempty"`
+}
+
+type UploadPolicies struct {
+	Feed        UploadPolicy `yaml:"feed,omitempty"`
+	Media       UploadPolicy `yaml:"media,omitempty"`
+	Artwork     UploadPolicy `yaml:"artwork,omitempty"`
+	Chapters    UploadPolicy `yaml:"chapters,omitempty"`
+	Transcripts UploadPolicy `yaml:"transcripts,omitempty"`
+	Masters     UploadPolicy `yaml:"masters,omitempty"`
+	Site        UploadPolicy `yaml:"site,omitempty"`
+}
+
+// UploadPolicy returns the policy for kind with defaults applied.
+func (c *Config) UploadPolicy(kind ObjectKind) UploadPolicy {
+	var policy UploadPolicy
+	switch kind {
+	case ObjectKindFeed:
+		policy = c.UploadPolicies.Feed
+	case ObjectKindMedia:
+		policy = c.UploadPolicies.Media
+	case ObjectKindArtwork:
+		policy = c.UploadPolicies.Artwork
+	case ObjectKindChapters:
+		policy = c.UploadPolicies.Chapters
+	case ObjectKindTranscripts:
+		policy = c.UploadPolicies.Transcripts
+	case ObjectKindMasters:
+		policy = c.UploadPolicies.Masters
+		if strings.TrimSpace(policy.StorageClass) == "" {
+			policy.StorageClass = defaultMastersStorageClass
+		}
+	case ObjectKindSite:
+		policy = c.UploadPolicies.Site
+	}
+	return policy
+}
+
+// ObjectMetadata returns the custom metadata of the policy together
+// with the SHA-256 used for content comparison.
+func (p UploadPolicy) ObjectMetadata(sha256sum string) map[string]*string {
+	metadata := make(map[string]*string)
+	for k, v := range p.Metadata {
+		metadata[k] = aws.String(v)
+	}
+	metadata[sha256MetadataKey] = aws.String(sha256sum)
+	return metadata
+}
+
+// Differences returns a description of each property of remote that
+// does not match the policy. Only properties set in the policy are
+// compared as buckets may apply default encryption or lifecycle rules
+// may transition the storage class. The ACL can not be read with
+// HeadObject and is not compared.
+func (p UploadPolicy) Differences(remote *RemoteObject) []string {
+	var diffs []string
+	compare := func(name, expected, got string) {
+		if expected != "" && expected != got {
+			diffs = append(diffs, fmt.Sprintf("%s is %q, expected %q", name, got, expected))
+		}
+	}
+	compare("Cache-Control", p.CacheControl, remote.CacheControl)
+	compare("Content-Disposition", p.ContentDisposition, remote.ContentDisposition)
+	compare("server-side encryption", p.ServerSideEncryption, remote.ServerSideEncryption)
+	// HeadObject omits the storage class of STANDARD objects.
+	remoteStorageClass := remote.StorageClass
+	if remoteStorageClass == "" {
+		remoteStorageClass = "STANDARD"
+	}
+	compare("storage class", p.StorageClass, remoteStorageClass)
+	var keys []string
+	for k := range p.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		got := ""
+		for rk, rv := range remote.Metadata {
+			if strings.EqualFold(rk, k) {
+				got = rv
+			}
+		}
+		compare("metadata "+k, p.Metadata[k], got)
+	}
+	return diffs
+}
+
+// optionalString returns nil for an empty string, the SDK would
+// otherwise send an empty header.
+func optionalString(s string) *string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return aws.String(s)
+}
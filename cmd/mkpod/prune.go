@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+// The prune command removes objects in the output bucket and files in
+// localStorageDir that are no longer referenced by the spec, e.g the
+// old mp3 after an episode has been re-encoded to m4a. The files mkpod
+// keeps next to the spec and the rendered feeds and site are never
+// pruned, and localStorageDir is not pruned at all if the spec is in it.
+// Shows in a workspace sharing localStorageDir or the output bucket keep
+// what any of them references, nothing is pruned if one of them does
+// not load.
+
+// PruneCandidate is an unreferenced object or local file.
+type PruneCandidate struct {
+	Key  string
+	Size int64
+}
+
+func (s *Show) pruner(c *cli.Context) error {
+	s.opts.Force = c.Bool("force")
+	s.opts.DryRun = c.Bool("dry-run")
+
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+	s.Aws.NewSession()
+
+	keep := append(append([]string{}, s.Atom.Config.PruneKeep...), c.StringSlice("keep")...)
+	for _, pattern := range keep {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad keep pattern %q: %w", pattern, err)
+		}
+	}
+
+	if !c.Bool("remote-only") {
+		if err := s.checkLocalPrune(); err != nil {
+			return err
+		}
+	}
+	if !c.Bool("local-only") {
+		if err := s.pruneOutputBucket(keep); err != nil {
+			return err
+		}
+		for _, bucket := range []string{s.Atom.Config.Aws.Buckets.Input, s.Atom.Config.Aws.Buckets.Output} {
+			if err := s.pruneIncompleteUploads(bucket); err != nil {
+				return err
+			}
+		}
+	}
+	if !c.Bool("remote-only") {
+		if err := s.pruneLocalStorageDir(keep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneOutputBucket lists the output bucket and removes every object
+// not referenced by the atom (or another show with the same output
+// bucket) or matched by keep.
+func (s *Show) pruneOutputBucket(keep []string) error {
+	bucket := s.Atom.Config.Aws.Buckets.Output
+	referenced, err := s.referencedOutputKeys()
+	if err != nil {
+		return err
+	}
+	log.Printf("Listing s3://%s", bucket)
+	objects, err := s.Aws.List(bucket)
+	if err != nil {
+		return err
+	}
+	var candidates []PruneCandidate
+	for key, o := range objects {
+		if !referenced[key] && !keepMatch(keep, key) {
+			candidates = append(candidates, PruneCandidate{Key: key, Size: o.Size})
+		}
+	}
+	if len(candidates) == 0 {
+		log.Printf("No unreferenced objects in s3://%s", bucket)
+		return nil
+	}
+	total := writePruneCandidates(os.Stdout, fmt.Sprintf("Unreferenced objects in s3://%s", bucket), candidates)
+	if !s.opts.doAction("Remove %d objects (%s) from s3://%s?", len(candidates), humanBytes(total), bucket) {
+		return nil
+	}
+	for _, candidate := range candidates {
+		log.Printf("Removing s3://%s", path.Join(bucket, candidate.Key))
+		if err := s.Aws.Remove(bucket, candidate.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneIncompleteUploads aborts multipart uploads in bucket that can
+// not be resumed by a later run (parts of incomplete uploads are stored
+// and billed until aborted).
+func (s *Show) pruneIncompleteUploads(bucket string) error {
+	incomplete, err := s.Aws.IncompleteUploads(bucket)
+	if err != nil {
+		return err
+	}
+	if len(incomplete) == 0 {
+		return nil
+	}
+	fmt.Printf("Incomplete multipart uploads in s3://%s:\n", bucket)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, u := range incomplete {
+		fmt.Fprintf(tw, "%s\t%s\n", u.Initiated.Format("2006-01-02 15:04:05"), u.Key)
+	}
+	tw.Flush()
+	if !s.opts.doAction("Abort %d incomplete multipart uploads in s3://%s?", len(incomplete), bucket) {
+		return nil
+	}
+	for _, u := range incomplete {
+		log.Printf("Aborting multipart upload of s3://%s", path.Join(bucket, u.Key))
+		if err := s.Aws.AbortIncompleteUpload(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneLocalStorageDir walks localStorageDir and removes every file
+// not referenced by the atom (or another show in the workspace) or
+// matched by keep.
+func (s *Show) pruneLocalStorageDir(keep []string) error {
+	if strings.TrimSpace(s.Atom.Config.LocalStorageDir) == "" {
+		log.Printf("WARNING: localStorageDir is empty in %s, will not prune local files", s.SpecFile)
+		return nil
+	}
+	root := s.Atom.LocalStorageDirExpanded()
+	others, err := s.otherShows()
+	if err != nil {
+		return fmt.Errorf("%w, will not prune local files", err)
+	}
+	// By absolute path, localStorageDir of the other shows may be the
+	// same as, inside or around root.
+	referenced := make(map[string]bool)
+	var protected []func(file string) bool
+	for _, show := range append([]*Show{s}, others...) {
+		if strings.TrimSpace(show.Atom.Config.LocalStorageDir) != "" {
+			dir := absPath(show.Atom.LocalStorageDirExpanded())
+			for file := range show.Atom.ReferencedLocalFiles() {
+				referenced[filepath.Join(dir, filepath.FromSlash(file))] = true
+			}
+		}
+		p, err := show.protectedLocalFiles()
+		if err != nil {
+			return err
+		}
+		protected = append(protected, p)
+	}
+	isProtected := func(file string) bool {
+		for _, p := range protected {
+			if p(file) {
+				return true
+			}
+		}
+		return false
+	}
+	var candidates []PruneCandidate
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if abs := absPath(p); referenced[abs] || keepMatch(keep, rel) || isProtected(abs) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, PruneCandidate{Key: rel, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		log.Printf("No unreferenced files in %s", root)
+		return nil
+	}
+	total := writePruneCandidates(os.Stdout, fmt.Sprintf("Unreferenced files in %s", root), candidates)
+	if !s.opts.doAction("Remove %d files (%s) from %s?", len(candidates), humanBytes(total), root) {
+		return nil
+	}
+	for _, candidate := range candidates {
+		file := filepath.Join(root, filepath.FromSlash(candidate.Key))
+		log.Printf("Removing %s", file)
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referencedOutputKeys returns the keys in the output bucket referenced
+// by the atom and by the other shows in the workspace with the same
+// output bucket (and endpoint).
+func (s *Show) referencedOutputKeys() (map[string]bool, error) {
+	referenced := s.Atom.ReferencedOutputKeys()
+	others, err := s.otherShows()
+	if err != nil {
+		return nil, fmt.Errorf("%w, can not tell which objects in s3://%s are referenced", err, s.Atom.Config.Aws.Buckets.Output)
+	}
+	for _, other := range others {
+		if other.Atom.Config.Aws.Buckets.Output != s.Atom.Config.Aws.Buckets.Output || other.Atom.Config.Aws.Endpoint != s.Atom.Config.Aws.Endpoint {
+			continue
+		}
+		log.Printf("s3://%s is shared with show %s", s.Atom.Config.Aws.Buckets.Output, other.Name)
+		for key := range other.Atom.ReferencedOutputKeys() {
+			referenced[key] = true
+		}
+	}
+	return referenced, nil
+}
+
+// checkLocalPrune returns error if localStorageDir contains the spec
+// (e.g . as set by import), it is then the project directory rather
+// than a cache of the buckets and is not pruned.
+func (s *Show) checkLocalPrune() error {
+	if strings.TrimSpace(s.Atom.Config.LocalStorageDir) == "" {
+		return nil
+	}
+	root := absPath(s.Atom.LocalStorageDirExpanded())
+	if withinDir(root, absPath(s.SpecFile)) {
+		return fmt.Errorf("localStorageDir %s contains %s, will not prune local files (use --remote-only or move localStorageDir out of the directory of the spec)", root, s.SpecFile)
+	}
+	return nil
+}
+
+// protectedLocalFiles returns a function reporting whether a file (by
+// absolute path) belongs to mkpod rather than to the episodes: the spec,
+// the local spec, the backups and lock of the spec, the workspace, the
+// episode files, the locally rendered feeds and anything in the episodes
+// dir or site output dir. These are never pruned.
+func (s *Show) protectedLocalFiles() (func(file string) bool, error) {
+	spec := absPath(s.SpecFile)
+	files := map[string]bool{
+		spec:                       true,
+		absPath(s.localSpecFile()): true,
+		absPath(s.specLockFile()):  true,
+	}
+	if s.workspace != nil {
+		files[absPath(s.workspace.file)] = true
+	}
+	episodeFiles, err := s.episodeFiles(&s.Atom)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range episodeFiles {
+		files[absPath(f)] = true
+	}
+	targets := []FeedTarget{s.Atom.ProductionTarget()}
+	if s.Atom.Config.StagingEnabled() {
+		targets = append(targets, s.Atom.StagingTarget())
+	}
+	for _, target := range targets {
+		files[absPath(s.localFeedFile(target))] = true
+		for _, feed := range s.Atom.AlternateFeeds() {
+			files[absPath(s.localAlternateFeedFile(target, feed))] = true
+		}
+	}
+	dirs := []string{absPath(s.Atom.Config.Site.OutputDirOrDefault())}
+	if strings.TrimSpace(s.Atom.EpisodesDir) != "" {
+		dirs = append(dirs, absPath(s.specRelative(s.Atom.EpisodesDir)))
+	}
+	return func(file string) bool {
+		if files[file] {
+			return true
+		}
+		// Backups are podspec.yaml.1, podspec.yaml.2 and so on.
+		if n, found := strings.CutPrefix(file, spec+"."); found {
+			if _, err := strconv.Atoi(n); err == nil {
+				return true
+			}
+		}
+		for _, dir := range dirs {
+			if withinDir(dir, file) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// absPath returns name as an absolute path, cleaned if it can not be
+// made absolute.
+func absPath(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return filepath.Clean(name)
+	}
+	return abs
+}
+
+// withinDir returns true if file is dir or under dir (both absolute).
+func withinDir(dir string, file string) bool {
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// keepMatch returns true if key or the base name of key matches any of
+// the glob patterns (path.Match syntax).
+func keepMatch(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(key)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writePruneCandidates sorts and prints candidates with their sizes
+// and returns the total size.
+func writePruneCandidates(f *os.File, title string, candidates []PruneCandidate) int64 {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Key < candidates[j].Key
+	})
+	var total int64
+	tw := tabwriter.NewWriter(f, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(f, "%s:\n", title)
+	for _, c := range candidates {
+		fmt.Fprintf(tw, "%s\t%s\n", humanBytes(c.Size), c.Key)
+		total += c.Size
+	}
+	fmt.Fprintf(tw, "%s\ttotal\n", humanBytes(total))
+	tw.Flush()
+	return total
+}
+
+// humanBytes returns n formatted with a binary unit suffix.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeepMatch(t *testing.T) {
+	patterns := []string{"*.html", "archive/*"}
+	for key, expected := range map[string]bool{
+		"index.html":                       true,
+		"site/qzj023/index.html":           true,
+		"archive/qzj001.mp3":               true,
+		"archive/old/qzj001.mp3":           false,
+		"qzj023-pace-vs-sambandstabla.mp3": false,
+	} {
+		if got := keepMatch(patterns, key); got != expected {
+			t.Errorf("keepMatch(%q) = %t, expected %t", key, got, expected)
+		}
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	for n, expected := range map[int64]string{
+		0:        "0 B",
+		1023:     "1023 B",
+		1024:     "1.0 KiB",
+		23393112: "22.3 MiB",
+	} {
+		if got := humanBytes(n); got != expected {
+			t.Errorf("humanBytes(%d) = %q, expected %q", n, got, expected)
+		}
+	}
+}
+
+func TestPruneLocalStorageDir(t *testing.T) {
+	dir := t.TempDir()
+	storage := filepath.Join(dir, "storage")
+	spec := filepath.Join(dir, "podspec.yaml")
+	files := map[string]string{
+		spec:                                           "atom: podcast.rss\nconfig:\n  localStorageDir: " + storage + "\n  feeds:\n    json: podcast.json\n  staging:\n    baseURL: https://example.com/staging\n    prefix: staging\nepisodesDir: episodes\nepisodes:\n- uid: 1\n  title: First\n  output: qzj001.mp3\n",
+		filepath.Join(dir, "podspec.local.yaml"):       "config:\n  aws:\n    profile: laptop\n",
+		filepath.Join(dir, "podspec.yaml.2"):           "atom: podcast.rss\n",
+		filepath.Join(dir, "episodes", "two.md"):       "---\nuid: 2\ntitle: Second\n---\n",
+		filepath.Join(storage, "qzj001.mp3"):           "mp3",
+		filepath.Join(storage, "qzj000.mp3"):           "old",
+		filepath.Join(storage, "podcast.rss"):          "<rss/>",
+		filepath.Join(storage, "podcast.staging.rss"):  "<rss/>",
+		filepath.Join(storage, "podcast.staging.json"): "{}",
+		filepath.Join(storage, "site", "index.html"):   "<html/>",
+		filepath.Join(storage, "notes", "qzj000.txt"):  "old",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Feeds and the site are rendered into the working directory.
+	t.Chdir(storage)
+	s := NewShow("", spec)
+	s.opts.Force = true
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	protected, err := s.protectedLocalFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, expected := range map[string]bool{
+		spec:                                     true,
+		filepath.Join(dir, "podspec.local.yaml"): true,
+		filepath.Join(dir, "podspec.yaml.2"):     true,
+		filepath.Join(dir, "podspec.yaml.lock"):  true,
+		filepath.Join(dir, "podspec.yaml.bak"):   false,
+		filepath.Join(dir, "episodes", "two.md"): true,
+		filepath.Join(storage, "podcast.json"):   true,
+		filepath.Join(storage, "qzj000.mp3"):     false,
+	} {
+		if got := protected(file); got != expected {
+			t.Errorf("protected(%s) = %t, expected %t", file, got, expected)
+		}
+	}
+	if err := s.checkLocalPrune(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.pruneLocalStorageDir(nil); err != nil {
+		t.Fatal(err)
+	}
+	for file := range files {
+		_, err := os.Stat(file)
+		removed := errors.Is(err, fs.ErrNotExist)
+		expected := file == filepath.Join(storage, "qzj000.mp3") || file == filepath.Join(storage, "notes", "qzj000.txt")
+		if removed != expected {
+			t.Errorf("expected %s removed to be %t", file, expected)
+		}
+	}
+
+	// The spec in localStorageDir (e.g . after import) is refused.
+	s.Atom.Config.LocalStorageDir = "."
+	t.Chdir(dir)
+	if err := s.checkLocalPrune(); err == nil || !strings.Contains(err.Error(), "will not prune local files") {
+		t.Errorf("expected refusal to prune the directory of the spec, got %v", err)
+	}
+}
+
+func TestPruneSharedByShows(t *testing.T) {
+	fake, endpoint := newFakeS3(t)
+	dir := t.TempDir()
+	storage := filepath.Join(dir, "storage")
+	config := "config:\n  localStorageDir: " + storage + "\n  aws:\n    region: eu-north-1\n    endpoint: " + endpoint + "\n    buckets:\n      output: pod\n"
+	workspace := filepath.Join(dir, defaultWorkspace)
+	files := map[string]string{
+		workspace:                              "shows:\n- name: qzj\n  spec: qzj/podspec.yaml\n- name: sm0\n  spec: sm0/podspec.yaml\n",
+		filepath.Join(dir, "qzj/podspec.yaml"): "atom: qzj.rss\n" + config + "episodes:\n- uid: 1\n  title: QZJ\n  output: qzj001.mp3\n",
+		filepath.Join(dir, "sm0/podspec.yaml"): "atom: sm0.rss\n" + config + "episodes:\n- uid: 1\n  title: SM0\n  output: sm0001.mp3\n",
+		filepath.Join(storage, "qzj001.mp3"):   "mp3",
+		filepath.Join(storage, "sm0001.mp3"):   "mp3",
+		filepath.Join(storage, "old.mp3"):      "old",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"qzj001.mp3", "sm0001.mp3", "old.mp3"} {
+		fake.Put("pod", key, []byte("mp3"))
+	}
+	t.Chdir(dir)
+	ws, err := LoadWorkspace(workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ws.Show("qzj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.opts.Force = true
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	s.Aws.NewSession()
+	if err := s.pruneLocalStorageDir(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.pruneOutputBucket(nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"qzj001.mp3", "sm0001.mp3", "old.mp3"} {
+		_, err := os.Stat(filepath.Join(storage, name))
+		removed := errors.Is(err, fs.ErrNotExist)
+		if expected := name == "old.mp3"; removed != expected {
+			t.Errorf("expected %s removed from localStorageDir to be %t", name, expected)
+		}
+		if expected := name == "old.mp3"; (fake.Object("pod", name) == nil) != expected {
+			t.Errorf("expected %s removed from the output bucket to be %t", name, expected)
+		}
+	}
+
+	// Nothing is pruned if another show does not load.
+	if err := os.WriteFile(filepath.Join(storage, "old.mp3"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fake.Put("pod", "old.mp3", []byte("old"))
+	if err := os.WriteFile(filepath.Join(dir, "sm0/podspec.yaml"), []byte("atom: [sm0.rss\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.pruneLocalStorageDir(nil); err == nil || !strings.Contains(err.Error(), "show sm0") {
+		t.Errorf("expected refusal to prune local files, got %v", err)
+	}
+	if err := s.pruneOutputBucket(nil); err == nil || !strings.Contains(err.Error(), "show sm0") {
+		t.Errorf("expected refusal to prune the output bucket, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storage, "old.mp3")); err != nil || fake.Object("pod", "old.mp3") == nil {
+		t.Error("expected old.mp3 to be kept")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Restore of archived objects. Masters moved to GLACIER or
+// DEEP_ARCHIVE (e.g by lifecycle rules) can not be downloaded until
+// they have been restored. Download starts a restore and returns
+// ErrRestorePending, the restore is tracked in a state file under
+// localStorageDir and the object is downloaded by a later run once the
+// restore has completed.
+
+const (
+	defaultRestoreStateFile string = ".mkpod-restores.json"
+	defaultRestoreTier      string = s3.TierStandard
+	defaultRestoreDays      int64  = 7
+)
+
+var ErrRestorePending = errors.New("restore from archive is pending")
+
+type RestoreConfig struct {
+	// Retrieval tier, Expedited, Standard or Bulk.
+	Tier string `yaml:"tier,omitempty"`
+	// Number of days the restored copy is available.
+	Days int64 `yaml:"days,omitempty"`
+}
+
+// TierOrDefault returns the configured tier or the default.
+func (r RestoreConfig) TierOrDefault() string {
+	if strings.TrimSpace(r.Tier) == "" {
+		return defaultRestoreTier
+	}
+	return r.Tier
+}
+
+// DaysOrDefault returns the configured number of days or the default.
+func (r RestoreConfig) DaysOrDefault() int64 {
+	if r.Days < 1 {
+		return defaultRestoreDays
+	}
+	return r.Days
+}
+
+// PendingRestore is a restore request that has not been seen to
+// complete.
+type PendingRestore struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	StorageClass string    `json:"storageClass"`
+	Tier         string    `json:"tier"`
+	Days         int64     `json:"days"`
+	RequestedAt  time.Time `json:"requestedAt"`
+}
+
+// RestoreState is the set of pending restores keyed by bucket/key.
+type RestoreState struct {
+	Pending map[string]PendingRestore `json:"pending"`
+	path    string
+	mu      sync.Mutex
+}
+
+// LoadRestoreState reads the state from file or returns an empty one
+// if file does not exist.
+func LoadRestoreState(file string) (*RestoreState, error) {
+	state := &RestoreState{
+		Pending: make(map[string]PendingRestore),
+		path:    file,
+	}
+	if err := loadJSON(file, state); err != nil {
+		return nil, err
+	}
+	if state.Pending == nil {
+		state.Pending = make(map[string]PendingRestore)
+	}
+	return state, nil
+}
+
+// Save writes the state to the file it was loaded from.
+func (r *RestoreState) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return saveJSON(r.path, r)
+}
+
+// pending returns the pending restore of id.
+func (r *RestoreState) pending(id string) (PendingRestore, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending, ok := r.Pending[id]
+	return pending, ok
+}
+
+// remove removes the pending restore of id.
+func (r *RestoreState) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.Pending, id)
+}
+
+// Archived returns true if the object has to be restored before it
+// can be downloaded.
+func (r *RemoteObject) Archived() bool {
+	switch r.StorageClass {
+	case s3.StorageClassGlacier, s3.StorageClassDeepArchive:
+		return true
+	}
+	// Intelligent-Tiering archive access tiers.
+	return r.ArchiveStatus != ""
+}
+
+// RestoreOngoing returns true if a restore has been requested and has
+// not completed yet (x-amz-restore: ongoing-request="true").
+func (r *RemoteObject) RestoreOngoing() bool {
+	return strings.Contains(r.Restore, `ongoing-request="true"`)
+}
+
+// Restored returns true if a restored copy of an archived object is
+// available (x-amz-restore: ongoing-request="false", expiry-date=...).
+func (r *RemoteObject) Restored() bool {
+	return strings.Contains(r.Restore, `ongoing-request="false"`)
+}
+
+// restoreState returns the restore state stored in localStorageDir,
+// loading it on first use.
+func (s *AwsHandler) restoreState() (*RestoreState, error) {
+	if s.Restores == nil {
+		file := absPath(path.Join(s.Atom.LocalStorageDirExpanded(), defaultRestoreStateFile))
+		localState.Lock()
+		defer localState.Unlock()
+		state, ok := localState.restores[file]
+		if !ok {
+			var err error
+			if state, err = LoadRestoreState(file); err != nil {
+				return nil, err
+			}
+			localState.restores[file] = state
+		}
+		s.Restores = state
+	}
+	return s.Restores, nil
+}
+
+// EnsureRestored returns nil if remote can be downloaded. If remote is
+// archived, a restore is requested (unless one is already ongoing) and
+// tracked in the restore state and ErrRestorePending is returned.
+func (s *AwsHandler) EnsureRestored(bucket string, key string, remote *RemoteObject) error {
+	state, err := s.restoreState()
+	if err != nil {
+		return err
+	}
+	id := path.Join(bucket, key)
+	if !remote.Archived() || remote.Restored() {
+		if _, ok := state.pending(id); ok {
+			log.Printf("Restore of s3://%s has completed", id)
+			state.remove(id)
+			return state.Save()
+		}
+		return nil
+	}
+	cfg := s.Atom.Config.Restore
+	if !remote.RestoreOngoing() {
+		request := &s3.RestoreRequest{
+			GlacierJobParameters: &s3.GlacierJobParameters{
+				Tier: aws.String(cfg.TierOrDefault()),
+			},
+		}
+		// Days must not be set for Intelligent-Tiering archive tiers.
+		if remote.ArchiveStatus == "" {
+			request.Days = aws.Int64(cfg.DaysOrDefault())
+		}
+		log.Printf("s3://%s is archived as %s, requesting %s restore", id, remote.StorageClass, cfg.TierOrDefault())
+		_, err := s.S3.RestoreObject(&s3.RestoreObjectInput{
+			Bucket:         aws.String(bucket),
+			Key:            aws.String(key),
+			RestoreRequest: request,
+		})
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "RestoreAlreadyInProgress" {
+				return fmt.Errorf("unable to restore s3://%s: %w", id, err)
+			}
+		}
+	}
+	pending, ok := state.pending(id)
+	if !ok {
+		pending = PendingRestore{
+			Bucket:       bucket,
+			Key:          key,
+			StorageClass: remote.StorageClass,
+			Tier:         cfg.TierOrDefault(),
+			Days:         cfg.DaysOrDefault(),
+			RequestedAt:  time.Now().UTC(),
+		}
+		state.mu.Lock()
+		state.Pending[id] = pending
+		state.mu.Unlock()
+		if err := state.Save(); err != nil {
+			return err
+		}
+	}
+	log.Printf("Restore of s3://%s is pending since %s", id, pending.RequestedAt.Format(time.RFC1123Z))
+	return fmt.Errorf("s3://%s: %w", id, ErrRestorePending)
+}
+
+// logPendingRestores checks every pending restore and logs which are
+// still in progress. Completed restores are removed from the state.
+func (s *Show) logPendingRestores() error {
+	state, err := s.Aws.restoreState()
+	if err != nil {
+		return err
+	}
+	state.mu.Lock()
+	var ids []string
+	for id := range state.Pending {
+		ids = append(ids, id)
+	}
+	state.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		pending, ok := state.pending(id)
+		if !ok {
+			continue
+		}
+		remote, err := s.Aws.Head(pending.Bucket, pending.Key)
+		if err != nil {
+			if isNotFound(err) {
+				log.Printf("WARNING: s3://%s with pending restore no longer exists", id)
+				state.remove(id)
+				continue
+			}
+			return err
+		}
+		if remote.Archived() && !remote.Restored() {
+			log.Printf("Restore of s3://%s (%s, %s tier) requested %s is still in progress", id, pending.StorageClass, pending.Tier, pending.RequestedAt.Format(time.RFC1123Z))
+		} else {
+			log.Printf("Restore of s3://%s has completed, will be downloaded when needed", id)
+		}
+	}
+	return state.Save()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoteObjectArchived(t *testing.T) {
+	for _, tc := range []struct {
+		remote   RemoteObject
+		archived bool
+		ongoing  bool
+		restored bool
+	}{
+		{RemoteObject{StorageClass: "GLACIER_IR"}, false, false, false},
+		{RemoteObject{StorageClass: "GLACIER"}, true, false, false},
+		{RemoteObject{StorageClass: "DEEP_ARCHIVE", Restore: `ongoing-request="true"`}, true, true, false},
+		{RemoteObject{StorageClass: "GLACIER", Restore: `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`}, true, false, true},
+		{RemoteObject{StorageClass: "INTELLIGENT_TIERING", ArchiveStatus: "ARCHIVE_ACCESS"}, true, false, false},
+	} {
+		if got := tc.remote.Archived(); got != tc.archived {
+			t.Errorf("%+v: expected Archived() %t, got %t", tc.remote, tc.archived, got)
+		}
+		if got := tc.remote.RestoreOngoing(); got != tc.ongoing {
+			t.Errorf("%+v: expected RestoreOngoing() %t, got %t", tc.remote, tc.ongoing, got)
+		}
+		if got := tc.remote.Restored(); got != tc.restored {
+			t.Errorf("%+v: expected Restored() %t, got %t", tc.remote, tc.restored, got)
+		}
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	file := filepath.Join(t.TempDir(), defaultRestoreStateFile)
+	state, err := LoadRestoreState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Pending) != 0 {
+		t.Fatalf("expected empty state, got %d pending", len(state.Pending))
+	}
+	requested := time.Date(2024, 10, 22, 21, 23, 47, 0, time.UTC)
+	state.Pending["assetbucket/QZJ-E16.wav"] = PendingRestore{
+		Bucket:       "assetbucket",
+		Key:          "QZJ-E16.wav",
+		StorageClass: "DEEP_ARCHIVE",
+		Tier:         (RestoreConfig{}).TierOrDefault(),
+		Days:         (RestoreConfig{}).DaysOrDefault(),
+		RequestedAt:  requested,
+	}
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRestoreState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := loaded.Pending["assetbucket/QZJ-E16.wav"]
+	if !ok {
+		t.Fatal("expected pending restore to be loaded")
+	}
+	if got.Tier != defaultRestoreTier || got.Days != defaultRestoreDays || !got.RequestedAt.Equal(requested) {
+		t.Errorf("unexpected pending restore %+v", got)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/sa6mwa/mp3duration"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/alessio/shellescape.v1"
+)
+
+// The retag command rewrites the metadata (ID3v2.4 tag or mp4
+// metadata and chapters) of already encoded and uploaded output files
+// without re-encoding. The audio (and video) is verified to be
+// bit-identical before the file is uploaded again.
+
+func (s *Show) retagger(c *cli.Context) error {
+	var err error
+
+	if c.Args().Len() == 0 && !c.Bool("all") {
+		log.Fatal("You need to select one or several episode UIDs to retag as argument(s) to this command or use the all-option -a")
+	}
+
+	s.opts.Force = c.Bool("force")
+
+	unlock, err := s.lockSpec()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = s.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	s.Aws.NewSession()
+
+	err = s.createLocalStorageDir()
+	if err != nil {
+		return err
+	}
+	err = s.basicAtomValidation()
+	if err != nil {
+		return err
+	}
+
+	s.templates.FFmpegRetag, err = template.New("ffmpegRetag").Funcs(commandFuncMap()).Parse(s.ffmpegRetagCommandTemplate)
+	if err != nil {
+		return err
+	}
+
+	// The cover is part of the ID3v2.4 tag.
+	if strings.TrimSpace(s.Atom.Encoding.Coverfront) != "" {
+		if err := s.Aws.Download(s.Atom.Config.Aws.Buckets.Input, s.Atom.Encoding.Coverfront); err != nil {
+			return err
+		}
+	}
+
+	var uids []int64
+	if c.Bool("all") {
+		for _, e := range s.Atom.Episodes {
+			if len(e.Output) >= 3 {
+				uids = append(uids, e.UID)
+			}
+		}
+	} else {
+		for _, uidstr := range c.Args().Slice() {
+			uid, err := strconv.ParseInt(uidstr, 10, 64)
+			if err != nil {
+				return fmt.Errorf("must specify the UID integer of the episode to retag: %w", err)
+			}
+			uids = append(uids, uid)
+		}
+	}
+	for _, uid := range uids {
+		if err := s.retagEpisode(s.templates, uid); err != nil {
+			return fmt.Errorf("error retagging episode with UID %d: %w", uid, err)
+		}
+	}
+
+	if s.processCounter == 0 {
+		log.Printf("No episode was retagged")
+	} else {
+		plural := ""
+		if s.processCounter > 1 {
+			plural = "s"
+		}
+		log.Printf("Retagged %d episode%s", s.processCounter, plural)
+	}
+
+	return s.rewriteSpec()
+}
+
+// retagEpisode downloads the output file of the episode with uid,
+// replaces the metadata, verifies the audio is unchanged, resolves the
+// new length and uploads it to the output bucket again.
+func (s *Show) retagEpisode(tmpl *Templates, uid int64) error {
+	idx := s.Atom.ContainsEpisode(uid)
+	if idx < 0 {
+		log.Printf("WARNING: Episode with uid %d does not exist in %s, skipping", uid, s.SpecFile)
+		return nil
+	}
+	episode := &s.Atom.Episodes[idx]
+	if len(episode.Output) < 3 {
+		log.Printf("WARNING: Episode with uid %d (%s) has not been encoded, skipping", episode.UID, episode.Title)
+		return nil
+	}
+	target := s.Atom.EpisodeTarget(episode)
+	if !s.opts.doAction("Download s3://%s, rewrite metadata and upload?", path.Join(target.Bucket, target.Key(episode.Output))) {
+		return nil
+	}
+	if err := s.Aws.DownloadTo(target.Bucket, target.Key(episode.Output), episode.Output); err != nil {
+		return err
+	}
+	if strings.TrimSpace(episode.Transcript) != "" {
+		if err := s.Aws.Download(s.Atom.Config.Aws.Buckets.Input, episode.Transcript); err != nil {
+			return err
+		}
+	}
+
+	outputPath := path.Join(s.Atom.LocalStorageDirExpanded(), episode.Output)
+	contentType, err := GetFileContentType(outputPath)
+	if err != nil {
+		return fmt.Errorf("unable to get content-type of file %s: %w", outputPath, err)
+	}
+	isMP3 := contentType == "audio/mpeg"
+
+	digest := s.FFmpegStreamDigest
+	if isMP3 {
+		digest = MP3AudioDigest
+	}
+	before, err := digest(outputPath)
+	if err != nil {
+		return fmt.Errorf("unable to checksum audio of %s: %w", outputPath, err)
+	}
+
+	tags, err := NewPodcastTags(&s.Atom, episode)
+	if err != nil {
+		return err
+	}
+	if isMP3 {
+		log.Printf("Replacing ID3v2.4 tag of %s", outputPath)
+		if err := tags.WriteID3v2Tag(outputPath); err != nil {
+			return err
+		}
+		di, err := mp3duration.ReadFile(outputPath)
+		if err != nil {
+			return err
+		}
+		episode.Length = di.Length
+		episode.Duration.Duration = di.TimeDuration
+	} else {
+		if err := s.retagViaFFmpeg(tmpl, episode, tags); err != nil {
+			return err
+		}
+	}
+
+	after, err := digest(outputPath)
+	if err != nil {
+		return fmt.Errorf("unable to checksum audio of %s: %w", outputPath, err)
+	}
+	if before != after {
+		return fmt.Errorf("audio of %s changed while retagging (sha256 %s before, %s after), will not upload", outputPath, before, after)
+	}
+	log.Printf("%s is %s long and %d bytes, audio is unchanged (updating %s)", episode.Output, episode.Duration, episode.Length, s.SpecFile)
+	episode.Type = contentType
+	s.updateAtom = true
+
+	if err := s.Aws.Upload(ObjectKindMedia, target.Bucket, target.Key(episode.Output), contentType, outputPath); err != nil {
+		return err
+	}
+	// The chapters may have changed.
+	if err := s.uploadSidecars(episode, target); err != nil {
+		return err
+	}
+	s.processCounter++
+	return nil
+}
+
+// retagViaFFmpeg stream copies the output file of episode into a
+// temporary file with new metadata and chapters and replaces the
+// output file with it. Episode length and duration are updated.
+func (s *Show) retagViaFFmpeg(tmpl *Templates, episode *Episode, tags *PodcastTags) error {
+	outputPath := path.Join(s.Atom.LocalStorageDirExpanded(), episode.Output)
+	duration, _, err := GetSizeAndDurationViaFFprobe(outputPath)
+	if err != nil {
+		return fmt.Errorf("unable to get duration of %s: %w", outputPath, err)
+	}
+	metadataFile, err := tags.WriteFFmpegMetadataFile(duration)
+	if err != nil {
+		return fmt.Errorf("unable to generate ffmetadata file: %w", err)
+	}
+	defer os.Remove(metadataFile)
+
+	combined := s.getCombined(*episode)
+	combined.MetadataFile = metadataFile
+	combined.TempFile = ReplaceExtension(outputPath, ".retag"+filepath.Ext(outputPath))
+	defer os.Remove(combined.TempFile)
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.FFmpegRetag.Execute(buf, combined); err != nil {
+		return err
+	}
+	log.Printf("Executing: %s", buf.String())
+	if err := Run(buf.String()); err != nil {
+		return fmt.Errorf("unable to retag %s using ffmpeg: %w", outputPath, err)
+	}
+	if err := tags.VerifyFFprobe(combined.TempFile); err != nil {
+		return err
+	}
+	if err := os.Rename(combined.TempFile, outputPath); err != nil {
+		return err
+	}
+	duration, size, err := GetSizeAndDurationViaFFprobe(outputPath)
+	if err != nil {
+		return fmt.Errorf("unable to get duration and size from %s: %w", episode.Output, err)
+	}
+	episode.Length = size
+	episode.Duration.Duration = duration
+	return nil
+}
+
+// MP3AudioDigest returns the hex encoded sha256 sum of mp3file
+// excluding any leading ID3v2 tag, i.e the audio frames (and any
+// trailing ID3v1 tag).
+func MP3AudioDigest(mp3file string) (string, error) {
+	f, err := os.Open(mp3file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return "", err
+	}
+	var offset int64
+	if bytes.HasPrefix(header, []byte("ID3")) {
+		// Tag size is a 28 bit synchsafe integer excluding the header
+		// (and the footer if flag bit 4 is set).
+		size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+		offset = 10 + size
+		if header[5]&0x10 != 0 {
+			offset += 10
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FFmpegStreamDigest returns the sha256 sum of all audio and video
+// packets in filename as calculated by ffmpeg's hash muxer. Metadata
+// and chapters are not part of the sum.
+func (s *Show) FFmpegStreamDigest(filename string) (string, error) {
+	ffmpegCmd := fmt.Sprintf("%s -v error -i %s -map 0:a -map 0:v? -c copy -f hash -hash sha256 -", shellescape.Quote(s.Atom.FFmpegPathExpanded()), shellescape.Quote(filename))
+	cmd := exec.Command(shell, shellCommandOption, ffmpegCmd)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	sum, found := strings.CutPrefix(strings.TrimSpace(out.String()), "SHA256=")
+	if !found {
+		return "", errors.New("unexpected output from ffmpeg hash muxer: " + out.String())
+	}
+	return sum, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	id3v2 "github.com/bogem/id3v2"
+)
+
+func TestMP3AudioDigest(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64, 0x00, 0x01}, 1000)
+	sum := sha256.Sum256(audio)
+	expected := hex.EncodeToString(sum[:])
+
+	mp3file := filepath.Join(t.TempDir(), "test.mp3")
+	for _, title := range []string{"First title", "A much longer second title to change the tag size"} {
+		tag := id3v2.NewEmptyTag()
+		tag.SetTitle(title)
+		buf := &bytes.Buffer{}
+		if _, err := tag.WriteTo(buf); err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(audio)
+		if err := os.WriteFile(mp3file, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := MP3AudioDigest(mp3file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("expected %s, got %s", expected, got)
+		}
+	}
+
+	if err := os.WriteFile(mp3file, audio, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := MP3AudioDigest(mp3file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != expected {
+		t.Errorf("expected %s for untagged file, got %s", expected, got)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// The serve-schedule command is a long-running mode publishing the
+// production feed whenever a future-dated episode reaches its pubDate.
+// The spec is reloaded (and the feed published) when the spec file
+// changes or on SIGHUP. SIGTERM and SIGINT stop the daemon between
+// publications.
+
+const (
+	defaultSchedulePoll time.Duration = 10 * time.Second
+	// Margin added to pubDate before publishing to absorb clock skew
+	// between this host and the template's timeNow.
+	scheduleMargin time.Duration = time.Second
+)
+
+// serveSchedules runs serve-schedule for every selected show
+// concurrently until one fails or all are stopped.
+func serveSchedules(c *cli.Context) error {
+	shows, err := selectShows(c)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, len(shows))
+	for _, s := range shows {
+		go func(s *Show) {
+			err := s.serveSchedule(c)
+			if err != nil && s.Name != "" {
+				err = fmt.Errorf("show %s: %w", s.Name, err)
+			}
+			errs <- err
+		}(s)
+	}
+	for range shows {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Show) serveSchedule(c *cli.Context) error {
+	notifyCommand := c.String("notify-command")
+	poll := c.Duration("poll")
+	if poll <= 0 {
+		poll = defaultSchedulePoll
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	specModTime, err := s.reloadSchedule()
+	if err != nil {
+		return err
+	}
+	if err := s.publishScheduled(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		var wakeup <-chan time.Time
+		var timer *time.Timer
+		next := s.nextPubDate(time.Now())
+		if next.IsZero() {
+			log.Printf("No future-dated episodes in %s, waiting for changes", s.SpecFile)
+		} else {
+			wait := time.Until(next) + scheduleMargin
+			log.Printf("Next episode is published %s (in %s)", next.Format(time.RFC1123Z), wait.Round(time.Second))
+			timer = time.NewTimer(wait)
+			wakeup = timer.C
+		}
+	wait:
+		for {
+			select {
+			case sig := <-signals:
+				if sig != syscall.SIGHUP {
+					log.Printf("Received %s, exiting", sig)
+					return nil
+				}
+				log.Printf("Received %s, reloading %s", sig, s.SpecFile)
+				if modTime, err := s.reloadSchedule(); err != nil {
+					log.Printf("ERROR: %v (keeping previous %s)", err, s.SpecFile)
+				} else {
+					specModTime = modTime
+					logError(s.publishScheduled())
+				}
+				break wait
+			case <-ticker.C:
+				modified, err := s.specModified()
+				if err != nil {
+					log.Printf("ERROR: %v", err)
+					continue
+				}
+				if modified.Equal(specModTime) {
+					continue
+				}
+				log.Printf("%s has changed, reloading", s.SpecFile)
+				if modTime, err := s.reloadSchedule(); err != nil {
+					log.Printf("ERROR: %v (keeping previous %s)", err, s.SpecFile)
+					specModTime = modified
+				} else {
+					specModTime = modTime
+					logError(s.publishScheduled())
+				}
+				break wait
+			case <-wakeup:
+				if err := s.publishScheduled(); err != nil {
+					// Retry at the next poll.
+					log.Printf("ERROR: %v", err)
+					wakeup = time.After(poll)
+					continue
+				}
+				if notifyCommand != "" {
+					log.Printf("Executing: %s", notifyCommand)
+					logError(Run(notifyCommand))
+				}
+				break wait
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// reloadSchedule loads the spec into a fresh atom and returns the
+// modification time of the spec (or of the latest episode file). The
+// previous atom is kept if loading fails.
+func (s *Show) reloadSchedule() (time.Time, error) {
+	if _, err := os.Stat(s.SpecFile); err != nil {
+		return time.Time{}, err
+	}
+	previous := s.Atom
+	s.Atom = Atom{}
+	if err := s.loadConfig(); err != nil {
+		s.Atom = previous
+		return time.Time{}, fmt.Errorf("unable to load %s: %w", s.SpecFile, err)
+	}
+	if err := s.validateScheduledEpisodes(); err != nil {
+		s.Atom = previous
+		return time.Time{}, err
+	}
+	s.Aws = AwsHandler{Atom: &s.Atom, opts: &s.opts}
+	s.Aws.NewSession()
+	if err := s.createLocalStorageDir(); err != nil {
+		return time.Time{}, err
+	}
+	return s.specModified()
+}
+
+// validateScheduledEpisodes returns error if an episode that is or will
+// be part of the production feed has not been encoded. Unlike
+// validateAtom, nothing is asked or resolved.
+func (s *Show) validateScheduledEpisodes() error {
+	if len(s.Atom.Atom) < 1 {
+		return fmt.Errorf("atom property in %s must not be empty", s.SpecFile)
+	}
+	for _, e := range s.Atom.Episodes {
+		if e.Staged || e.PubDate.IsZero() {
+			continue
+		}
+		if len(e.Output) < 3 || e.Length < 1 || strings.TrimSpace(e.Type) == "" {
+			return fmt.Errorf("episode with uid %d (%s) has a pubDate but has not been encoded", e.UID, e.Title)
+		}
+	}
+	return nil
+}
+
+// publishScheduled renders the production feed and uploads it if it
+// differs from the object in the output bucket.
+func (s *Show) publishScheduled() error {
+	s.refreshLastBuildDate(time.Now())
+	return s.publishFeed(s.Atom.ProductionTarget())
+}
+
+// refreshLastBuildDate sets lastBuildDate to the pubDate of the latest
+// episode published at now if it is later. The rendered feed is
+// therefore the same no matter when (or how many times) it is
+// rendered between two publications.
+func (s *Show) refreshLastBuildDate(now time.Time) {
+	for _, e := range s.Atom.Episodes {
+		if e.Staged || !isAfter(now, e.PubDate.Time) {
+			continue
+		}
+		if e.PubDate.After(s.Atom.LastBuildDate.Time) {
+			s.Atom.LastBuildDate.Time = e.PubDate.Time
+		}
+	}
+}
+
+// nextPubDate returns the earliest pubDate after now of an episode that
+// is not staged or zero time if there is none.
+func (s *Show) nextPubDate(now time.Time) time.Time {
+	var next time.Time
+	for _, e := range s.Atom.Episodes {
+		if e.Staged || !e.PubDate.After(now) {
+			continue
+		}
+		if next.IsZero() || e.PubDate.Before(next) {
+			next = e.PubDate.Time
+		}
+	}
+	return next
+}
+
+func logError(err error) {
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+}
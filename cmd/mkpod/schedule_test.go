@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestNextPubDate(t *testing.T) {
+	s := NewShow("", "")
+
+	now := time.Date(2024, 10, 22, 12, 0, 0, 0, time.UTC)
+	published := now.Add(-48 * time.Hour)
+	tomorrow := now.Add(24 * time.Hour)
+	nextWeek := now.Add(7 * 24 * time.Hour)
+	s.Atom = Atom{}
+	s.Atom.LastBuildDate.Time = now.Add(-72 * time.Hour)
+	s.Atom.Episodes = []Episode{
+		{UID: 4, PubDate: ItunesTime{nextWeek}},
+		{UID: 3, PubDate: ItunesTime{now.Add(time.Hour)}, Staged: true},
+		{UID: 2, PubDate: ItunesTime{tomorrow}},
+		{UID: 1, PubDate: ItunesTime{published}},
+	}
+
+	if next := s.nextPubDate(now); !next.Equal(tomorrow) {
+		t.Errorf("expected next pubDate %s, got %s", tomorrow, next)
+	}
+	if next := s.nextPubDate(nextWeek); !next.IsZero() {
+		t.Errorf("expected no next pubDate, got %s", next)
+	}
+
+	s.refreshLastBuildDate(now)
+	if !s.Atom.LastBuildDate.Equal(published) {
+		t.Errorf("expected lastBuildDate %s, got %s", published, s.Atom.LastBuildDate.Time)
+	}
+	s.refreshLastBuildDate(tomorrow)
+	if !s.Atom.LastBuildDate.Equal(tomorrow) {
+		t.Errorf("expected lastBuildDate %s, got %s", tomorrow, s.Atom.LastBuildDate.Time)
+	}
+}
+
+// scheduleTestShow writes a spec publishing to the fake S3 endpoint with
+// one published episode in the spec and one in an episode file.
+func scheduleTestShow(t *testing.T, endpoint string, next time.Time) (*Show, string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	spec := filepath.Join(dir, "podspec.yaml")
+	files := map[string]string{
+		spec: "atom: podcast.rss\ntitle: QZJ\nconfig:\n  baseURL: https://pod.example.com\n  localStorageDir: " + filepath.Join(dir, "storage") + "\n  aws:\n    region: eu-north-1\n    endpoint: " + endpoint + "\n    buckets:\n      output: pod\nepisodesDir: episodes\nepisodes:\n" +
+			"- uid: 1\n  title: First\n  pubDate: Mon, 02 Jan 2006 15:04:05 +0000\n  output: qzj001.mp3\n  length: 3\n  type: audio/mpeg\n",
+		filepath.Join(dir, "episodes", "two.md"): "---\nuid: 2\ntitle: Second\npubDate: " + next.Format(time.RFC1123Z) + "\noutput: qzj002.mp3\nlength: 3\ntype: audio/mpeg\n---\n",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shows, err := selectShows(globalTestContext(t, spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return shows[0], dir
+}
+
+// touch rewrites file with content and a modification time in the
+// future so that a change is seen regardless of timestamp resolution.
+func touch(t *testing.T, file string, content string, offset time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(offset)
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadSchedule(t *testing.T) {
+	_, endpoint := newFakeS3(t)
+	s, dir := scheduleTestShow(t, endpoint, time.Now().Add(24*time.Hour))
+	modTime, err := s.reloadSchedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Atom.Episodes) != 2 {
+		t.Fatalf("expected 2 episodes, got %+v", s.Atom.Episodes)
+	}
+	if s.Aws.Atom != &s.Atom || s.Aws.opts != &s.opts {
+		t.Error("expected the AWS handler to use the atom and options of the show")
+	}
+	if modified, err := s.specModified(); err != nil || !modified.Equal(modTime) {
+		t.Errorf("expected %s unmodified, got %s (%v)", s.SpecFile, modified, err)
+	}
+
+	// A changed episode file is picked up.
+	episodeFile := filepath.Join(dir, "episodes", "two.md")
+	b, err := os.ReadFile(episodeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	touch(t, episodeFile, strings.Replace(string(b), "title: Second", "title: Andra", 1), time.Hour)
+	modified, err := s.specModified()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modified.Equal(modTime) {
+		t.Fatalf("expected a change of %s to be detected", episodeFile)
+	}
+	if modTime, err = s.reloadSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if !modified.Equal(modTime) || s.Atom.Episodes[0].Title != "Andra" {
+		t.Errorf("expected the changed episode file to be loaded, got %+v", s.Atom.Episodes)
+	}
+
+	// A changed spec is picked up.
+	b, err = os.ReadFile(s.SpecFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := string(b)
+	touch(t, s.SpecFile, strings.Replace(spec, "title: QZJ", "title: QZJ Radio", 1), 2*time.Hour)
+	if _, err := s.reloadSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Atom.Title != "QZJ Radio" {
+		t.Errorf("expected the changed spec to be loaded, got title %q", s.Atom.Title)
+	}
+
+	// The previous atom is kept if the spec does not load or an episode
+	// with a pubDate has not been encoded.
+	for _, broken := range []string{
+		strings.Replace(spec, "title: QZJ", "title: [QZJ", 1),
+		strings.Replace(spec, "  length: 3\n", "", 1),
+	} {
+		touch(t, s.SpecFile, broken, 3*time.Hour)
+		if _, err := s.reloadSchedule(); err == nil {
+			t.Errorf("expected error loading:\n%s", broken)
+		}
+		if s.Atom.Title != "QZJ Radio" || len(s.Atom.Episodes) != 2 || s.Atom.Episodes[1].Length != 3 {
+			t.Errorf("expected the previous atom to be kept, got %+v", s.Atom)
+		}
+	}
+}
+
+func TestServeSchedulePublishesAtPubDate(t *testing.T) {
+	fake, endpoint := newFakeS3(t)
+	next := time.Now().Truncate(time.Second).Add(2 * time.Second)
+	s, _ := scheduleTestShow(t, endpoint, next)
+
+	set := flag.NewFlagSet("serve-schedule", flag.ContinueOnError)
+	set.String("notify-command", "", "")
+	set.Duration("poll", 50*time.Millisecond, "")
+	c := cli.NewContext(cli.NewApp(), set, nil)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serveSchedule(c)
+	}()
+
+	feedContains := func(text string) bool {
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if o := fake.Object("pod", "podcast.rss"); o != nil && strings.Contains(string(o.Body), text) {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+	if !feedContains("<title>First</title>") {
+		t.Fatal("expected the feed to be published on start")
+	}
+	if o := fake.Object("pod", "podcast.rss"); strings.Contains(string(o.Body), "<title>Second</title>") {
+		t.Fatal("expected the future-dated episode to be left out of the feed")
+	}
+	if !feedContains("<title>Second</title>") {
+		t.Error("expected the feed to be published when the pubDate was reached")
+	} else if time.Now().Before(next) {
+		t.Error("expected the episode to be published no earlier than its pubDate")
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected serve-schedule to stop on SIGTERM")
+	}
+}
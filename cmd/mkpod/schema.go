@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// The keys of the spec, episode files and the workspace are checked
+// against the Go types they are decoded into. yaml.v3 silently ignores
+// keys it does not know (a misspelled encodinglanguage is lost), every
+// unknown key is reported with its line and column as a warning, or as
+// an error with --strict. The same walk over the types generates the
+// JSON Schema printed by mkpod schema for editors to autocomplete and
+// validate the spec.
+
+const (
+	schemaTypeSpec    string = "spec"
+	schemaTypeEpisode string = "episode"
+	jsonSchemaDraft   string = "https://json-schema.org/draft/2020-12/schema"
+)
+
+// Unmarshaler of yaml.v2, implemented by the types in types.go.
+type legacyUnmarshaler interface {
+	UnmarshalYAML(unmarshal func(interface{}) error) error
+}
+
+var (
+	yamlNodeType          = reflect.TypeOf(yaml.Node{})
+	yamlUnmarshalerType   = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	legacyUnmarshalerType = reflect.TypeOf((*legacyUnmarshaler)(nil)).Elem()
+)
+
+// yamlField is a field of a struct and its key.
+type yamlField struct {
+	Key  string
+	Type reflect.Type
+}
+
+// yamlFields returns the fields of struct type t as decoded by yaml.v3,
+// inline structs flattened.
+func yamlFields(t reflect.Type) []yamlField {
+	var fields []yamlField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if strings.Contains(","+options+",", ",inline,") {
+			fields = append(fields, yamlFields(derefType(f.Type))...)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields = append(fields, yamlField{Key: name, Type: f.Type})
+	}
+	return fields
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// customYAML returns true if t decodes itself (the keys it accepts are
+// not known from its fields).
+func customYAML(t reflect.Type) bool {
+	p := reflect.PointerTo(t)
+	return t == yamlNodeType || p.Implements(yamlUnmarshalerType) || p.Implements(legacyUnmarshalerType)
+}
+
+// UnknownKey is a key in yaml not matching any field of the type it is
+// decoded into.
+type UnknownKey struct {
+	// Path of the mapping the key is in, e.g episodes[2].
+	Path   string
+	Key    string
+	Line   int
+	Column int
+	// Known key differing only in case, if any.
+	Suggestion string
+}
+
+func (k UnknownKey) String() string {
+	where := "at the top level"
+	if k.Path != "" {
+		where = "in " + k.Path
+	}
+	s := fmt.Sprintf("%d:%d: unknown key %q %s", k.Line, k.Column, k.Key, where)
+	if k.Suggestion != "" {
+		s += fmt.Sprintf(", did you mean %q?", k.Suggestion)
+	}
+	return s
+}
+
+// unknownKeys returns the keys in n that type t does not have.
+func unknownKeys(n *yaml.Node, t reflect.Type, path string) []UnknownKey {
+	t = derefType(t)
+	if n == nil || customYAML(t) {
+		return nil
+	}
+	var unknown []UnknownKey
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			unknown = append(unknown, unknownKeys(c, t, path)...)
+		}
+		return unknown
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := make(map[string]reflect.Type)
+			for _, f := range yamlFields(t) {
+				fields[f.Key] = f.Type
+			}
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key, value := n.Content[i], n.Content[i+1]
+				if key.Tag == "!!merge" {
+					continue
+				}
+				fieldType, found := fields[key.Value]
+				if found {
+					unknown = append(unknown, unknownKeys(value, fieldType, joinKeyPath(path, key.Value))...)
+					continue
+				}
+				k := UnknownKey{Path: path, Key: key.Value, Line: key.Line, Column: key.Column}
+				for name := range fields {
+					if strings.EqualFold(name, key.Value) {
+						k.Suggestion = name
+					}
+				}
+				unknown = append(unknown, k)
+			}
+		case reflect.Map:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				unknown = append(unknown, unknownKeys(n.Content[i+1], t.Elem(), joinKeyPath(path, n.Content[i].Value))...)
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, c := range n.Content {
+				unknown = append(unknown, unknownKeys(c, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return unknown
+}
+
+func joinKeyPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// checkKeys reports the unknown keys of yaml n (at path in file) decoded
+// into v as warnings, or returns them as error if strict decoding is
+// enabled. lineOffset is added to the line numbers (e.g for front
+// matter).
+func checkKeys(file string, n *yaml.Node, v any, path string, lineOffset int) error {
+	var errs []error
+	for _, k := range unknownKeys(n, reflect.TypeOf(v), path) {
+		k.Line += lineOffset
+		if strictDecode {
+			errs = append(errs, fmt.Errorf("%s:%s", file, k))
+		} else {
+			log.Printf("WARNING: %s:%s", file, k)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkYAMLKeys is checkKeys for the yaml content b.
+func checkYAMLKeys(file string, b []byte, v any, lineOffset int) error {
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return checkKeys(file, &n, v, "", lineOffset)
+}
+
+// JSON Schema of the types decoding themselves.
+var customSchemas = map[reflect.Type]map[string]any{
+	reflect.TypeOf(ItunesTime{}): {
+		"type":        "string",
+		"description": "Date as RFC1123Z (" + time.RFC1123Z + "), now or today",
+	},
+	reflect.TypeOf(ItunesDuration{}): {
+		"type":        "string",
+		"pattern":     "^([0-9]+:[0-5][0-9]:[0-5][0-9]|gen|generate|parse)?$",
+		"description": "Duration as HH:MM:SS, generated when encoding if empty",
+	},
+	reflect.TypeOf(ItunesExplicit{}): {
+		"type": []string{"string", "boolean"},
+		"enum": []any{"yes", "no", "true", "false", true, false},
+	},
+}
+
+// schemaGenerator builds a JSON Schema, named types of this package are
+// put in $defs.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	t = derefType(t)
+	if s, found := customSchemas[t]; found {
+		g.defs[t.Name()] = s
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	if t == reflect.TypeOf(Category{}) {
+		// A category is a name, a name mapped to the subcategories or
+		// the fields of Category.
+		g.defs[t.Name()] = map[string]any{
+			"anyOf": []any{
+				map[string]any{"type": "string"},
+				g.structSchema(t),
+				map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					"maxProperties":        1,
+				},
+			},
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	if customYAML(t) {
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		named := t.Name() != "" && t.PkgPath() == reflect.TypeOf(Atom{}).PkgPath()
+		if named {
+			if _, found := g.defs[t.Name()]; found {
+				return map[string]any{"$ref": "#/$defs/" + t.Name()}
+			}
+			// Placeholder for recursive types.
+			g.defs[t.Name()] = map[string]any{}
+		}
+		s := g.structSchema(t)
+		if !named {
+			return s
+		}
+		g.defs[t.Name()] = s
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// structSchema returns the schema of struct type t, an object with the
+// fields of t as properties and no other.
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	for _, f := range yamlFields(t) {
+		properties[f.Key] = g.schema(f.Type)
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// jsonSchema returns the JSON Schema of the spec, or of an episode file
+// if schemaType is episode.
+func jsonSchema(schemaType string) (map[string]any, error) {
+	var root reflect.Type
+	title := "mkpod spec"
+	switch schemaType {
+	case schemaTypeSpec:
+		root = reflect.TypeOf(Atom{})
+	case schemaTypeEpisode:
+		root = reflect.TypeOf(Episode{})
+		title = "mkpod episode file"
+	default:
+		return nil, fmt.Errorf("unknown schema type %q, expected %s or %s", schemaType, schemaTypeSpec, schemaTypeEpisode)
+	}
+	g := &schemaGenerator{defs: make(map[string]any)}
+	ref := g.schema(root)
+	return map[string]any{
+		"$schema": jsonSchemaDraft,
+		"title":   title,
+		"$ref":    ref["$ref"],
+		"$defs":   g.defs,
+	}, nil
+}
+
+func schema(c *cli.Context) error {
+	s, err := jsonSchema(c.String("type"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(os.Stdout, s)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestUnknownKeys(t *testing.T) {
+	defer func(saved bool) { strictDecode = saved }(strictDecode)
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "podspec.yaml")
+	files := map[string]string{
+		spec:                                     "atom: podcast.rss\ndefaultpodimage: pod.jpg\nconfig:\n  aws:\n    regoin: eu-north-1\ncategories:\n- Technology\n- name: Leisure\n  subcategories: [Hobbies]\nepisodesDir: episodes\nepisodes:\n- uid: 1\n  title: First\n  encodinglanguage: sv\n  chapters:\n  - title: Intro\n    start: \"00:00:00.000\"\n",
+		filepath.Join(dir, "episodes", "two.md"): "---\nuid: 2\nsubtitel: Typo\n---\nNotes.\n",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, k := range unknownKeys(&n, reflect.TypeOf(Atom{}), "") {
+		keys = append(keys, k.String())
+	}
+	expected := []string{
+		`2:1: unknown key "defaultpodimage" at the top level`,
+		`5:5: unknown key "regoin" in config.aws`,
+		`14:3: unknown key "encodinglanguage" in episodes[0], did you mean "encodingLanguage"?`,
+	}
+	if strings.Join(keys, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(keys, "\n"))
+	}
+
+	strictDecode = false
+	if err := NewShow("", spec).loadConfig(); err != nil {
+		t.Errorf("expected warnings only, got %v", err)
+	}
+	strictDecode = true
+	err = NewShow("", spec).loadConfig()
+	if err == nil || !strings.Contains(err.Error(), spec+`:2:1: unknown key "defaultpodimage"`) {
+		t.Errorf("expected strict error, got %v", err)
+	}
+	if err := os.WriteFile(spec, []byte("atom: podcast.rss\nepisodesDir: episodes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = NewShow("", spec).loadConfig()
+	if err == nil || !strings.Contains(err.Error(), `two.md:3:1: unknown key "subtitel"`) {
+		t.Errorf("expected error with the line in the episode file, got %v", err)
+	}
+
+	workspace := filepath.Join(dir, defaultWorkspace)
+	if err := os.WriteFile(workspace, []byte("shows:\n- name: qzj\n  spec: podspec.yaml\n  config:\n    baseurl: https://example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadWorkspace(workspace)
+	if err == nil || !strings.Contains(err.Error(), `5:5: unknown key "baseurl" in shows[0].config, did you mean "baseURL"?`) {
+		t.Errorf("expected workspace error, got %v", err)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	for _, schemaType := range []string{schemaTypeSpec, schemaTypeEpisode} {
+		s, err := jsonSchema(schemaType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded struct {
+			Ref  string `json:"$ref"`
+			Defs map[string]struct {
+				Properties           map[string]json.RawMessage `json:"properties"`
+				AdditionalProperties *bool                      `json:"additionalProperties"`
+				AnyOf                []json.RawMessage          `json:"anyOf"`
+			} `json:"$defs"`
+		}
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		episode, found := decoded.Defs["Episode"]
+		if !found || episode.AdditionalProperties == nil || *episode.AdditionalProperties {
+			t.Fatalf("expected a closed Episode definition in %s schema", schemaType)
+		}
+		for _, key := range []string{"uid", "encodingLanguage", "chapters", "pubDate"} {
+			if _, found := episode.Properties[key]; !found {
+				t.Errorf("expected %s in the Episode definition", key)
+			}
+		}
+		if schemaType == schemaTypeEpisode {
+			if decoded.Ref != "#/$defs/Episode" {
+				t.Errorf("expected episode schema to refer to Episode, got %s", decoded.Ref)
+			}
+			continue
+		}
+		if decoded.Ref != "#/$defs/Atom" {
+			t.Errorf("expected spec schema to refer to Atom, got %s", decoded.Ref)
+		}
+		for _, key := range []string{"Config", "AwsConfig"} {
+			if _, found := decoded.Defs[key]; !found {
+				t.Errorf("expected %s in $defs", key)
+			}
+		}
+		if len(decoded.Defs["Category"].AnyOf) != 3 {
+			t.Errorf("expected a category to be a string, mapping or object")
+		}
+	}
+	if _, err := jsonSchema("podcast"); err == nil {
+		t.Error("expected error for unknown schema type")
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// A show is one podcast: the spec, the atom loaded from it and the state
+// of a command run for it (AWS session, templates). Commands run for the
+// spec given by --spec, or for shows listed in a workspace file selected
+// with --show or --all-shows. The workspace shares config and encoding
+// between the shows, a show in the workspace can override them and the
+// spec of the show overrides both.
+
+const defaultWorkspace string = "mkpod-workspace.yaml"
+
+// Show is a podcast and the state of the command run for it.
+type Show struct {
+	// Name in the workspace, empty for a spec given by --spec.
+	Name     string
+	SpecFile string
+	// Environment in the spec selected with --env, empty for none.
+	Environment string
+	Atom        Atom
+	Aws         AwsHandler
+	// Workspace the show is in (nil if none) and its entry.
+	workspace *Workspace
+	entry     *WorkspaceShow
+	// Templates in use, the defaults or loaded from the templates
+	// section of the spec.
+	rssTemplate                        string
+	lameCommandTemplate                string
+	ffmpegCommandTemplate              string
+	ffmpegToAudioCommandTemplate       string
+	ffmpegToM4ACommandTemplate         string
+	ffmpegPreProcessingCommandTemplate string
+	ffmpegRetagCommandTemplate         string
+	templates                          *Templates
+	// Template files given with --template by name, overriding the
+	// templates section.
+	templateFlags map[string]string
+	// Settings given as global flags (by flag name) and the source of
+	// each setting in the atom (see config.go).
+	overrides     map[string]string
+	configSources map[string]string
+	// Options of the command run.
+	opts RunOptions
+	// Fields in the atom have changed and should be written back.
+	updateAtom     bool
+	processCounter int
+}
+
+// NewShow returns a show of specFile with the default templates.
+func NewShow(name string, specFile string) *Show {
+	s := &Show{
+		Name:                               name,
+		SpecFile:                           specFile,
+		rssTemplate:                        defaultRSSTemplate,
+		lameCommandTemplate:                defaultLameCommandTemplate,
+		ffmpegCommandTemplate:              defaultFFmpegCommandTemplate,
+		ffmpegToAudioCommandTemplate:       defaultFFmpegToAudioCommandTemplate,
+		ffmpegToM4ACommandTemplate:         defaultFFmpegToM4ACommandTemplate,
+		ffmpegPreProcessingCommandTemplate: defaultFFmpegPreProcessingCommandTemplate,
+		ffmpegRetagCommandTemplate:         defaultFFmpegRetagCommandTemplate,
+		templates:                          &Templates{},
+	}
+	s.Aws.Atom = &s.Atom
+	s.Aws.opts = &s.opts
+	return s
+}
+
+// Workspace is a file listing shows sharing config and encoding.
+type Workspace struct {
+	// Config and encoding shared by every show.
+	Config   yaml.Node       `yaml:"config,omitempty"`
+	Encoding yaml.Node       `yaml:"encoding,omitempty"`
+	Shows    []WorkspaceShow `yaml:"shows"`
+	file     string
+}
+
+// WorkspaceShow is a show in the workspace.
+type WorkspaceShow struct {
+	Name string `yaml:"name"`
+	// Spec of the show relative to the workspace file.
+	Spec string `yaml:"spec"`
+	// Overrides of the shared config and encoding.
+	Config   yaml.Node `yaml:"config,omitempty"`
+	Encoding yaml.Node `yaml:"encoding,omitempty"`
+}
+
+// LoadWorkspace reads the workspace file.
+func LoadWorkspace(file string) (*Workspace, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	ws := &Workspace{file: file}
+	if err := yaml.Unmarshal(b, ws); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if err := checkYAMLKeys(file, b, Workspace{}, 0); err != nil {
+		return nil, err
+	}
+	if err := ws.checkKeys(); err != nil {
+		return nil, err
+	}
+	if len(ws.Shows) == 0 {
+		return nil, fmt.Errorf("no shows in %s", file)
+	}
+	names := make(map[string]bool)
+	for i, show := range ws.Shows {
+		switch {
+		case strings.TrimSpace(show.Name) == "":
+			return nil, fmt.Errorf("show %d in %s has no name", i+1, file)
+		case names[show.Name]:
+			return nil, fmt.Errorf("show %s is listed more than once in %s", show.Name, file)
+		case strings.TrimSpace(show.Spec) == "":
+			return nil, fmt.Errorf("show %s in %s has no spec", show.Name, file)
+		}
+		names[show.Name] = true
+	}
+	return ws, nil
+}
+
+// checkKeys checks the keys of the shared config and encoding and of
+// the overrides of each show.
+func (ws *Workspace) checkKeys() error {
+	var errs []error
+	check := func(config *yaml.Node, encoding *yaml.Node, path string) {
+		errs = append(errs, checkKeys(ws.file, config, Config{}, joinKeyPath(path, "config"), 0))
+		errs = append(errs, checkKeys(ws.file, encoding, Atom{}.Encoding, joinKeyPath(path, "encoding"), 0))
+	}
+	check(&ws.Config, &ws.Encoding, "")
+	for i := range ws.Shows {
+		check(&ws.Shows[i].Config, &ws.Shows[i].Encoding, fmt.Sprintf("shows[%d]", i))
+	}
+	return errors.Join(errs...)
+}
+
+// Names returns the names of the shows in the workspace.
+func (ws *Workspace) Names() []string {
+	var names []string
+	for _, show := range ws.Shows {
+		names = append(names, show.Name)
+	}
+	return names
+}
+
+// Show returns the show called name.
+func (ws *Workspace) Show(name string) (*Show, error) {
+	for i := range ws.Shows {
+		entry := &ws.Shows[i]
+		if entry.Name != name {
+			continue
+		}
+		spec := resolvetilde(entry.Spec)
+		if !filepath.IsAbs(spec) {
+			spec = filepath.Join(filepath.Dir(ws.file), spec)
+		}
+		s := NewShow(entry.Name, spec)
+		s.workspace, s.entry = ws, entry
+		return s, nil
+	}
+	return nil, fmt.Errorf("there is no show %s in %s (shows: %s)", name, ws.file, strings.Join(ws.Names(), ", "))
+}
+
+// otherShows returns the other shows in the workspace of s loaded with
+// the same environment and overrides, none without a workspace. Shows
+// in a workspace can share localStorageDir and buckets.
+func (s *Show) otherShows() ([]*Show, error) {
+	if s.workspace == nil {
+		return nil, nil
+	}
+	var shows []*Show
+	for _, name := range s.workspace.Names() {
+		if name == s.Name {
+			continue
+		}
+		other, err := s.workspace.Show(name)
+		if err != nil {
+			return nil, err
+		}
+		other.overrides, other.Environment = s.overrides, s.Environment
+		if err := other.loadConfig(); err != nil {
+			return nil, fmt.Errorf("unable to load show %s in %s: %w", name, s.workspace.file, err)
+		}
+		shows = append(shows, other)
+	}
+	return shows, nil
+}
+
+// decodeSpec returns the atom of the spec content b decoded over the
+// shared config and encoding of the workspace, with the local spec,
+// environment and flags layered on top and the defaults set.
+func (s *Show) decodeSpec(b []byte) (Atom, error) {
+	var a Atom
+	sources := make(map[string]string)
+	if s.workspace != nil {
+		if err := decodeConfigLayer(&a, &s.workspace.Config, &s.workspace.Encoding, s.workspace.file, sources); err != nil {
+			return a, err
+		}
+		if err := decodeConfigLayer(&a, &s.entry.Config, &s.entry.Encoding, fmt.Sprintf("%s (show %s)", s.workspace.file, s.Name), sources); err != nil {
+			return a, err
+		}
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return a, err
+	}
+	if doc.Kind != 0 {
+		if err := doc.Decode(&a); err != nil {
+			return a, err
+		}
+	}
+	recordSources(lookupNode(&doc, "config"), lookupNode(&doc, "encoding"), s.SpecFile, sources)
+	if err := s.configLayers(&a, &doc, sources); err != nil {
+		return a, err
+	}
+	setDefaults(&a)
+	s.configSources = sources
+	return a, nil
+}
+
+// showFlags returns the flags selecting shows in a workspace.
+func showFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "workspace",
+			Aliases: []string{"w"},
+			Value:   defaultWorkspace,
+			Usage:   "Workspace file listing the shows, used if it exists and --spec is not given",
+		},
+		&cli.StringSliceFlag{
+			Name:  "show",
+			Usage: "Name of the show in the workspace to run the command for (can be repeated)",
+		},
+		&cli.BoolFlag{
+			Name:  "all-shows",
+			Usage: "Run the command for every show in the workspace",
+		},
+	}
+}
+
+// selectShows returns the shows selected by the --spec, --workspace,
+// --show and --all-shows flags. Without a workspace (or with --spec) it
+// is the show of the spec.
+func selectShows(c *cli.Context) ([]*Show, error) {
+	selecting := c.IsSet("show") || c.Bool("all-shows")
+	if c.IsSet("spec") && !selecting {
+		return withOverrides([]*Show{NewShow("", c.String("spec"))}, c), nil
+	}
+	file := c.String("workspace")
+	if file == "" {
+		file = defaultWorkspace
+	}
+	ws, err := LoadWorkspace(file)
+	if errors.Is(err, fs.ErrNotExist) && !c.IsSet("workspace") {
+		if selecting {
+			return nil, fmt.Errorf("--show and --all-shows need a workspace, %s does not exist", file)
+		}
+		return withOverrides([]*Show{NewShow("", c.String("spec"))}, c), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	switch {
+	case c.Bool("all-shows"):
+		names = ws.Names()
+	case c.IsSet("show"):
+		names = c.StringSlice("show")
+	case len(ws.Shows) == 1:
+		names = ws.Names()
+	default:
+		return nil, fmt.Errorf("select a show in %s with --show or use --all-shows (shows: %s)", file, strings.Join(ws.Names(), ", "))
+	}
+	var shows []*Show
+	for _, name := range names {
+		s, err := ws.Show(name)
+		if err != nil {
+			return nil, err
+		}
+		shows = append(shows, s)
+	}
+	return withOverrides(shows, c), nil
+}
+
+// withOverrides sets the environment and the settings given as global
+// flags on shows.
+func withOverrides(shows []*Show, c *cli.Context) []*Show {
+	overrides := configOverrides(c)
+	environment := selectedEnvironment(c)
+	for _, s := range shows {
+		s.overrides = overrides
+		s.Environment = environment
+	}
+	return shows
+}
+
+// showAction returns a command action running action for each selected
+// show.
+func showAction(action func(s *Show, c *cli.Context) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		shows, err := selectShows(c)
+		if err != nil {
+			return err
+		}
+		for _, s := range shows {
+			if len(shows) > 1 {
+				log.Printf("Show %s (%s)", s.Name, s.SpecFile)
+			}
+			if err := action(s, c); err != nil {
+				if s.Name != "" {
+					return fmt.Errorf("show %s: %w", s.Name, err)
+				}
+				return err
+			}
+		}
+		return nil
+	}
+}
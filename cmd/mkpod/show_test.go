@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+const showTestWorkspace = `config:
+  baseURL: https://shared.example
+  localStorageDir: ~/pods
+  aws:
+    region: eu-north-1
+    buckets:
+      input: masters
+encoding:
+  bitrate: 128
+shows:
+- name: qzj
+  spec: qzj/podspec.yaml
+  config:
+    aws:
+      buckets:
+        output: qzj-output
+- name: video
+  spec: video/podspec.yaml
+  encoding:
+    bitrate: 192
+`
+
+func showTestContext(t *testing.T, workspace string, args ...string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("spec", defaultSpec, "")
+	for _, f := range showFlags() {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse(append([]string{"--workspace", workspace}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func TestWorkspaceShows(t *testing.T) {
+	dir := t.TempDir()
+	workspace := filepath.Join(dir, defaultWorkspace)
+	files := map[string]string{
+		workspace: showTestWorkspace,
+		filepath.Join(dir, "qzj", "podspec.yaml"):   "# QZJ\natom: qzj.rss\nconfig:\n  baseURL: https://qzj.example\nepisodes: []\n",
+		filepath.Join(dir, "video", "podspec.yaml"): "atom: video.rss\nepisodes: []\n",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := selectShows(showTestContext(t, workspace)); err == nil || !strings.Contains(err.Error(), "qzj, video") {
+		t.Errorf("expected error listing the shows, got %v", err)
+	}
+	if _, err := selectShows(showTestContext(t, workspace, "--show", "nope")); err == nil {
+		t.Error("expected error for unknown show")
+	}
+	shows, err := selectShows(showTestContext(t, workspace, "--all-shows"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shows) != 2 || shows[0].Name != "qzj" || shows[1].SpecFile != filepath.Join(dir, "video", "podspec.yaml") {
+		t.Fatalf("unexpected shows %+v", shows)
+	}
+	for _, s := range shows {
+		if err := s.loadConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	qzj, video := shows[0], shows[1]
+	if qzj.Atom.Config.BaseURL != "https://qzj.example" || video.Atom.Config.BaseURL != "https://shared.example" {
+		t.Errorf("expected baseURL of the spec over the shared one, got %s and %s", qzj.Atom.Config.BaseURL, video.Atom.Config.BaseURL)
+	}
+	if qzj.Atom.Config.Aws.Buckets.Output != "qzj-output" || qzj.Atom.Config.Aws.Buckets.Input != "masters" || qzj.Atom.Config.Aws.Region != "eu-north-1" {
+		t.Errorf("expected per-show override merged with shared aws config, got %+v", qzj.Atom.Config.Aws)
+	}
+	if qzj.Atom.Encoding.Bitrate != 128 || video.Atom.Encoding.Bitrate != 192 {
+		t.Errorf("expected bitrates 128 and 192, got %d and %d", qzj.Atom.Encoding.Bitrate, video.Atom.Encoding.Bitrate)
+	}
+	if qzj.Aws.Atom != &qzj.Atom || video.Aws.Atom != &video.Atom {
+		t.Error("expected the AWS handler of each show to use the atom of the show")
+	}
+
+	// Shared config is not written into the spec of the show.
+	qzj.Atom.Title = "QZJ"
+	b, err := qzj.specBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "# QZJ\natom: qzj.rss\ntitle: QZJ\nconfig:\n  baseURL: https://qzj.example\nepisodes: []\n"; string(b) != expected {
+		t.Errorf("expected spec:\n%s\ngot:\n%s", expected, b)
+	}
+
+	// --spec bypasses the workspace.
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("spec", "", "")
+	for _, f := range showFlags() {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse([]string{"--workspace", workspace, "--spec", "other.yaml"}); err != nil {
+		t.Fatal(err)
+	}
+	shows, err = selectShows(cli.NewContext(cli.NewApp(), set, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shows) != 1 || shows[0].SpecFile != "other.yaml" || shows[0].Name != "" {
+		t.Errorf("expected the show of --spec, got %+v", shows)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sa6mwa/id3v24"
+)
+
+// Sidecars are the files published next to the output file of an
+// episode: the transcript (from the input bucket) and the chapters as a
+// Podcasting 2.0 JSON chapters file generated from episode.chapters.
+// They are uploaded with the transcripts and chapters upload policies
+// and referenced from the rss feed as <podcast:transcript> and
+// <podcast:chapters>. See
+// https://github.com/Podcastindex-org/podcast-namespace/blob/main/chapters/jsonChapters.md
+
+const (
+	chaptersFileSuffix  string = ".chapters.json"
+	chaptersContentType string = "application/json+chapters"
+	chaptersVersion     string = "1.2.0"
+)
+
+// PodcastChapters is the content of a JSON chapters file.
+type PodcastChapters struct {
+	Version  string           `json:"version"`
+	Chapters []PodcastChapter `json:"chapters"`
+}
+
+type PodcastChapter struct {
+	StartTime float64 `json:"startTime"`
+	Title     string  `json:"title"`
+}
+
+// ChaptersFile returns the name of the JSON chapters file of the
+// episode, the output file with the extension replaced by
+// .chapters.json, or empty string if the episode has no chapters or no
+// output.
+func (e *Episode) ChaptersFile() string {
+	if len(e.Chapters) == 0 || strings.TrimSpace(e.Output) == "" {
+		return ""
+	}
+	return strings.TrimSuffix(e.Output, path.Ext(e.Output)) + chaptersFileSuffix
+}
+
+// TranscriptType returns the content type of the transcript of the
+// episode by extension, text/plain if not WebVTT, SubRip, JSON or HTML.
+func (e *Episode) TranscriptType() string {
+	switch strings.ToLower(path.Ext(e.Transcript)) {
+	case ".vtt":
+		return "text/vtt"
+	case ".srt":
+		return "application/x-subrip"
+	case ".json":
+		return "application/json"
+	case ".html", ".htm":
+		return "text/html"
+	}
+	return "text/plain"
+}
+
+// ChaptersJSON returns chapters as a JSON chapters file.
+func ChaptersJSON(chapters []id3v24.Chapter) ([]byte, error) {
+	pc := PodcastChapters{Version: chaptersVersion, Chapters: []PodcastChapter{}}
+	for _, c := range chapters {
+		t, err := id3v24.StringTimeToTime(c.Start)
+		if err != nil {
+			return nil, fmt.Errorf("chapter %q: %w", c.Title, err)
+		}
+		seconds := float64(t.Hour()*3600+t.Minute()*60+t.Second()) + float64(t.Nanosecond())/1e9
+		pc.Chapters = append(pc.Chapters, PodcastChapter{StartTime: seconds, Title: c.Title})
+	}
+	return json.MarshalIndent(pc, "", "  ")
+}
+
+// uploadSidecars uploads the transcript and the chapters file of
+// episode to target, the chapters file is written to localStorageDir
+// first. The transcript has to be in localStorageDir (downloaded from
+// the input bucket).
+func (s *Show) uploadSidecars(episode *Episode, target FeedTarget) error {
+	if strings.TrimSpace(episode.Transcript) != "" {
+		file := path.Join(s.Atom.LocalStorageDirExpanded(), episode.Transcript)
+		if err := s.Aws.Upload(ObjectKindTranscripts, target.Bucket, target.Key(episode.Transcript), episode.TranscriptType(), file); err != nil {
+			return err
+		}
+	}
+	if name := episode.ChaptersFile(); name != "" {
+		b, err := ChaptersJSON(episode.Chapters)
+		if err != nil {
+			return fmt.Errorf("uid %d: %w", episode.UID, err)
+		}
+		file := path.Join(s.Atom.LocalStorageDirExpanded(), name)
+		if err := os.WriteFile(file, b, 0644); err != nil {
+			return err
+		}
+		log.Printf("Wrote chapters of UID %d to %s", episode.UID, file)
+		if err := s.Aws.Upload(ObjectKindChapters, target.Bucket, target.Key(name), chaptersContentType, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sa6mwa/id3v24"
+)
+
+func TestUploadSidecars(t *testing.T) {
+	fake, endpoint := newFakeS3(t)
+
+	dir := t.TempDir()
+	s := NewShow("", "")
+	s.Atom.Config.LocalStorageDir = dir
+	s.Atom.Config.Aws.Region = "eu-north-1"
+	s.Atom.Config.Aws.Endpoint = endpoint
+	s.Atom.Config.Aws.Buckets.Output = "pod"
+	s.Atom.Config.UploadPolicies.Transcripts = UploadPolicy{CacheControl: "max-age=60", Metadata: map[string]string{"kind": "transcript"}}
+	s.Atom.Config.UploadPolicies.Chapters = UploadPolicy{CacheControl: "max-age=120"}
+	episode := &Episode{
+		UID:        1,
+		Output:     "qzj001.mp3",
+		Transcript: "qzj001.vtt",
+		Chapters:   []id3v24.Chapter{{Title: "Intro", Start: "00:00:00.000"}, {Title: "Antennas", Start: "00:01:30.500"}},
+	}
+	if err := os.WriteFile(filepath.Join(dir, episode.Transcript), []byte("WEBVTT\n\n00:00.000 --> 00:01.000\nHej\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.Aws.NewSession()
+	if err := s.uploadSidecars(episode, s.Atom.ProductionTarget()); err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := fake.Object("pod", "qzj001.vtt")
+	if transcript == nil || transcript.Header.Get("Content-Type") != "text/vtt" || transcript.Header.Get("Cache-Control") != "max-age=60" || transcript.Header.Get("X-Amz-Meta-Kind") != "transcript" {
+		t.Errorf("expected transcript uploaded with the transcripts policy, got %v", transcript)
+	}
+	chapters := fake.Object("pod", "qzj001.chapters.json")
+	if chapters == nil || chapters.Header.Get("Content-Type") != chaptersContentType || chapters.Header.Get("Cache-Control") != "max-age=120" {
+		t.Errorf("expected chapters uploaded with the chapters policy, got %v", chapters)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, episode.ChaptersFile()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pc PodcastChapters
+	if err := json.Unmarshal(b, &pc); err != nil {
+		t.Fatal(err)
+	}
+	if pc.Version != chaptersVersion || len(pc.Chapters) != 2 || pc.Chapters[1].StartTime != 90.5 || pc.Chapters[1].Title != "Antennas" {
+		t.Errorf("unexpected chapters %+v", pc)
+	}
+
+	s.Atom.Atom = "podcast.rss"
+	s.Atom.Config.BaseURL = "https://example.com"
+	episode.PubDate = ItunesTime{time.Now().Add(-time.Hour)}
+	s.Atom.Episodes = []Episode{*episode}
+	feed, err := s.renderFeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<podcast:transcript url="https://example.com/qzj001.vtt" type="text/vtt"/>`,
+		`<podcast:chapters url="https://example.com/qzj001.chapters.json" type="application/json+chapters"/>`,
+	} {
+		if !strings.Contains(string(feed), expected) {
+			t.Errorf("expected %s in the feed:\n%s", expected, feed)
+		}
+	}
+}
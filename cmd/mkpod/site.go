@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sa6mwa/id3v24"
+	"github.com/urfave/cli/v2"
+)
+
+// The site command renders a static website of the podcast; an index
+// page with the latest episodes, one page per published episode (with
+// player, show notes, chapters and transcript) and an archive page. The
+// pages are rendered from html/templates embedded in mkpod, any of
+// which can be overridden by a file with the same name in
+// config.site.templates (or the --templates option).
+
+//go:embed site
+var siteFS embed.FS
+
+const (
+	defaultSiteOutputDir     string = "site"
+	defaultSitePrefix        string = "site"
+	defaultSiteIndexEpisodes int    = 10
+)
+
+// Page templates (each parsed together with the layout) and static
+// files copied as is.
+var (
+	sitePages       = []string{"index.html", "episode.html", "archive.html"}
+	siteLayout      = "layout.html"
+	siteStaticFiles = []string{"style.css"}
+)
+
+type SiteConfig struct {
+	// Public URL of the site, defaults to baseURL with the prefix
+	// appended.
+	BaseURL string `yaml:"baseURL,omitempty"`
+	// Key prefix of the site in the output bucket (default site).
+	Prefix string `yaml:"prefix,omitempty"`
+	// Local directory the site is rendered into (default site).
+	OutputDir string `yaml:"outputDir,omitempty"`
+	// Directory with templates overriding the embedded ones, e.g
+	// episode.html or style.css.
+	Templates string `yaml:"templates,omitempty"`
+	// Number of episodes on the index page (default 10).
+	IndexEpisodes int `yaml:"indexEpisodes,omitempty"`
+}
+
+// SiteEnabled returns true if config.site is configured.
+func (c *Config) SiteEnabled() bool {
+	s := c.Site
+	return strings.TrimSpace(s.BaseURL) != "" || strings.TrimSpace(s.Prefix) != "" ||
+		strings.TrimSpace(s.OutputDir) != "" || strings.TrimSpace(s.Templates) != ""
+}
+
+func (s *SiteConfig) PrefixOrDefault() string {
+	if strings.TrimSpace(s.Prefix) == "" {
+		return defaultSitePrefix
+	}
+	return strings.Trim(s.Prefix, "/")
+}
+
+func (s *SiteConfig) OutputDirOrDefault() string {
+	if strings.TrimSpace(s.OutputDir) == "" {
+		return defaultSiteOutputDir
+	}
+	return resolvetilde(s.OutputDir)
+}
+
+func (s *SiteConfig) IndexEpisodesOrDefault() int {
+	if s.IndexEpisodes < 1 {
+		return defaultSiteIndexEpisodes
+	}
+	return s.IndexEpisodes
+}
+
+// SiteURL returns the public URL of the site.
+func (a *Atom) SiteURL() string {
+	if strings.TrimSpace(a.Config.Site.BaseURL) != "" {
+		return strings.TrimSuffix(a.Config.Site.BaseURL, "/")
+	}
+	return strings.TrimSuffix(a.Config.BaseURL, "/") + "/" + a.Config.Site.PrefixOrDefault()
+}
+
+// EpisodePage returns the file name of the page of episode, the base
+// name of the output file (e.g qzj023-title.html) or episode-UID.html
+// if there is no output file yet.
+func (a *Atom) EpisodePage(episode *Episode) string {
+	name := strings.TrimSuffix(path.Base(episode.Output), path.Ext(episode.Output))
+	if strings.TrimSpace(episode.Output) == "" || name == "" || name == "." {
+		name = fmt.Sprintf("episode-%d", episode.UID)
+	}
+	return name + ".html"
+}
+
+// EpisodeLink returns the link of episode. If the link is empty and
+// config.site is configured, the URL of the episode page is returned.
+func (a *Atom) EpisodeLink(episode *Episode) string {
+	if strings.TrimSpace(episode.Link) != "" || !a.Config.SiteEnabled() {
+		return episode.Link
+	}
+	return a.SiteURL() + "/" + a.EpisodePage(episode)
+}
+
+// ChannelLink returns the link of the podcast, the site URL if link is
+// empty and config.site is configured.
+func (a *Atom) ChannelLink() string {
+	if strings.TrimSpace(a.Link) != "" || !a.Config.SiteEnabled() {
+		return a.Link
+	}
+	return a.SiteURL() + "/index.html"
+}
+
+// SiteData is passed to every site template.
+type SiteData struct {
+	Atom    *Atom
+	URL     string
+	FeedURL string
+	// Published episodes, latest first.
+	Episodes []*SiteEpisode
+	// The first config.site.indexEpisodes episodes (index page).
+	Latest []*SiteEpisode
+	// Episodes grouped by year, latest first (archive page).
+	Archive []SiteYear
+	// The episode being rendered (episode page), nil on other pages.
+	Episode *SiteEpisode
+}
+
+type SiteYear struct {
+	Year     int
+	Episodes []*SiteEpisode
+}
+
+type SiteEpisode struct {
+	Episode  *Episode
+	Page     string
+	URL      string
+	MediaURL string
+	ImageURL string
+	Video    bool
+	// Description rendered from markdown.
+	ShowNotes  template.HTML
+	Chapters   []SiteChapter
+	Transcript string
+}
+
+type SiteChapter struct {
+	Title string
+	// Formatted start, e.g 04:05 or 01:04:05.
+	Start string
+	// Start in seconds, used to seek the player.
+	Seconds float64
+}
+
+// siteChapters returns chapters with the start in seconds. Returns nil
+// if a start time can not be parsed.
+func siteChapters(chapters []id3v24.Chapter) []SiteChapter {
+	var longFormat bool
+	var starts []time.Time
+	for _, c := range chapters {
+		s, err := id3v24.StringTimeToTime(c.Start)
+		if err != nil {
+			log.Printf("WARNING: Unable to parse chapter start %q: %v", c.Start, err)
+			return nil
+		}
+		if s.Hour() > 0 {
+			longFormat = true
+		}
+		starts = append(starts, s)
+	}
+	var output []SiteChapter
+	for i, c := range chapters {
+		s := starts[i]
+		format := "04:05"
+		if longFormat {
+			format = "15:04:05"
+		}
+		seconds := time.Duration(s.Hour())*time.Hour + time.Duration(s.Minute())*time.Minute +
+			time.Duration(s.Second())*time.Second + time.Duration(s.Nanosecond())
+		output = append(output, SiteChapter{
+			Title:   strings.TrimSpace(c.Title),
+			Start:   s.Format(format),
+			Seconds: seconds.Seconds(),
+		})
+	}
+	return output
+}
+
+// siteTemplateFile returns the content of name from the templates
+// directory if it exists there, otherwise the embedded default.
+func siteTemplateFile(templatesDir string, name string) ([]byte, error) {
+	if strings.TrimSpace(templatesDir) != "" {
+		b, err := os.ReadFile(filepath.Join(resolvetilde(templatesDir), name))
+		if err == nil {
+			return b, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return siteFS.ReadFile(path.Join("site", name))
+}
+
+// siteTemplates parses every page template together with the layout.
+func siteTemplates(templatesDir string) (map[string]*template.Template, error) {
+	layout, err := siteTemplateFile(templatesDir, siteLayout)
+	if err != nil {
+		return nil, err
+	}
+	funcMap := template.FuncMap{
+		"markdown": func(s string) template.HTML {
+			return template.HTML(MarkdownToHTML(s))
+		},
+	}
+	templates := make(map[string]*template.Template)
+	for _, page := range sitePages {
+		b, err := siteTemplateFile(templatesDir, page)
+		if err != nil {
+			return nil, err
+		}
+		t, err := template.New(siteLayout).Funcs(funcMap).Parse(string(layout))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", siteLayout, err)
+		}
+		if _, err := t.New(page).Parse(string(b)); err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", page, err)
+		}
+		templates[page] = t
+	}
+	return templates, nil
+}
+
+// siteEpisodes returns the episodes of the production feed, latest
+// first. The transcript of each episode is read from localStorageDir.
+func (s *Show) siteEpisodes(now time.Time) []*SiteEpisode {
+	var episodes []*SiteEpisode
+	for i := range s.Atom.Episodes {
+		e := &s.Atom.Episodes[i]
+		if e.Staged || !isAfter(now, e.PubDate.Time) || strings.TrimSpace(e.Output) == "" {
+			continue
+		}
+		target := s.Atom.EpisodeTarget(e)
+		se := &SiteEpisode{
+			Episode:   e,
+			Page:      s.Atom.EpisodePage(e),
+			URL:       s.Atom.SiteURL() + "/" + s.Atom.EpisodePage(e),
+			MediaURL:  target.URL(target.Key(e.Output)),
+			Video:     strings.HasPrefix(e.Type, "video/"),
+			ShowNotes: template.HTML(MarkdownToHTML(e.Description)),
+			Chapters:  siteChapters(e.Chapters),
+		}
+		if strings.TrimSpace(e.Image) != "" {
+			se.ImageURL = target.URL(target.Key(e.Image))
+		}
+		if strings.TrimSpace(e.Transcript) != "" {
+			b, err := os.ReadFile(path.Join(s.Atom.LocalStorageDirExpanded(), e.Transcript))
+			if err != nil {
+				log.Printf("WARNING: Unable to read transcript of episode with uid %d: %v", e.UID, err)
+			} else {
+				se.Transcript = TranscriptToText(b)
+			}
+		}
+		episodes = append(episodes, se)
+	}
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return episodes[i].Episode.PubDate.After(episodes[j].Episode.PubDate.Time)
+	})
+	return episodes
+}
+
+// newSiteData returns the data of the site at now.
+func (s *Show) newSiteData(now time.Time) SiteData {
+	data := SiteData{
+		Atom:     &s.Atom,
+		URL:      s.Atom.SiteURL(),
+		FeedURL:  s.Atom.FeedURL(),
+		Episodes: s.siteEpisodes(now),
+	}
+	data.Latest = data.Episodes
+	if n := s.Atom.Config.Site.IndexEpisodesOrDefault(); len(data.Latest) > n {
+		data.Latest = data.Latest[:n]
+	}
+	for _, e := range data.Episodes {
+		year := e.Episode.PubDate.Year()
+		if len(data.Archive) == 0 || data.Archive[len(data.Archive)-1].Year != year {
+			data.Archive = append(data.Archive, SiteYear{Year: year})
+		}
+		last := &data.Archive[len(data.Archive)-1]
+		last.Episodes = append(last.Episodes, e)
+	}
+	return data
+}
+
+// renderSite renders the site into outputDir and returns the names of
+// the written files relative to outputDir.
+func (s *Show) renderSite(outputDir string, templatesDir string, now time.Time) ([]string, error) {
+	templates, err := siteTemplates(templatesDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, err
+	}
+	data := s.newSiteData(now)
+	var files []string
+	write := func(name string, page string, data SiteData) error {
+		buf := &bytes.Buffer{}
+		if err := templates[page].ExecuteTemplate(buf, page, data); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(outputDir, name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		files = append(files, name)
+		return nil
+	}
+	if err := write("index.html", "index.html", data); err != nil {
+		return nil, err
+	}
+	if err := write("archive.html", "archive.html", data); err != nil {
+		return nil, err
+	}
+	for _, e := range data.Episodes {
+		episodeData := data
+		episodeData.Episode = e
+		if err := write(e.Page, "episode.html", episodeData); err != nil {
+			return nil, fmt.Errorf("unable to render page of episode with uid %d: %w", e.Episode.UID, err)
+		}
+	}
+	for _, name := range siteStaticFiles {
+		b, err := siteTemplateFile(templatesDir, name)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(outputDir, name), b, 0644); err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// SiteKeys returns the keys in the output bucket of every page and
+// static file of the site.
+func (a *Atom) SiteKeys() []string {
+	prefix := a.Config.Site.PrefixOrDefault()
+	var keys []string
+	for _, name := range append([]string{"index.html", "archive.html"}, siteStaticFiles...) {
+		keys = append(keys, path.Join(prefix, name))
+	}
+	for i := range a.Episodes {
+		keys = append(keys, path.Join(prefix, a.EpisodePage(&a.Episodes[i])))
+	}
+	return keys
+}
+
+func (s *Show) siter(c *cli.Context) error {
+	s.opts.Force = c.Bool("force")
+
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+	outputDir := s.Atom.Config.Site.OutputDirOrDefault()
+	if c.IsSet("output-dir") {
+		outputDir = c.String("output-dir")
+	}
+	templatesDir := s.Atom.Config.Site.Templates
+	if c.IsSet("templates") {
+		templatesDir = c.String("templates")
+	}
+
+	upload := c.Bool("upload")
+	if upload {
+		s.Aws.NewSession()
+		if err := s.createLocalStorageDir(); err != nil {
+			return err
+		}
+		// Transcripts are rendered from localStorageDir.
+		now := time.Now()
+		for _, e := range s.Atom.Episodes {
+			if e.Staged || !isAfter(now, e.PubDate.Time) || strings.TrimSpace(e.Transcript) == "" {
+				continue
+			}
+			if err := s.Aws.Download(s.Atom.Config.Aws.Buckets.Input, e.Transcript); err != nil {
+				return err
+			}
+		}
+	}
+
+	files, err := s.renderSite(outputDir, templatesDir, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("Successfully generated %d files in %s", len(files), outputDir)
+
+	if !upload {
+		return nil
+	}
+	bucket := s.Atom.Config.Aws.Buckets.Output
+	prefix := s.Atom.Config.Site.PrefixOrDefault()
+	if !s.opts.doAction("Upload %d files to s3://%s?", len(files), path.Join(bucket, prefix)) {
+		return nil
+	}
+	for _, name := range files {
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		if err := s.Aws.Upload(ObjectKindSite, bucket, path.Join(prefix, name), contentType, filepath.Join(outputDir, name)); err != nil {
+			return err
+		}
+	}
+	log.Printf("Published %s", s.Atom.SiteURL()+"/index.html")
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sa6mwa/id3v24"
+)
+
+func TestRenderSite(t *testing.T) {
+	s := NewShow("", "")
+
+	storage := t.TempDir()
+	if err := os.WriteFile(filepath.Join(storage, "qzj001.vtt"), []byte("WEBVTT\n\n00:00:01.000 --> 00:00:03.000\nHello listener\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.Atom = Atom{
+		Atom:     "podcast.rss",
+		Title:    "QZJ",
+		Language: "sv",
+	}
+	s.Atom.Config.BaseURL = "https://qzj.se"
+	s.Atom.Config.LocalStorageDir = storage
+	s.Atom.Config.Site.Prefix = "audio"
+	s.Atom.Episodes = []Episode{
+		{
+			UID:         2,
+			Title:       "Future",
+			PubDate:     ItunesTime{now.Add(24 * time.Hour)},
+			Output:      "qzj002-future.mp3",
+			Type:        "audio/mpeg",
+			Description: "Not yet",
+		},
+		{
+			UID:         1,
+			Title:       "First",
+			PubDate:     ItunesTime{now.Add(-24 * time.Hour)},
+			Output:      "qzj001-first.mp3",
+			Type:        "audio/mpeg",
+			Description: "Show *notes*",
+			Transcript:  "qzj001.vtt",
+			Chapters: []id3v24.Chapter{
+				{Title: "Intro", Start: "00:00:00.000"},
+				{Title: "Topic", Start: "00:01:30.500"},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	files, err := s.renderSite(dir, "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := []string{"index.html", "archive.html", "qzj001-first.html", "style.css"}
+	if strings.Join(files, ",") != strings.Join(expectedFiles, ",") {
+		t.Fatalf("expected files %v, got %v", expectedFiles, files)
+	}
+	page, err := os.ReadFile(filepath.Join(dir, "qzj001-first.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<audio id="player" controls preload="metadata" src="https://qzj.se/qzj001-first.mp3">`,
+		`<em>notes</em>`,
+		`data-seek="90.5">01:30</a> Topic`,
+		`Hello listener`,
+	} {
+		if !strings.Contains(string(page), expected) {
+			t.Errorf("expected episode page to contain %s", expected)
+		}
+	}
+	index, err := os.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(index), `href="qzj001-first.html"`) || strings.Contains(string(index), "Future") {
+		t.Error("expected index to link the published episode only")
+	}
+
+	if got, expected := s.Atom.EpisodeLink(&s.Atom.Episodes[1]), "https://qzj.se/audio/qzj001-first.html"; got != expected {
+		t.Errorf("expected derived link %s, got %s", expected, got)
+	}
+	s.Atom.Episodes[1].Link = "https://example.com/first"
+	if got := s.Atom.EpisodeLink(&s.Atom.Episodes[1]); got != "https://example.com/first" {
+		t.Errorf("expected explicit link to be kept, got %s", got)
+	}
+}
+
+func TestSiteTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(`custom {{ .Atom.Title }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := siteTemplateFile(dir, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `custom {{ .Atom.Title }}` {
+		t.Errorf("expected overridden template, got %s", b)
+	}
+	b, err = siteTemplateFile(dir, "episode.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `template "player"`) {
+		t.Error("expected embedded episode.html when not overridden")
+	}
+}
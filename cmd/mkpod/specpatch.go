@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Write-back of the spec. Instead of marshalling the whole atom (which
+// drops comments, reorders keys and changes the style of scalars), the
+// original spec is parsed into a yaml.v3 node tree and compared with the
+// atom encoded as a node tree. Only the lines of key/value pairs that
+// changed are re-rendered, every other line (comments, blank lines,
+// block scalars, anchors) is kept as is. Whether a value changed is
+// decided against the original spec loaded into an atom and encoded
+// again (the baseline), keys the atom does not know about are left alone.
+// Episodes are matched by uid, new episodes are inserted where they are
+// in the atom and removed episodes are deleted.
+
+// lineEdit replaces lines start to end (1-based, inclusive) with lines.
+// An edit where end is start-1 inserts lines before start.
+type lineEdit struct {
+	start int
+	end   int
+	lines []string
+	seq   int
+}
+
+func (e lineEdit) insertion() bool {
+	return e.end < e.start
+}
+
+type specPatcher struct {
+	lines []string
+	edits []lineEdit
+}
+
+// patchSpec returns original (the content of the spec of the show) with
+// the fields that differ from a patched in.
+func (s *Show) patchSpec(original []byte, a *Atom) ([]byte, error) {
+	loaded, err := s.decodeSpec(original)
+	if err != nil {
+		return nil, err
+	}
+	return patchYAML(original, &loaded, a)
+}
+
+// patchYAML returns original with the fields where updated differs from
+// baseline (original as loaded) patched in.
+func patchYAML(original []byte, baseline any, updated any) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(original, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode || doc.Content[0].Style&yaml.FlowStyle != 0 {
+		return nil, fmt.Errorf("not a block mapping")
+	}
+	var baselineNode, updatedNode yaml.Node
+	if err := baselineNode.Encode(baseline); err != nil {
+		return nil, err
+	}
+	if err := updatedNode.Encode(updated); err != nil {
+		return nil, err
+	}
+	text := string(original)
+	trailingNewline := strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(text, "\n")
+	p := &specPatcher{lines: strings.Split(text, "\n")}
+	if err := p.mapping(doc.Content[0], &baselineNode, &updatedNode, len(p.lines)); err != nil {
+		return nil, err
+	}
+	out := strings.Join(p.apply(), "\n")
+	if trailingNewline {
+		out += "\n"
+	}
+	return []byte(out), nil
+}
+
+// apply returns the lines with every edit applied, edits are applied
+// from the end so that line numbers of earlier edits stay valid.
+func (p *specPatcher) apply() []string {
+	edits := p.edits
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start > edits[j].start
+		}
+		if edits[i].insertion() != edits[j].insertion() {
+			return !edits[i].insertion()
+		}
+		return edits[i].seq > edits[j].seq
+	})
+	lines := p.lines
+	for _, e := range edits {
+		replaced := append([]string{}, lines[:e.start-1]...)
+		replaced = append(replaced, e.lines...)
+		lines = append(replaced, lines[e.end:]...)
+	}
+	return lines
+}
+
+func (p *specPatcher) edit(start int, end int, lines []string) {
+	p.edits = append(p.edits, lineEdit{start: start, end: end, lines: lines, seq: len(p.edits)})
+}
+
+// trimEnd returns end moved up past blank lines and comment lines
+// indented at most indent (they belong to what follows).
+func (p *specPatcher) trimEnd(start int, end int, indent int) int {
+	for end > start {
+		line := p.lines[end-1]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			end--
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") && len(line)-len(strings.TrimLeft(line, " ")) <= indent {
+			end--
+			continue
+		}
+		break
+	}
+	return end
+}
+
+// prefix returns the text before column (1-based) on line, e.g the
+// indentation or the "- " of a sequence item.
+func (p *specPatcher) prefix(line int, column int) string {
+	text := p.lines[line-1]
+	if column-1 > len(text) {
+		return text
+	}
+	return text[:column-1]
+}
+
+// pairs returns the index of every key in mapping, nil if mapping is
+// not a mapping.
+func pairs(mapping *yaml.Node) map[string]int {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	keys := make(map[string]int)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		keys[mapping.Content[i].Value] = i
+	}
+	return keys
+}
+
+// value returns the value of key in mapping, nil if there is none.
+func value(mapping *yaml.Node, key string) *yaml.Node {
+	if idx, found := pairs(mapping)[key]; found {
+		return mapping.Content[idx+1]
+	}
+	return nil
+}
+
+// changed returns true if updated differs from baseline (or from orig
+// if the baseline does not have the value).
+func changed(orig *yaml.Node, baseline *yaml.Node, updated *yaml.Node) bool {
+	if baseline == nil {
+		return !nodesEqual(orig, updated)
+	}
+	return !nodesEqual(baseline, updated)
+}
+
+// mapping patches the block mapping orig (ending at line end) with
+// updated where it differs from baseline.
+func (p *specPatcher) mapping(orig *yaml.Node, baseline *yaml.Node, updated *yaml.Node, end int) error {
+	origKeys := pairs(orig)
+	indent := orig.Column - 1
+	// Line range of each pair in orig.
+	ranges := make(map[string][2]int)
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		start := orig.Content[i].Line
+		pairEnd := end
+		if i+2 < len(orig.Content) {
+			pairEnd = orig.Content[i+2].Line - 1
+		}
+		ranges[orig.Content[i].Value] = [2]int{start, p.trimEnd(start, pairEnd, indent)}
+	}
+
+	updatedKeys := pairs(updated)
+	previous := ""
+	for i := 0; i+1 < len(updated.Content); i += 2 {
+		key, updatedValue := updated.Content[i], updated.Content[i+1]
+		idx, found := origKeys[key.Value]
+		if !found {
+			if base := value(baseline, key.Value); base != nil && !changed(nil, base, updatedValue) {
+				continue
+			}
+			if merged := mergedValue(orig, key.Value); merged != nil && nodesEqual(merged, updatedValue) {
+				continue
+			}
+			// Insert after the previous key of updated found in orig
+			// (or after the first pair).
+			after := orig.Content[0].Value
+			if previous != "" {
+				after = previous
+			}
+			r := ranges[after]
+			p.edit(r[1]+1, r[1], renderPair(key, updatedValue, nil, strings.Repeat(" ", indent)))
+			continue
+		}
+		previous = key.Value
+		origKey, origValue := orig.Content[idx], orig.Content[idx+1]
+		base := value(baseline, key.Value)
+		r := ranges[key.Value]
+		switch {
+		case origValue.Kind == yaml.MappingNode && updatedValue.Kind == yaml.MappingNode && origValue.Style&yaml.FlowStyle == 0 && origValue.Line > origKey.Line:
+			if err := p.mapping(origValue, base, updatedValue, r[1]); err != nil {
+				return err
+			}
+		case origValue.Kind == yaml.SequenceNode && updatedValue.Kind == yaml.SequenceNode && origValue.Style&yaml.FlowStyle == 0 && uidSequence(origValue) && uidSequence(updatedValue):
+			if err := p.sequence(origValue, base, updatedValue, r[1]); err != nil {
+				return err
+			}
+		case !changed(origValue, base, updatedValue):
+		default:
+			p.edit(r[0], r[1], renderPair(origKey, updatedValue, origValue, p.prefix(r[0], origKey.Column)))
+		}
+	}
+	// Remove pairs no longer in the atom (e.g staged: false), keys the
+	// atom never had are kept.
+	baselineKeys := pairs(baseline)
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		key := orig.Content[i]
+		if _, found := updatedKeys[key.Value]; found {
+			continue
+		}
+		if _, found := baselineKeys[key.Value]; !found {
+			continue
+		}
+		r := ranges[key.Value]
+		if strings.TrimSpace(p.prefix(r[0], key.Column)) != "" {
+			// First key of a sequence item, removing its lines
+			// would remove the "- " of the item.
+			continue
+		}
+		p.edit(r[0], r[1], nil)
+	}
+	return nil
+}
+
+// sequence patches a block sequence of mappings with a uid key (the
+// episodes) matching the items by uid.
+func (p *specPatcher) sequence(orig *yaml.Node, baseline *yaml.Node, updated *yaml.Node, end int) error {
+	baselineItems := make(map[string]*yaml.Node)
+	if baseline != nil && baseline.Kind == yaml.SequenceNode {
+		for _, n := range baseline.Content {
+			baselineItems[uidOf(n)] = n
+		}
+	}
+	indent := orig.Column - 1
+	type item struct {
+		node  *yaml.Node
+		start int
+		end   int
+	}
+	origItems := make(map[string]item)
+	var order []string
+	for i, n := range orig.Content {
+		itemEnd := end
+		if i+1 < len(orig.Content) {
+			itemEnd = orig.Content[i+1].Line - 1
+		}
+		uid := uidOf(n)
+		origItems[uid] = item{node: n, start: n.Line, end: p.trimEnd(n.Line, itemEnd, indent)}
+		order = append(order, uid)
+	}
+	updatedUIDs := make(map[string]bool)
+	for i, n := range updated.Content {
+		uid := uidOf(n)
+		updatedUIDs[uid] = true
+		if o, found := origItems[uid]; found {
+			if err := p.mapping(o.node, baselineItems[uid], n, o.end); err != nil {
+				return err
+			}
+			continue
+		}
+		lines := renderItem(n, strings.Repeat(" ", indent))
+		// Insert before the next item of updated found in orig, after
+		// the last item if there is none.
+		inserted := false
+		for _, next := range updated.Content[i+1:] {
+			if o, found := origItems[uidOf(next)]; found {
+				p.edit(o.start, o.start-1, lines)
+				inserted = true
+				break
+			}
+		}
+		if !inserted {
+			last := origItems[order[len(order)-1]]
+			p.edit(last.end+1, last.end, lines)
+		}
+	}
+	for i, uid := range order {
+		if updatedUIDs[uid] {
+			continue
+		}
+		// Take the blank lines separating the item from the next one
+		// (from the previous one if it is the last item) with it.
+		o := origItems[uid]
+		start, end := o.start, o.end
+		if i+1 < len(order) {
+			end = p.blankUntil(end, origItems[order[i+1]].start)
+		} else if i > 0 {
+			if previous := origItems[order[i-1]].end; p.blankUntil(previous, start) == start-1 {
+				start = previous + 1
+			}
+		}
+		p.edit(start, end, nil)
+	}
+	return nil
+}
+
+// blankUntil returns the last line after line and before next where all
+// lines in between are blank.
+func (p *specPatcher) blankUntil(line int, next int) int {
+	for line+1 < next && strings.TrimSpace(p.lines[line]) == "" {
+		line++
+	}
+	return line
+}
+
+// uidSequence returns true if every item of seq is a block mapping with
+// a uid, false if seq is empty.
+func uidSequence(seq *yaml.Node) bool {
+	if len(seq.Content) == 0 {
+		return false
+	}
+	for _, n := range seq.Content {
+		if n.Kind != yaml.MappingNode || n.Style&yaml.FlowStyle != 0 || uidOf(n) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func uidOf(mapping *yaml.Node) string {
+	if uid := value(mapping, "uid"); uid != nil {
+		return uid.Value
+	}
+	return ""
+}
+
+// mergedValue returns the value of key merged into mapping by a << key,
+// nil if there is none.
+func mergedValue(mapping *yaml.Node, key string) *yaml.Node {
+	idx, found := pairs(mapping)["<<"]
+	if !found {
+		return nil
+	}
+	sources := []*yaml.Node{mapping.Content[idx+1]}
+	if sources[0].Kind == yaml.SequenceNode {
+		sources = sources[0].Content
+	}
+	for _, src := range sources {
+		for src.Kind == yaml.AliasNode {
+			src = src.Alias
+		}
+		if src.Kind != yaml.MappingNode {
+			continue
+		}
+		if i, found := pairs(src)[key]; found {
+			return src.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// nodesEqual returns true if a and b decode to the same value. A null
+// is equal to an empty string.
+func nodesEqual(a *yaml.Node, b *yaml.Node) bool {
+	for a.Kind == yaml.AliasNode {
+		a = a.Alias
+	}
+	if a.Kind == yaml.ScalarNode && b.Kind == yaml.ScalarNode {
+		if a.Tag == "!!null" || b.Tag == "!!null" {
+			return strings.Trim(a.Value, "~") == "" && strings.Trim(b.Value, "~") == "" || a.Tag == b.Tag
+		}
+		return a.Value == b.Value
+	}
+	var av, bv any
+	if err := a.Decode(&av); err != nil {
+		return false
+	}
+	if err := b.Decode(&bv); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+// renderPair returns the lines of key: value where the first line is
+// prefixed with prefix and the others with as many spaces. The style and
+// line comment of the replaced value orig (nil for a new pair) are kept
+// where possible.
+func renderPair(key *yaml.Node, value *yaml.Node, orig *yaml.Node, prefix string) []string {
+	k := *key
+	k.HeadComment, k.FootComment = "", ""
+	v := *value
+	if orig != nil {
+		v.LineComment = orig.LineComment
+		if k.LineComment == "" && orig.LineComment == "" {
+			k.LineComment = key.LineComment
+		}
+		if v.Kind == yaml.ScalarNode && orig.Kind == yaml.ScalarNode && v.Tag == "!!str" {
+			switch orig.Style {
+			case yaml.LiteralStyle, yaml.FoldedStyle:
+				if strings.Contains(v.Value, "\n") {
+					v.Style = orig.Style
+				}
+			case yaml.DoubleQuotedStyle, yaml.SingleQuotedStyle:
+				v.Style = orig.Style
+			}
+		}
+	}
+	return renderLines(&yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{&k, &v}}, prefix, strings.Repeat(" ", len(prefix)))
+}
+
+// renderItem returns the lines of mapping as a sequence item indented
+// by indent.
+func renderItem(mapping *yaml.Node, indent string) []string {
+	return renderLines(mapping, indent+"- ", indent+"  ")
+}
+
+func renderLines(n *yaml.Node, firstPrefix string, prefix string) []string {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		// Encoding a node from Node.Encode does not fail.
+		panic(err)
+	}
+	enc.Close()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i := range lines {
+		switch {
+		case i == 0:
+			lines[i] = firstPrefix + lines[i]
+		case lines[i] == "":
+		default:
+			lines[i] = prefix + lines[i]
+		}
+	}
+	return lines
+}
+
+// replaceYAML returns original re-encoded as a whole (dropping comments
+// but not the style of the values) with the values of keys in the top
+// level mapping replaced by those of updated. Every other key, such as
+// config and environments, is kept as it was in original.
+func replaceYAML(original []byte, updated any, keys ...string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(original, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("not a mapping")
+	}
+	root := doc.Content[0]
+	var updatedNode yaml.Node
+	if err := updatedNode.Encode(updated); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		v := value(&updatedNode, key)
+		idx, found := pairs(root)[key]
+		switch {
+		case found && v != nil:
+			root.Content[idx+1] = v
+		case found:
+			root.Content = append(root.Content[:idx], root.Content[idx+2:]...)
+		case v != nil:
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+		}
+	}
+	root.Style &^= yaml.FlowStyle
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const specPatchTestSpec = `# Podcast spec
+config:
+  baseURL: https://example.com # where the feed lives
+  bucket: podcast
+atom: podcast.rss
+title: Example
+lastBuildDate: Tue, 22 Oct 2024 21:23:47 +0000
+x-notes: not known by mkpod
+episodes:
+# Newest first
+- uid: 2
+  title: 'Second'
+  staged: true
+  description: |-
+    Second episode.
+
+    # Not a comment
+  duration: "00:00:00"
+  length: 0
+  input: two.wav
+
+- uid: 1
+  title: First
+  description: |-
+    First episode.
+  duration: "00:10:00"
+  length: 1000
+  input: one.wav
+  output: one.mp3
+`
+
+func TestPatchSpec(t *testing.T) {
+	var a Atom
+	if err := yaml.Unmarshal([]byte(specPatchTestSpec), &a); err != nil {
+		t.Fatal(err)
+	}
+	setDefaults(&a)
+	unchanged, err := NewShow("", "").patchSpec([]byte(specPatchTestSpec), &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(unchanged) != specPatchTestSpec {
+		t.Errorf("expected spec to be untouched, got:\n%s", unchanged)
+	}
+
+	a.LastBuildDate.Time = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	a.Episodes[0].Staged = false
+	a.Episodes[0].Duration.Duration = 754 * time.Second
+	a.Episodes[0].Length = 4321
+	a.Episodes[0].Output = "two.mp3"
+	a.Episodes[0].Type = "audio/mpeg"
+	a.Episodes = append([]Episode{{UID: 3, Title: "Third", Input: "three.wav"}}, a.Episodes...)
+	b, err := NewShow("", "").patchSpec([]byte(specPatchTestSpec), &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := string(b)
+	for _, expected := range []string{
+		"# Podcast spec\n",
+		"  baseURL: https://example.com # where the feed lives\n",
+		"lastBuildDate: Thu, 02 Jan 2025 03:04:05 +0000\n",
+		"x-notes: not known by mkpod\n",
+		"episodes:\n# Newest first\n- uid: 3\n  title: Third\n",
+		"- uid: 2\n  title: 'Second'\n  description: |-\n    Second episode.\n\n    # Not a comment\n  type: audio/mpeg\n  duration: \"00:12:34\"\n  length: 4321\n",
+		"  input: two.wav\n  output: two.mp3\n\n- uid: 1\n  title: First\n  description: |-\n    First episode.\n  duration: \"00:10:00\"\n  length: 1000\n",
+	} {
+		if !strings.Contains(patched, expected) {
+			t.Errorf("expected patched spec to contain %q, got:\n%s", expected, patched)
+		}
+	}
+	if strings.Contains(patched, "staged") {
+		t.Errorf("expected staged to be removed, got:\n%s", patched)
+	}
+
+	var reloaded Atom
+	if err := yaml.Unmarshal(b, &reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Episodes) != 3 || reloaded.Episodes[0].UID != 3 || reloaded.Episodes[1].Output != "two.mp3" || reloaded.Episodes[2].Output != "one.mp3" {
+		t.Errorf("unexpected episodes in patched spec %+v", reloaded.Episodes)
+	}
+
+	a.Episodes = a.Episodes[1:2]
+	b, err = NewShow("", "").patchSpec([]byte(specPatchTestSpec), &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "uid: 1") || !strings.HasSuffix(string(b), "  output: two.mp3\n") {
+		t.Errorf("expected episode 1 to be removed, got:\n%s", b)
+	}
+}
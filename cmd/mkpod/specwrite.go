@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// The spec is the only copy of what has been encoded and published. It
+// is written to a temp file in the same directory, synced and renamed
+// over the spec (the spec is either the old or the new content, never
+// truncated). The previous content is kept in rotating backups
+// (podspec.yaml.1 being the latest), episode files are written the same
+// way. Commands that may write the spec take an advisory lock
+// (podspec.yaml.lock naming the pid and host of the run) for as long as
+// they run, a lock left by a run on this host that is no longer running
+// is taken over.
+
+const defaultSpecBackups int = 3
+
+// SpecBackupsOrDefault returns the number of backups of the spec to keep,
+// 0 if negative.
+func (c *Config) SpecBackupsOrDefault() int {
+	switch {
+	case c.SpecBackups < 0:
+		return 0
+	case c.SpecBackups == 0:
+		return defaultSpecBackups
+	}
+	return c.SpecBackups
+}
+
+// writeSpec writes b to the spec keeping the previous content as a
+// backup.
+func (s *Show) writeSpec(b []byte) error {
+	return s.writeBackedUp(s.SpecFile, b)
+}
+
+// writeBackedUp writes b to name (the spec or an episode file) keeping
+// the previous content as a backup.
+func (s *Show) writeBackedUp(name string, b []byte) error {
+	previous, err := os.ReadFile(name)
+	switch {
+	case err == nil:
+		if err := backupFile(name, previous, s.Atom.Config.SpecBackupsOrDefault()); err != nil {
+			return fmt.Errorf("unable to backup %s: %w", name, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if err := writeFileAtomic(name, b, 0644); err != nil {
+		return fmt.Errorf("unable to re-write %s: %w", name, err)
+	}
+	return nil
+}
+
+// backupFileName returns the file name of backup n of name.
+func backupFileName(name string, n int) string {
+	return name + "." + strconv.Itoa(n)
+}
+
+// backupFile rotates the backups of name (dropping the oldest) and
+// writes content as backup 1.
+func backupFile(name string, content []byte, backups int) error {
+	if backups < 1 {
+		return nil
+	}
+	if err := os.Remove(backupFileName(name, backups)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for n := backups - 1; n > 0; n-- {
+		if err := os.Rename(backupFileName(name, n), backupFileName(name, n+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return writeFileAtomic(backupFileName(name, 1), content, 0644)
+}
+
+// writeFileAtomic writes b to a temp file next to name, syncs it and
+// renames it to name. The mode of an existing name is kept, perm is used
+// otherwise.
+func writeFileAtomic(name string, b []byte, perm fs.FileMode) error {
+	if fi, err := os.Stat(name); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	// Sync the directory for the rename to survive a crash, not
+	// supported everywhere.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// SpecLock is the content of the lock file of a spec.
+type SpecLock struct {
+	PID   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Since time.Time `json:"since"`
+}
+
+// specLockFile returns the lock file of the spec.
+func (s *Show) specLockFile() string {
+	return s.SpecFile + ".lock"
+}
+
+// lockSpec takes the lock of the spec for the rest of the run and
+// returns the function releasing it. It fails naming the pid and host of
+// the run holding the lock.
+func (s *Show) lockSpec() (func(), error) {
+	host, _ := os.Hostname()
+	lock := SpecLock{PID: os.Getpid(), Host: host, Since: time.Now().UTC()}
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+	file := s.specLockFile()
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(append(b, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(file)
+				return nil, fmt.Errorf("unable to lock %s: %w", s.SpecFile, err)
+			}
+			return func() {
+				if err := os.Remove(file); err != nil {
+					log.Printf("WARNING: Unable to remove lock %s: %v", file, err)
+				}
+			}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("unable to lock %s: %w", s.SpecFile, err)
+		}
+		var holder SpecLock
+		content, rerr := os.ReadFile(file)
+		if rerr == nil {
+			rerr = json.Unmarshal(content, &holder)
+		}
+		if rerr != nil {
+			return nil, fmt.Errorf("%s is locked by another mkpod run (unable to read %s: %v), remove it if no other run is in progress", s.SpecFile, file, rerr)
+		}
+		if attempt == 0 && holder.Host == host && !processRunning(holder.PID) {
+			log.Printf("WARNING: Taking over stale lock %s of pid %d (no longer running)", file, holder.PID)
+			if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			continue
+		}
+		return nil, fmt.Errorf("%s is locked by mkpod pid %d on %s since %s, remove %s if that run is gone", s.SpecFile, holder.PID, holder.Host, holder.Since.Local().Format(time.RFC3339), file)
+	}
+}
+
+// processRunning returns true if a process with pid exists on this host.
+func processRunning(pid int) bool {
+	if pid < 1 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSpecBackups(t *testing.T) {
+	s := NewShow("", "")
+	s.SpecFile = filepath.Join(t.TempDir(), "podspec.yaml")
+	s.Atom = Atom{}
+	s.Atom.Config.SpecBackups = 2
+	if err := os.WriteFile(s.SpecFile, []byte("v0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := s.writeSpec([]byte(fmt.Sprintf("v%d\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, expected := range map[string]string{
+		s.SpecFile:                    "v3\n",
+		backupFileName(s.SpecFile, 1): "v2\n",
+		backupFileName(s.SpecFile, 2): "v1\n",
+	} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("expected %s to contain %q, got %q", file, expected, b)
+		}
+	}
+	if _, err := os.Stat(backupFileName(s.SpecFile, 3)); err == nil {
+		t.Errorf("expected only 2 backups")
+	}
+	if fi, err := os.Stat(s.SpecFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected mode of %s to be kept, got %v (%v)", s.SpecFile, fi.Mode(), err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(s.SpecFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("temp file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestLockSpec(t *testing.T) {
+	s := NewShow("", "")
+	s.SpecFile = filepath.Join(t.TempDir(), "podspec.yaml")
+
+	unlock, err := s.lockSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+	_, err = s.lockSpec()
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("pid %d on %s", os.Getpid(), host)) {
+		t.Errorf("expected error naming pid and host, got %v", err)
+	}
+	unlock()
+	if _, err := os.Stat(s.specLockFile()); err == nil {
+		t.Error("expected lock file to be removed on unlock")
+	}
+
+	// A lock of a run on another host is never taken over.
+	b, _ := json.Marshal(SpecLock{PID: 1, Host: "elsewhere.example", Since: time.Now()})
+	if err := os.WriteFile(s.specLockFile(), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.lockSpec(); err == nil || !strings.Contains(err.Error(), "pid 1 on elsewhere.example") {
+		t.Errorf("expected lock held by elsewhere.example, got %v", err)
+	}
+
+	// A lock of a run on this host no longer running is stale.
+	b, _ = json.Marshal(SpecLock{PID: 1 << 30, Host: host, Since: time.Now()})
+	if err := os.WriteFile(s.specLockFile(), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = s.lockSpec()
+	if err != nil {
+		t.Fatalf("expected stale lock to be taken over, got %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli/v2"
+)
+
+// Staged publishing. When config.staging is configured, encode uploads
+// output files and artwork to the staging bucket and/or prefix and marks
+// the episode as staged. Staged episodes are left out of the production
+// feed, but are part of the staging feed (parse --staging) regardless of
+// pubDate. The promote command copies the objects of staged episodes to
+// the output bucket server-side and uploads the re-rendered production
+// feed last, so that subscribers never see a feed referencing objects
+// that do not exist. The spec is written before the staged objects are
+// removed.
+
+type StagingConfig struct {
+	// Bucket for staged objects, defaults to the output bucket.
+	Bucket string `yaml:"bucket,omitempty"`
+	// Key prefix of staged objects (and the staging feed).
+	Prefix string `yaml:"prefix,omitempty"`
+	// Public URL of the staging bucket (the prefix is appended to
+	// keys), same as baseURL if staging is a prefix in the output bucket.
+	BaseURL string `yaml:"baseURL,omitempty"`
+}
+
+// FeedTarget is where a feed and the objects it references are
+// published.
+type FeedTarget struct {
+	Name    string
+	Bucket  string
+	Prefix  string
+	BaseURL string
+}
+
+// Key returns key prefixed with the target prefix.
+func (t FeedTarget) Key(key string) string {
+	if strings.TrimSpace(t.Prefix) == "" {
+		return key
+	}
+	return path.Join(t.Prefix, key)
+}
+
+// URL returns the public URL of key.
+func (t FeedTarget) URL(key string) string {
+	return strings.TrimSuffix(t.BaseURL, "/") + "/" + key
+}
+
+// Includes returns true if episode is part of the feed of target at
+// now. The production feed has published episodes that are not staged,
+// the staging feed has these and all staged episodes regardless of
+// pubDate.
+func (t FeedTarget) Includes(episode *Episode, now time.Time) bool {
+	if episode.Staged {
+		return t.Name == "staging"
+	}
+	return isAfter(now, episode.PubDate.Time)
+}
+
+// StagingEnabled returns true if config.staging is configured.
+func (c *Config) StagingEnabled() bool {
+	return strings.TrimSpace(c.Staging.BaseURL) != ""
+}
+
+// ProductionTarget returns the output bucket and baseURL.
+func (a *Atom) ProductionTarget() FeedTarget {
+	return FeedTarget{
+		Name:    "production",
+		Bucket:  a.Config.Aws.Buckets.Output,
+		BaseURL: a.Config.BaseURL,
+	}
+}
+
+// StagingTarget returns the staging bucket, prefix and baseURL.
+func (a *Atom) StagingTarget() FeedTarget {
+	bucket := a.Config.Staging.Bucket
+	if strings.TrimSpace(bucket) == "" {
+		bucket = a.Config.Aws.Buckets.Output
+	}
+	return FeedTarget{
+		Name:    "staging",
+		Bucket:  bucket,
+		Prefix:  a.Config.Staging.Prefix,
+		BaseURL: a.Config.Staging.BaseURL,
+	}
+}
+
+// EpisodeTarget returns the target where the objects of episode are
+// stored.
+func (a *Atom) EpisodeTarget(episode *Episode) FeedTarget {
+	if episode.Staged {
+		return a.StagingTarget()
+	}
+	return a.ProductionTarget()
+}
+
+// EpisodeURL returns the URL of key (output or image) of episode,
+// staged episodes are served from staging.
+func (a *Atom) EpisodeURL(episode *Episode, key string) string {
+	t := a.EpisodeTarget(episode)
+	return t.URL(t.Key(key))
+}
+
+// validateStaging returns error if staging is configured in a way
+// that would overwrite production objects.
+func (s *Show) validateStaging() error {
+	if !s.Atom.Config.StagingEnabled() {
+		return nil
+	}
+	staging := s.Atom.StagingTarget()
+	if staging.Bucket == s.Atom.Config.Aws.Buckets.Output && strings.Trim(staging.Prefix, "/") == "" {
+		return fmt.Errorf("config.staging in %s needs a bucket other than the output bucket or a prefix", s.SpecFile)
+	}
+	return nil
+}
+
+// Copy copies srcBucket/srcKey to dstBucket/dstKey server-side applying
+// the upload policy of kind to the copy. Content type and the SHA-256
+// metadata are preserved.
+func (s *AwsHandler) Copy(kind ObjectKind, srcBucket string, srcKey string, dstBucket string, dstKey string) error {
+	src, err := s.Head(srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+	policy := s.Atom.Config.UploadPolicy(kind)
+	log.Printf("Copying s3://%s to s3://%s", path.Join(srcBucket, srcKey), path.Join(dstBucket, dstKey))
+	_, err = s.S3.CopyObject(&s3.CopyObjectInput{
+		Bucket:               aws.String(dstBucket),
+		Key:                  aws.String(dstKey),
+		CopySource:           aws.String(url.PathEscape(path.Join(srcBucket, srcKey))),
+		MetadataDirective:    aws.String(s3.MetadataDirectiveReplace),
+		ContentType:          optionalString(src.ContentType),
+		CacheControl:         optionalString(policy.CacheControl),
+		ContentDisposition:   optionalString(policy.ContentDisposition),
+		StorageClass:         optionalString(policy.StorageClass),
+		ACL:                  optionalString(policy.ACL),
+		ServerSideEncryption: optionalString(policy.ServerSideEncryption),
+		Metadata:             policy.ObjectMetadata(src.SHA256),
+	})
+	if err != nil {
+		return err
+	}
+	dst, err := s.Head(dstBucket, dstKey)
+	if err != nil {
+		return err
+	}
+	if dst.Size != src.Size {
+		return fmt.Errorf("size of s3://%s (%d) differs from s3://%s (%d) after copy", path.Join(dstBucket, dstKey), dst.Size, path.Join(srcBucket, srcKey), src.Size)
+	}
+	return nil
+}
+
+func (s *Show) promoter(c *cli.Context) error {
+	if c.Args().Len() == 0 && !c.Bool("all") {
+		log.Fatal("You need to select one or several episode UIDs to promote as argument(s) to this command or use the all-option -a")
+	}
+
+	s.opts.Force = c.Bool("force")
+
+	unlock, err := s.lockSpec()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+	if !s.Atom.Config.StagingEnabled() {
+		return fmt.Errorf("config.staging is not configured in %s", s.SpecFile)
+	}
+	if err := s.validateStaging(); err != nil {
+		return err
+	}
+	s.Aws.NewSession()
+	if err := s.createLocalStorageDir(); err != nil {
+		return err
+	}
+	if err := s.validateAtom(); err != nil {
+		return err
+	}
+
+	var uids []int64
+	if c.Bool("all") {
+		for _, e := range s.Atom.Episodes {
+			if e.Staged {
+				uids = append(uids, e.UID)
+			}
+		}
+	} else {
+		for _, uidstr := range c.Args().Slice() {
+			uid, err := strconv.ParseInt(uidstr, 10, 64)
+			if err != nil {
+				return fmt.Errorf("must specify the UID integer of the episode to promote: %w", err)
+			}
+			uids = append(uids, uid)
+		}
+	}
+
+	// Copy the objects of every episode before the production feed is
+	// touched.
+	var promoted []*Episode
+	for _, uid := range uids {
+		idx := s.Atom.ContainsEpisode(uid)
+		if idx < 0 {
+			log.Printf("WARNING: Episode with uid %d does not exist in %s, skipping", uid, s.SpecFile)
+			continue
+		}
+		episode := &s.Atom.Episodes[idx]
+		if !episode.Staged {
+			log.Printf("WARNING: Episode with uid %d (%s) is not staged, skipping", episode.UID, episode.Title)
+			continue
+		}
+		if !s.opts.doAction("Promote UID %d (%s) to s3://%s?", episode.UID, episode.Title, s.Atom.Config.Aws.Buckets.Output) {
+			continue
+		}
+		if err := s.promoteEpisode(episode); err != nil {
+			return fmt.Errorf("error promoting episode with UID %d: %w", episode.UID, err)
+		}
+		promoted = append(promoted, episode)
+	}
+	if len(promoted) == 0 {
+		log.Printf("No episode was promoted")
+		return nil
+	}
+
+	for _, episode := range promoted {
+		episode.Staged = false
+	}
+	if err := s.publishFeed(s.Atom.ProductionTarget()); err != nil {
+		return err
+	}
+	log.Printf("Promoted %d episode(s) and published s3://%s", len(promoted), path.Join(s.Atom.Config.Aws.Buckets.Output, s.Atom.Atom))
+	// The spec has to agree with the published feed before any staged
+	// object is removed, it is written without asking.
+	if err := s.writeAtom(); err != nil {
+		return fmt.Errorf("promoted episodes are published but %s could not be written, staged objects are kept: %w", s.SpecFile, err)
+	}
+
+	if !c.Bool("keep-staged") {
+		// Keys still used by other staged episodes (e.g a shared
+		// default image) are kept.
+		inUse := make(map[string]bool)
+		for i := range s.Atom.Episodes {
+			if s.Atom.Episodes[i].Staged {
+				for _, key := range stagedKeys(&s.Atom.Episodes[i]) {
+					inUse[key] = true
+				}
+			}
+		}
+		staging := s.Atom.StagingTarget()
+		for _, episode := range promoted {
+			for _, key := range stagedKeys(episode) {
+				if inUse[key] {
+					continue
+				}
+				inUse[key] = true
+				log.Printf("Removing s3://%s", path.Join(staging.Bucket, staging.Key(key)))
+				if err := s.Aws.Remove(staging.Bucket, staging.Key(key)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	// The staging feed now references the promoted episodes in
+	// production.
+	return s.publishFeed(s.Atom.StagingTarget())
+}
+
+// stagedKeys returns the keys (without staging prefix) of the objects
+// of episode uploaded to staging.
+func stagedKeys(episode *Episode) []string {
+	var keys []string
+	for _, key := range []string{episode.Output, episode.Image, episode.Transcript, episode.ChaptersFile()} {
+		if strings.TrimSpace(key) != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// promoteEpisode copies the staged output file, artwork and sidecars
+// of episode to the output bucket.
+func (s *Show) promoteEpisode(episode *Episode) error {
+	staging := s.Atom.StagingTarget()
+	production := s.Atom.ProductionTarget()
+	kinds := map[string]ObjectKind{
+		episode.Output:         ObjectKindMedia,
+		episode.Image:          ObjectKindArtwork,
+		episode.Transcript:     ObjectKindTranscripts,
+		episode.ChaptersFile(): ObjectKindChapters,
+	}
+	for _, key := range stagedKeys(episode) {
+		if err := s.Aws.Copy(kinds[key], staging.Bucket, staging.Key(key), production.Bucket, production.Key(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishFeed renders the feed (and the alternate feeds) of target into
+// local files and uploads them (skipped by Upload if unchanged, see
+// uploadFeed).
+func (s *Show) publishFeed(target FeedTarget) error {
+	b, err := s.renderFeedFor(target)
+	if err != nil {
+		return err
+	}
+	file := s.localFeedFile(target)
+	if err := os.WriteFile(file, b, 0644); err != nil {
+		return err
+	}
+	log.Printf("Successfully generated %s", file)
+	if err := s.writeAlternateFeeds(target); err != nil {
+		return err
+	}
+	s.runHooks(EventFeedRendered, target, nil)
+	if err := s.uploadAlternateFeeds(target); err != nil {
+		return err
+	}
+	return s.uploadFeed(target, file)
+}
+
+// uploadFeed uploads the rendered feed file of target. If the content
+// of the production feed changed, the configured notifiers and
+// feed.published hooks are called.
+func (s *Show) uploadFeed(target FeedTarget, file string) error {
+	key := target.Key(s.Atom.Atom)
+	identical, _, _, err := s.Aws.IsIdentical(target.Bucket, key, file)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if err := s.Aws.Upload(ObjectKindFeed, target.Bucket, key, "text/xml", file); err != nil {
+		return err
+	}
+	if !identical && target.Name == "production" {
+		s.notifyFeedPublished(target.URL(key))
+		s.runHooks(EventFeedPublished, target, nil)
+	}
+	return nil
+}
+
+// localFeedFile returns the name of the locally rendered feed of target,
+// the staging feed is written as e.g podcast.staging.rss.
+func (s *Show) localFeedFile(target FeedTarget) string {
+	if target.Name == "staging" {
+		return ReplaceExtension(s.Atom.Atom, ".staging"+path.Ext(s.Atom.Atom))
+	}
+	return s.Atom.Atom
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderFeedStaging(t *testing.T) {
+	s := NewShow("", "")
+
+	s.Atom = Atom{
+		Atom:  "podcast.rss",
+		Title: "QZJ",
+	}
+	s.Atom.Config.BaseURL = "https://mypodbucket.s3.eu-north-1.amazonaws.com"
+	s.Atom.Config.Aws.Buckets.Output = "mypodbucket"
+	s.Atom.Config.Staging = StagingConfig{
+		Prefix:  "staging",
+		BaseURL: "https://mypodbucket.s3.eu-north-1.amazonaws.com",
+	}
+	s.Atom.Episodes = []Episode{
+		{
+			UID:     2,
+			Title:   "Staged",
+			PubDate: ItunesTime{time.Now().Add(24 * time.Hour)},
+			Image:   "qzj002.jpg",
+			Output:  "qzj002.mp3",
+			Staged:  true,
+		},
+		{
+			UID:     1,
+			Title:   "Published",
+			PubDate: ItunesTime{time.Now().Add(-24 * time.Hour)},
+			Image:   "qzj001.jpg",
+			Output:  "qzj001.mp3",
+		},
+	}
+	if err := s.validateStaging(); err != nil {
+		t.Fatal(err)
+	}
+
+	production, err := s.renderFeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<atom:link href="https://mypodbucket.s3.eu-north-1.amazonaws.com/podcast.rss" rel="self"`,
+		`url="https://mypodbucket.s3.eu-north-1.amazonaws.com/qzj001.mp3"`,
+	} {
+		if !strings.Contains(string(production), expected) {
+			t.Errorf("expected production feed to contain %s", expected)
+		}
+	}
+	if strings.Contains(string(production), "qzj002") {
+		t.Error("expected staged episode to be left out of the production feed")
+	}
+
+	staging, err := s.renderFeedFor(s.Atom.StagingTarget())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<atom:link href="https://mypodbucket.s3.eu-north-1.amazonaws.com/staging/podcast.rss" rel="self"`,
+		`url="https://mypodbucket.s3.eu-north-1.amazonaws.com/staging/qzj002.mp3"`,
+		`<guid isPermaLink="true">https://mypodbucket.s3.eu-north-1.amazonaws.com/qzj002.mp3</guid>`,
+		`url="https://mypodbucket.s3.eu-north-1.amazonaws.com/qzj001.mp3"`,
+	} {
+		if !strings.Contains(string(staging), expected) {
+			t.Errorf("expected staging feed to contain %s", expected)
+		}
+	}
+
+	s.Atom.Config.Staging.Prefix = ""
+	if err := s.validateStaging(); err == nil {
+		t.Error("expected error when staging would overwrite the output bucket")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+// The status command compares the input and output buckets with the
+// spec and reports drift without modifying anything. Objects referenced
+// by another show in the workspace with the same output bucket are not
+// reported as unreferenced.
+
+type StatusReport struct {
+	InputBucket         string               `json:"inputBucket"`
+	OutputBucket        string               `json:"outputBucket"`
+	MissingOutputs      []StatusEpisode      `json:"missingOutputs"`
+	UnreferencedObjects []ObjectSummary      `json:"unreferencedObjects"`
+	SizeMismatches      []StatusSizeMismatch `json:"sizeMismatches"`
+	LocalOnlyMasters    []StatusEpisode      `json:"localOnlyMasters"`
+	RemoteOnlyMasters   []StatusEpisode      `json:"remoteOnlyMasters"`
+	MissingMasters      []StatusEpisode      `json:"missingMasters"`
+	Feed                StatusFeed           `json:"feed"`
+}
+
+type StatusEpisode struct {
+	UID   int64  `json:"uid"`
+	Title string `json:"title"`
+	Key   string `json:"key"`
+}
+
+type StatusSizeMismatch struct {
+	StatusEpisode
+	Length     int64 `json:"length"`
+	RemoteSize int64 `json:"remoteSize"`
+}
+
+type StatusFeed struct {
+	Key     string `json:"key"`
+	Exists  bool   `json:"exists"`
+	Differs bool   `json:"differs"`
+}
+
+// Drift returns true if anything in the report needs attention. Masters
+// that only exist remotely are expected (the local copy is a cache) and
+// are not considered drift.
+func (r *StatusReport) Drift() bool {
+	return len(r.MissingOutputs) > 0 ||
+		len(r.UnreferencedObjects) > 0 ||
+		len(r.SizeMismatches) > 0 ||
+		len(r.LocalOnlyMasters) > 0 ||
+		len(r.MissingMasters) > 0 ||
+		!r.Feed.Exists || r.Feed.Differs
+}
+
+func (s *Show) status(c *cli.Context) error {
+
+	if err := s.loadConfig(); err != nil {
+		return err
+	}
+	s.Aws.NewSession()
+
+	report, err := s.newStatusReport()
+	if err != nil {
+		return err
+	}
+
+	switch format := strings.ToLower(c.String("output")); format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	case "table":
+		if err := report.WriteTable(os.Stdout); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported output format %q, use table or json", format)
+	}
+
+	if c.Bool("exit-code") && report.Drift() {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
+
+// newStatusReport lists both buckets and compares them with the atom.
+func (s *Show) newStatusReport() (*StatusReport, error) {
+	// Empty slices rather than nil so that --json prints [] when there
+	// are no findings.
+	report := &StatusReport{
+		InputBucket:         s.Atom.Config.Aws.Buckets.Input,
+		OutputBucket:        s.Atom.Config.Aws.Buckets.Output,
+		MissingOutputs:      []StatusEpisode{},
+		UnreferencedObjects: []ObjectSummary{},
+		SizeMismatches:      []StatusSizeMismatch{},
+		LocalOnlyMasters:    []StatusEpisode{},
+		RemoteOnlyMasters:   []StatusEpisode{},
+		MissingMasters:      []StatusEpisode{},
+		Feed:                StatusFeed{Key: s.Atom.Atom},
+	}
+	referenced, err := s.referencedOutputKeys()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Listing s3://%s", report.OutputBucket)
+	outputObjects, err := s.Aws.List(report.OutputBucket)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Listing s3://%s", report.InputBucket)
+	inputObjects, err := s.Aws.List(report.InputBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range s.Atom.Episodes {
+		// Staged episodes are not expected in the output bucket.
+		if strings.TrimSpace(e.Output) != "" && !e.Staged {
+			se := StatusEpisode{UID: e.UID, Title: e.Title, Key: e.Output}
+			if o, ok := outputObjects[e.Output]; !ok {
+				report.MissingOutputs = append(report.MissingOutputs, se)
+			} else if o.Size != e.Length {
+				report.SizeMismatches = append(report.SizeMismatches, StatusSizeMismatch{
+					StatusEpisode: se,
+					Length:        e.Length,
+					RemoteSize:    o.Size,
+				})
+			}
+		}
+		if strings.TrimSpace(e.Input) != "" {
+			se := StatusEpisode{UID: e.UID, Title: e.Title, Key: e.Input}
+			_, remote := inputObjects[e.Input]
+			local, err := localFileExists(path.Join(s.Atom.LocalStorageDirExpanded(), e.Input))
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case local && !remote:
+				report.LocalOnlyMasters = append(report.LocalOnlyMasters, se)
+			case remote && !local:
+				report.RemoteOnlyMasters = append(report.RemoteOnlyMasters, se)
+			case !remote && !local:
+				report.MissingMasters = append(report.MissingMasters, se)
+			}
+		}
+	}
+
+	for key, o := range outputObjects {
+		if !referenced[key] {
+			report.UnreferencedObjects = append(report.UnreferencedObjects, o)
+		}
+	}
+	sort.Slice(report.UnreferencedObjects, func(i, j int) bool {
+		return report.UnreferencedObjects[i].Key < report.UnreferencedObjects[j].Key
+	})
+
+	if _, ok := outputObjects[s.Atom.Atom]; ok {
+		report.Feed.Exists = true
+		rendered, err := s.renderFeed()
+		if err != nil {
+			return nil, err
+		}
+		remote, err := s.Aws.Get(report.OutputBucket, s.Atom.Atom)
+		if err != nil {
+			return nil, err
+		}
+		report.Feed.Differs = !bytes.Equal(rendered, remote)
+	}
+	return report, nil
+}
+
+// WriteTable writes the report as human readable tables to w.
+func (r *StatusReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	section := func(title string, count int) {
+		fmt.Fprintf(tw, "\n%s (%d)\n", title, count)
+	}
+	section(fmt.Sprintf("Episodes with output missing in s3://%s", r.OutputBucket), len(r.MissingOutputs))
+	writeStatusEpisodes(tw, r.MissingOutputs)
+	section("Episodes where remote size differs from length", len(r.SizeMismatches))
+	if len(r.SizeMismatches) > 0 {
+		fmt.Fprintln(tw, "UID\tTITLE\tKEY\tLENGTH\tREMOTE SIZE")
+		for _, m := range r.SizeMismatches {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\n", m.UID, m.Title, m.Key, m.Length, m.RemoteSize)
+		}
+	}
+	section(fmt.Sprintf("Objects in s3://%s not referenced by any episode", r.OutputBucket), len(r.UnreferencedObjects))
+	if len(r.UnreferencedObjects) > 0 {
+		fmt.Fprintln(tw, "KEY\tSIZE\tLAST MODIFIED")
+		for _, o := range r.UnreferencedObjects {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", o.Key, o.Size, o.LastModified.Format("2006-01-02 15:04:05"))
+		}
+	}
+	section("Masters only in localStorageDir", len(r.LocalOnlyMasters))
+	writeStatusEpisodes(tw, r.LocalOnlyMasters)
+	section(fmt.Sprintf("Masters only in s3://%s", r.InputBucket), len(r.RemoteOnlyMasters))
+	writeStatusEpisodes(tw, r.RemoteOnlyMasters)
+	section("Masters missing both locally and remotely", len(r.MissingMasters))
+	writeStatusEpisodes(tw, r.MissingMasters)
+	fmt.Fprintln(tw)
+	switch {
+	case !r.Feed.Exists:
+		fmt.Fprintf(tw, "Feed s3://%s does not exist\n", path.Join(r.OutputBucket, r.Feed.Key))
+	case r.Feed.Differs:
+		fmt.Fprintf(tw, "Feed s3://%s differs from a fresh render\n", path.Join(r.OutputBucket, r.Feed.Key))
+	default:
+		fmt.Fprintf(tw, "Feed s3://%s is up to date\n", path.Join(r.OutputBucket, r.Feed.Key))
+	}
+	return tw.Flush()
+}
+
+func writeStatusEpisodes(w io.Writer, episodes []StatusEpisode) {
+	if len(episodes) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "UID\tTITLE\tKEY")
+	for _, e := range episodes {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", e.UID, e.Title, e.Key)
+	}
+}
+
+// localFileExists returns true if file exists, false if it does not or
+// error if stat failed for another reason.
+func localFileExists(file string) (bool, error) {
+	_, err := os.Stat(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
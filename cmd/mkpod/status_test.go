@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestStatusReport(t *testing.T) {
+	fake, endpoint := newFakeS3(t)
+	dir := t.TempDir()
+	storage := filepath.Join(dir, "storage")
+	if err := os.Mkdir(storage, 0755); err != nil {
+		t.Fatal(err)
+	}
+	spec := filepath.Join(dir, "podspec.yaml")
+	content := "atom: podcast.rss\ntitle: QZJ\nconfig:\n  baseURL: https://pod.example.com\n  localStorageDir: " + storage + "\n  aws:\n    region: eu-north-1\n    endpoint: " + endpoint + "\n    buckets:\n      input: masters\n      output: pod\nepisodes:\n" +
+		"- uid: 1\n  title: One\n  pubDate: Mon, 02 Jan 2006 15:04:05 +0000\n  input: one.wav\n  output: one.mp3\n  length: 3\n" +
+		"- uid: 2\n  title: Two\n  pubDate: Tue, 03 Jan 2006 15:04:05 +0000\n  input: two.wav\n  output: two.mp3\n  length: 3\n" +
+		"- uid: 3\n  title: Three\n  pubDate: Wed, 04 Jan 2006 15:04:05 +0000\n  input: three.wav\n  output: three.mp3\n  length: 3\n" +
+		"- uid: 4\n  title: Four\n  pubDate: Thu, 05 Jan 2006 15:04:05 +0000\n  input: four.wav\n  output: four.mp3\n  length: 3\n"
+	if err := os.WriteFile(spec, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"one.wav", "two.wav"} {
+		if err := os.WriteFile(filepath.Join(storage, name), []byte("wav"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.Put("masters", "one.wav", []byte("wav"))
+	fake.Put("masters", "three.wav", []byte("wav"))
+	fake.Put("pod", "one.mp3", []byte("mp3"))
+	fake.Put("pod", "three.mp3", []byte("mp3mp3"))
+	fake.Put("pod", "four.mp3", []byte("mp3"))
+	fake.Put("pod", "old.mp3", []byte("old"))
+
+	shows, err := selectShows(globalTestContext(t, spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := shows[0]
+	if err := s.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	s.Aws.NewSession()
+	report, err := s.newStatusReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func(episodes []StatusEpisode) string {
+		var k []string
+		for _, e := range episodes {
+			k = append(k, e.Key)
+		}
+		return strings.Join(k, ",")
+	}
+	if k := keys(report.MissingOutputs); k != "two.mp3" {
+		t.Errorf("expected two.mp3 missing in the output bucket, got %q", k)
+	}
+	if len(report.SizeMismatches) != 1 || report.SizeMismatches[0].Key != "three.mp3" || report.SizeMismatches[0].Length != 3 || report.SizeMismatches[0].RemoteSize != 6 {
+		t.Errorf("expected a size mismatch of three.mp3, got %+v", report.SizeMismatches)
+	}
+	if len(report.UnreferencedObjects) != 1 || report.UnreferencedObjects[0].Key != "old.mp3" {
+		t.Errorf("expected old.mp3 unreferenced, got %+v", report.UnreferencedObjects)
+	}
+	if k := keys(report.LocalOnlyMasters); k != "two.wav" {
+		t.Errorf("expected two.wav only local, got %q", k)
+	}
+	if k := keys(report.RemoteOnlyMasters); k != "three.wav" {
+		t.Errorf("expected three.wav only remote, got %q", k)
+	}
+	if k := keys(report.MissingMasters); k != "four.wav" {
+		t.Errorf("expected four.wav missing, got %q", k)
+	}
+	if report.Feed.Exists || !report.Drift() {
+		t.Errorf("expected drift and no feed, got %+v", report.Feed)
+	}
+
+	var table bytes.Buffer
+	if err := report.WriteTable(&table); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"Episodes with output missing in s3://pod (1)",
+		"Episodes where remote size differs from length (1)",
+		"3    Three  three.mp3  3       6",
+		"Objects in s3://pod not referenced by any episode (1)",
+		"old.mp3",
+		"Masters only in localStorageDir (1)",
+		"Masters only in s3://masters (1)",
+		"Masters missing both locally and remotely (1)",
+		"4    Four   four.wav",
+		"Feed s3://pod/podcast.rss does not exist",
+	} {
+		if !strings.Contains(table.String(), expected) {
+			t.Errorf("expected %q in the table:\n%s", expected, table.String())
+		}
+	}
+
+	statusContext := func(args ...string) *cli.Context {
+		set := flag.NewFlagSet("status", flag.ContinueOnError)
+		set.String("output", "table", "")
+		set.Bool("exit-code", false, "")
+		if err := set.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return cli.NewContext(cli.NewApp(), set, nil)
+	}
+	err = s.status(statusContext("--output", "json", "--exit-code"))
+	var exitErr cli.ExitCoder
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("expected exit code 1 on drift, got %v", err)
+	}
+	if err := s.status(statusContext("--output", "json")); err != nil {
+		t.Errorf("expected no error without --exit-code, got %v", err)
+	}
+
+	// Publish the feed and fix the drift, only the remote-only master
+	// remains which is not drift.
+	feed, err := s.renderFeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Put("pod", "podcast.rss", feed)
+	fake.Put("pod", "two.mp3", []byte("mp3"))
+	fake.Put("pod", "three.mp3", []byte("mp3"))
+	fake.Put("masters", "two.wav", []byte("wav"))
+	fake.Put("masters", "four.wav", []byte("wav"))
+	if err := s.Aws.Remove("pod", "old.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.status(statusContext("--exit-code")); err != nil {
+		t.Errorf("expected no drift, got %v", err)
+	}
+	report, err = s.newStatusReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Feed.Exists || report.Feed.Differs || report.Drift() {
+		t.Errorf("expected an up to date feed and no drift, got %+v", report)
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"missingOutputs":[]`, `"unreferencedObjects":[]`, `"sizeMismatches":[]`, `"localOnlyMasters":[]`, `"missingMasters":[]`} {
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("expected %s in %s", expected, b)
+		}
+	}
+}
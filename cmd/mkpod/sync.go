@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Content-hash based synchronisation between localStorageDir and the
+// S3 buckets. Single-part uploads are compared by MD5 (the ETag),
+// multipart uploads by the SHA-256 stored as object metadata on
+// upload. Local hashes and the last known state of remote objects are
+// cached in a manifest file so that repeated runs only need to hash
+// files that have changed. Shows sharing localStorageDir (e.g running
+// concurrently in serve-schedule) share the manifest and the upload and
+// restore states.
+
+const (
+	defaultManifestFile string = ".mkpod-manifest.json"
+	// Object metadata key (x-amz-meta-sha256) holding the hex encoded
+	// SHA-256 of the object content.
+	sha256MetadataKey string = "Sha256"
+)
+
+// Manifest caches hashes of local files and the state of remote
+// objects as of the last upload or download.
+type Manifest struct {
+	Files   map[string]ManifestFile   `json:"files"`
+	Objects map[string]ManifestObject `json:"objects"`
+	path    string
+	mu      sync.Mutex
+}
+
+// localState holds the manifest and the upload and restore states in
+// use by absolute path to their file.
+var localState = struct {
+	sync.Mutex
+	manifests map[string]*Manifest
+	uploads   map[string]*UploadState
+	restores  map[string]*RestoreState
+}{
+	manifests: make(map[string]*Manifest),
+	uploads:   make(map[string]*UploadState),
+	restores:  make(map[string]*RestoreState),
+}
+
+// ManifestFile is keyed by absolute path to the local file. The hashes
+// are considered valid as long as size and modification time match.
+type ManifestFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	MD5     string    `json:"md5"`
+	SHA256  string    `json:"sha256"`
+}
+
+// ManifestObject is keyed by bucket/key and holds the state of the
+// remote object when it was last known to be identical to File.
+type ManifestObject struct {
+	ETag   string `json:"etag"`
+	SHA256 string `json:"sha256"`
+	File   string `json:"file"`
+}
+
+// RemoteObject is the result of a HeadObject.
+type RemoteObject struct {
+	ETag                 string
+	SHA256               string
+	Size                 int64
+	ContentType          string
+	StorageClass         string
+	CacheControl         string
+	ContentDisposition   string
+	ServerSideEncryption string
+	Metadata             map[string]string
+	// x-amz-restore and x-amz-archive-status headers.
+	Restore       string
+	ArchiveStatus string
+}
+
+// Multipart returns true if the ETag is not the MD5 of the content.
+func (r *RemoteObject) Multipart() bool {
+	return strings.Contains(r.ETag, "-")
+}
+
+// LoadManifest reads the manifest from file or returns an empty one if
+// file does not exist.
+func LoadManifest(file string) (*Manifest, error) {
+	m := &Manifest{
+		Files:   make(map[string]ManifestFile),
+		Objects: make(map[string]ManifestObject),
+		path:    file,
+	}
+	if err := loadJSON(file, m); err != nil {
+		return nil, err
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]ManifestFile)
+	}
+	if m.Objects == nil {
+		m.Objects = make(map[string]ManifestObject)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to the file it was loaded from.
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return saveJSON(m.path, m)
+}
+
+// loadJSON unmarshals file into v, v is left untouched if file does not
+// exist.
+func loadJSON(file string, v any) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// saveJSON writes v as indented json to a temporary file which is then
+// renamed to file.
+func saveJSON(file string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(file, b, 0644)
+}
+
+// FileHashes returns the MD5 and SHA-256 of file, cached hashes are
+// returned if size and modification time have not changed.
+func (m *Manifest) FileHashes(file string) (ManifestFile, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	m.mu.Lock()
+	cached, ok := m.Files[abs]
+	m.mu.Unlock()
+	if ok && cached.Size == fi.Size() && cached.ModTime.Equal(fi.ModTime()) {
+		return cached, nil
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer f.Close()
+	md5sum := md5.New()
+	sha256sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5sum, sha256sum), f); err != nil {
+		return ManifestFile{}, err
+	}
+	entry := ManifestFile{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		MD5:     hex.EncodeToString(md5sum.Sum(nil)),
+		SHA256:  hex.EncodeToString(sha256sum.Sum(nil)),
+	}
+	m.mu.Lock()
+	m.Files[abs] = entry
+	m.mu.Unlock()
+	return entry, nil
+}
+
+// Identical returns true if the content of local (as returned by
+// FileHashes) is the same as remote. Multipart objects without a
+// SHA-256 in their metadata can not be compared and are considered
+// different unless the manifest recorded them as identical to file
+// with the same ETag.
+func (m *Manifest) Identical(bucket, key, file string, local ManifestFile, remote *RemoteObject) bool {
+	if remote.Size != local.Size {
+		return false
+	}
+	if remote.SHA256 != "" {
+		return strings.EqualFold(remote.SHA256, local.SHA256)
+	}
+	if !remote.Multipart() {
+		return strings.EqualFold(remote.ETag, local.MD5)
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	m.mu.Lock()
+	obj, ok := m.Objects[path.Join(bucket, key)]
+	m.mu.Unlock()
+	if ok {
+		return obj.ETag == remote.ETag && obj.File == abs && obj.SHA256 == local.SHA256
+	}
+	return false
+}
+
+// Synced records that bucket/key with etag is identical to file.
+func (m *Manifest) Synced(bucket, key, file, etag string, local ManifestFile) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Objects[path.Join(bucket, key)] = ManifestObject{
+		ETag:   etag,
+		SHA256: local.SHA256,
+		File:   abs,
+	}
+}
+
+// manifest returns the manifest stored in localStorageDir, loading it
+// on first use (running loadConfig() prior to calling this function
+// is required).
+func (s *AwsHandler) manifest() (*Manifest, error) {
+	if s.Manifest == nil {
+		file := absPath(path.Join(s.Atom.LocalStorageDirExpanded(), defaultManifestFile))
+		localState.Lock()
+		defer localState.Unlock()
+		m, ok := localState.manifests[file]
+		if !ok {
+			var err error
+			if m, err = LoadManifest(file); err != nil {
+				return nil, err
+			}
+			localState.manifests[file] = m
+		}
+		s.Manifest = m
+	}
+	return s.Manifest, nil
+}
+
+// Head returns ETag, SHA-256 metadata, size, content type, storage
+// class and restore status of bucket/key.
+func (s *AwsHandler) Head(bucket string, key string) (*RemoteObject, error) {
+	result, err := s.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	remote := &RemoteObject{
+		ETag:                 strings.Trim(aws.StringValue(result.ETag), `"`),
+		Size:                 aws.Int64Value(result.ContentLength),
+		ContentType:          aws.StringValue(result.ContentType),
+		StorageClass:         aws.StringValue(result.StorageClass),
+		CacheControl:         aws.StringValue(result.CacheControl),
+		ContentDisposition:   aws.StringValue(result.ContentDisposition),
+		ServerSideEncryption: aws.StringValue(result.ServerSideEncryption),
+		Metadata:             make(map[string]string),
+		Restore:              aws.StringValue(result.Restore),
+		ArchiveStatus:        aws.StringValue(result.ArchiveStatus),
+	}
+	for k, v := range result.Metadata {
+		remote.Metadata[k] = aws.StringValue(v)
+		if strings.EqualFold(k, sha256MetadataKey) {
+			remote.SHA256 = aws.StringValue(v)
+		}
+	}
+	return remote, nil
+}
+
+// IsIdentical returns true if file has the same content as
+// bucket/key. Returns an awserr.Error with code NotFound if the object
+// does not exist.
+func (s *AwsHandler) IsIdentical(bucket string, key string, file string) (bool, ManifestFile, *RemoteObject, error) {
+	m, err := s.manifest()
+	if err != nil {
+		return false, ManifestFile{}, nil, err
+	}
+	local, err := m.FileHashes(file)
+	if err != nil {
+		return false, ManifestFile{}, nil, err
+	}
+	remote, err := s.Head(bucket, key)
+	if err != nil {
+		return false, local, nil, err
+	}
+	identical := m.Identical(bucket, key, file, local, remote)
+	if identical {
+		m.Synced(bucket, key, file, remote.ETag, local)
+	}
+	return identical, local, remote, m.Save()
+}
+
+// recordSync refreshes the manifest after file has been uploaded to
+// or downloaded from bucket/key.
+func (s *AwsHandler) recordSync(bucket string, key string, file string) error {
+	m, err := s.manifest()
+	if err != nil {
+		return err
+	}
+	local, err := m.FileHashes(file)
+	if err != nil {
+		return err
+	}
+	remote, err := s.Head(bucket, key)
+	if err != nil {
+		return err
+	}
+	m.Synced(bucket, key, file, remote.ETag, local)
+	return m.Save()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "episode.mp3")
+	if err := os.WriteFile(file, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadManifest(filepath.Join(dir, defaultManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := m.FileHashes(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "5eb63bbbe01eeed093cb22bb8f5acdc3"; local.MD5 != expected {
+		t.Errorf("expected md5 %s, got %s", expected, local.MD5)
+	}
+	if expected := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"; local.SHA256 != expected {
+		t.Errorf("expected sha256 %s, got %s", expected, local.SHA256)
+	}
+
+	singlepart := &RemoteObject{ETag: local.MD5, Size: local.Size}
+	if !m.Identical("bucket", "episode.mp3", file, local, singlepart) {
+		t.Error("expected single-part object with matching ETag to be identical")
+	}
+	multipart := &RemoteObject{ETag: "0123456789abcdef0123456789abcdef-2", Size: local.Size}
+	if m.Identical("bucket", "episode.mp3", file, local, multipart) {
+		t.Error("expected multipart object without sha256 metadata to differ")
+	}
+	m.Synced("bucket", "episode.mp3", file, multipart.ETag, local)
+	if !m.Identical("bucket", "episode.mp3", file, local, multipart) {
+		t.Error("expected multipart object recorded in manifest to be identical")
+	}
+	multipart.SHA256 = local.SHA256
+	multipart.ETag = "fedcba9876543210fedcba9876543210-3"
+	if !m.Identical("bucket", "episode.mp3", file, local, multipart) {
+		t.Error("expected multipart object with matching sha256 metadata to be identical")
+	}
+	multipart.Size++
+	if m.Identical("bucket", "episode.mp3", file, local, multipart) {
+		t.Error("expected object with different size to differ")
+	}
+
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadManifest(filepath.Join(dir, defaultManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := reloaded.FileHashes(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.ModTime.Equal(local.ModTime) || cached.MD5 != local.MD5 || cached.SHA256 != local.SHA256 {
+		t.Errorf("expected cached hashes %+v, got %+v", local, cached)
+	}
+}
+
+func TestSharedManifest(t *testing.T) {
+	dir := t.TempDir()
+	shows := []*Show{NewShow("qzj", ""), NewShow("sm0", "")}
+	for _, s := range shows {
+		s.Atom.Config.LocalStorageDir = dir
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(shows)*10)
+	for _, s := range shows {
+		wg.Add(1)
+		go func(s *Show) {
+			defer wg.Done()
+			for n := 0; n < 10; n++ {
+				file := filepath.Join(dir, fmt.Sprintf("%s%03d.mp3", s.Name, n))
+				if err := os.WriteFile(file, []byte(file), 0644); err != nil {
+					errs <- err
+					return
+				}
+				m, err := s.Aws.manifest()
+				if err != nil {
+					errs <- err
+					return
+				}
+				local, err := m.FileHashes(file)
+				if err != nil {
+					errs <- err
+					return
+				}
+				m.Synced("pod", filepath.Base(file), file, local.MD5, local)
+				errs <- m.Save()
+				state, err := s.Aws.uploadState()
+				if err != nil {
+					errs <- err
+					return
+				}
+				errs <- state.Save()
+			}
+		}(s)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if shows[0].Aws.Manifest != shows[1].Aws.Manifest || shows[0].Aws.Uploads != shows[1].Aws.Uploads {
+		t.Error("expected shows with the same localStorageDir to share the manifest and upload state")
+	}
+	m, err := LoadManifest(filepath.Join(dir, defaultManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Objects) != 20 || len(m.Files) != 20 {
+		t.Errorf("expected the objects and files of both shows in the manifest, got %d and %d", len(m.Objects), len(m.Files))
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) > 0 {
+		t.Errorf("expected no temporary files left, got %v", tmp)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	id3v2 "github.com/bogem/id3v2"
+	"github.com/sa6mwa/id3v24"
+	"github.com/sa6mwa/mp3duration"
+)
+
+// Tagging of encoded output files. Every encoder (lame, ffmpeg piped
+// into lame, ffmpeg to m4a/m4b and ffmpeg to mp4) builds its metadata
+// with NewPodcastTags so that all formats carry the same information.
+
+// iTunes media kind (stik) for podcasts, written as media_type by
+// ffmpeg's mp4 muxer.
+const mp4MediaTypePodcast string = "21"
+
+// PodcastTags extends id3v24.TrackInfo with the podcast specific
+// frames not covered by the id3v24 package.
+type PodcastTags struct {
+	id3v24.TrackInfo
+	AudioFileURL       string // WOAR, official audio file webpage
+	FeedURL            string // WFED, podcast feed URL (iTunes)
+	PodcastDescription string // TDES, podcast description (iTunes)
+	GUID               string // TGID, podcast episode identifier (iTunes)
+	Lyrics             string // USLT, unsynchronised lyrics/transcript
+}
+
+// NewPodcastTags returns the tags for episode. Episode.Output should
+// be set prior to calling this function as it is used for the GUID. If
+// the episode has a transcript, it is read from localStorageDir and
+// returned as Lyrics.
+func NewPodcastTags(a *Atom, episode *Episode) (*PodcastTags, error) {
+	if episode == nil {
+		return nil, errors.New("received nil pointer episode")
+	}
+	rplcr := strings.NewReplacer("\n", " ", "\r", "")
+	lang := a.Encoding.Language
+	if episode.EncodingLanguage != "" {
+		lang = episode.EncodingLanguage
+	}
+	tags := &PodcastTags{
+		TrackInfo: id3v24.TrackInfo{
+			Title:       episode.Title,
+			Album:       a.Title,
+			Artist:      episode.Author,
+			Genre:       a.Encoding.Genre,
+			Year:        episode.PubDate.Format("2006"),
+			Date:        episode.PubDate.Time,
+			Track:       fmt.Sprintf("%d", episode.UID),
+			Comment:     episode.Link,
+			Description: rplcr.Replace(episode.Subtitle),
+			Language:    strings.ToLower(lang),
+			Copyright:   a.Copyright,
+			Chapters:    episode.Chapters,
+		},
+		AudioFileURL:       a.EpisodeLink(episode),
+		FeedURL:            a.FeedURL(),
+		PodcastDescription: strings.TrimSpace(episode.Description),
+		GUID:               a.EpisodeGUID(episode),
+	}
+	if strings.TrimSpace(a.Encoding.Coverfront) != "" {
+		tags.CoverJPEG = path.Join(a.LocalStorageDirExpanded(), a.Encoding.Coverfront)
+	}
+	if strings.TrimSpace(episode.Transcript) != "" {
+		transcriptPath := path.Join(a.LocalStorageDirExpanded(), episode.Transcript)
+		b, err := os.ReadFile(transcriptPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read transcript: %w", err)
+		}
+		tags.Lyrics = TranscriptToText(b)
+	}
+	return tags, nil
+}
+
+// id3Language returns a three letter ISO-639-2 language code as
+// required by the COMM and USLT frames. Two letter ISO-639-1 codes (the
+// common form in the rss language element, e.g en or en-us) are mapped
+// to their ISO-639-2 code. Returns "und" (undetermined) if lang is
+// neither.
+func (t *PodcastTags) id3Language() string {
+	lang := strings.ToLower(strings.TrimSpace(t.Language))
+	if primary, _, found := strings.Cut(strings.ReplaceAll(lang, "_", "-"), "-"); found {
+		lang = primary
+	}
+	if len(lang) == 2 {
+		if code, found := iso6391To6392[lang]; found {
+			return code
+		}
+	}
+	if len(lang) != 3 {
+		return "und"
+	}
+	return lang
+}
+
+// ISO-639-1 to ISO-639-2 (the bibliographic code where it differs from
+// the terminological one, as used by ID3).
+var iso6391To6392 = map[string]string{
+	"ar": "ara", "bg": "bul", "ca": "cat", "cs": "cze", "cy": "wel",
+	"da": "dan", "de": "ger", "el": "gre", "en": "eng", "eo": "epo",
+	"es": "spa", "et": "est", "eu": "baq", "fa": "per", "fi": "fin",
+	"fo": "fao", "fr": "fre", "ga": "gle", "he": "heb", "hi": "hin",
+	"hr": "hrv", "hu": "hun", "id": "ind", "is": "ice", "it": "ita",
+	"ja": "jpn", "ko": "kor", "la": "lat", "lt": "lit", "lv": "lav",
+	"nb": "nob", "nl": "dut", "nn": "nno", "no": "nor", "pl": "pol",
+	"pt": "por", "ro": "rum", "ru": "rus", "se": "sme", "sk": "slo",
+	"sl": "slv", "sr": "srp", "sv": "swe", "th": "tha", "tr": "tur",
+	"uk": "ukr", "vi": "vie", "zh": "chi",
+}
+
+// date returns the TDRC (recording time) value, yyyy-mm-dd if Date is
+// set, otherwise Year.
+func (t *PodcastTags) date() string {
+	if !t.Date.IsZero() {
+		return t.Date.Format("2006-01-02")
+	}
+	return t.Year
+}
+
+// ApplyTo adds all frames to tag. duration is needed to calculate the
+// end of the last chapter. If an error is returned, the tag should be
+// considered corrupt and not be saved.
+func (t *PodcastTags) ApplyTo(tag *id3v2.Tag, duration mp3duration.Info) error {
+	tag.SetVersion(4)
+	enc := tag.DefaultEncoding()
+	textFrames := []struct {
+		id    string
+		value string
+	}{
+		{"TIT2", t.Title},
+		{"TALB", t.Album},
+		{"TPE1", t.Artist},
+		{"TCON", t.Genre},
+		{"TDRC", t.date()},
+		{"TRCK", t.Track},
+		{"TIT3", t.Description},
+		{"TLAN", t.Language},
+		{"TCOP", t.Copyright},
+		{"TDES", t.PodcastDescription},
+		{"TGID", t.GUID},
+		// WFED is written as a text frame by iTunes, not as a URL frame.
+		{"WFED", t.FeedURL},
+	}
+	for _, f := range textFrames {
+		if len([]rune(f.value)) > 0 {
+			tag.AddTextFrame(f.id, enc, f.value)
+		}
+	}
+	if len([]rune(t.AudioFileURL)) > 0 {
+		// URL link frames have no encoding byte, always ISO-8859-1.
+		tag.AddFrame("WOAR", id3v2.UnknownFrame{Body: []byte(t.AudioFileURL)})
+	}
+	if len([]rune(t.Comment)) > 0 {
+		tag.AddCommentFrame(id3v2.CommentFrame{
+			Encoding: enc,
+			Language: t.id3Language(),
+			Text:     t.Comment,
+		})
+	}
+	if len([]rune(t.Lyrics)) > 0 {
+		tag.AddUnsynchronisedLyricsFrame(id3v2.UnsynchronisedLyricsFrame{
+			Encoding: enc,
+			Language: t.id3Language(),
+			Lyrics:   t.Lyrics,
+		})
+	}
+	// The podcast flag, iTunes writes four zero bytes.
+	tag.AddFrame("PCST", id3v2.UnknownFrame{Body: []byte{0x00, 0x00, 0x00, 0x00}})
+	if len([]rune(t.CoverJPEG)) > 0 {
+		if err := id3v24.AddCoverJPEG(tag, t.CoverJPEG); err != nil {
+			return err
+		}
+	}
+	if len(t.Chapters) > 0 {
+		if err := id3v24.AddCHAPAndCTOC(duration, tag, t.Chapters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify compares tag with t and returns an error listing every frame
+// that is missing or differs.
+func (t *PodcastTags) Verify(tag *id3v2.Tag) error {
+	var mismatches []string
+	expectText := func(id, expected string) {
+		if len([]rune(expected)) == 0 {
+			return
+		}
+		if got := frameText(tag, id); got != expected {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %q, expected %q", id, got, expected))
+		}
+	}
+	expectText("TIT2", t.Title)
+	expectText("TALB", t.Album)
+	expectText("TPE1", t.Artist)
+	expectText("TCON", t.Genre)
+	expectText("TDRC", t.date())
+	expectText("TRCK", t.Track)
+	expectText("TIT3", t.Description)
+	expectText("TLAN", t.Language)
+	expectText("TCOP", t.Copyright)
+	expectText("TDES", t.PodcastDescription)
+	expectText("TGID", t.GUID)
+	expectText("WFED", t.FeedURL)
+	expectText("WOAR", t.AudioFileURL)
+	if len([]rune(t.Comment)) > 0 {
+		found := false
+		for _, f := range tag.GetFrames("COMM") {
+			if cf, ok := f.(id3v2.CommentFrame); ok && cf.Text == t.Comment {
+				found = true
+			}
+		}
+		if !found {
+			mismatches = append(mismatches, fmt.Sprintf("COMM %q is missing", t.Comment))
+		}
+	}
+	if len([]rune(t.Lyrics)) > 0 {
+		found := false
+		for _, f := range tag.GetFrames("USLT") {
+			if uslf, ok := f.(id3v2.UnsynchronisedLyricsFrame); ok && uslf.Lyrics == t.Lyrics {
+				found = true
+			}
+		}
+		if !found {
+			mismatches = append(mismatches, "USLT (transcript) is missing or differs")
+		}
+	}
+	if len(tag.GetFrames("PCST")) == 0 {
+		mismatches = append(mismatches, "PCST is missing")
+	}
+	if len([]rune(t.CoverJPEG)) > 0 && len(tag.GetFrames("APIC")) == 0 {
+		mismatches = append(mismatches, "APIC (cover) is missing")
+	}
+	if got := len(tag.GetFrames("CHAP")); got != len(t.Chapters) {
+		mismatches = append(mismatches, fmt.Sprintf("found %d CHAP frames, expected %d", got, len(t.Chapters)))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("tag verification failed: %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// frameText returns the text of the last frame with id, regardless of
+// whether id3v2 parsed it as a text frame or an unknown frame (URL
+// link frames and non-standard frames such as WFED).
+func frameText(tag *id3v2.Tag, id string) string {
+	switch f := tag.GetLastFrame(id).(type) {
+	case id3v2.TextFrame:
+		return f.Text
+	case id3v2.UnknownFrame:
+		body := f.Body
+		if strings.HasPrefix(id, "W") && id != "WFED" {
+			return string(body)
+		}
+		if len(body) > 0 {
+			// Skip the encoding byte. WFED is always read back as
+			// ISO-8859-1 or UTF-8, both are fine for an URL.
+			body = body[1:]
+		}
+		return string(bytes.TrimRight(body, "\x00"))
+	}
+	return ""
+}
+
+// WriteID3v2Tag replaces any existing tag in mp3file with an ID3v2.4
+// tag built from t, then reads the tag back to verify it.
+func (t *PodcastTags) WriteID3v2Tag(mp3file string) error {
+	di, err := mp3duration.ReadFile(mp3file)
+	if err != nil {
+		return err
+	}
+	tag, err := id3v2.Open(mp3file, id3v2.Options{Parse: false})
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+	if err := t.ApplyTo(tag, di); err != nil {
+		return err
+	}
+	if err := tag.Save(); err != nil {
+		return err
+	}
+	return t.VerifyID3v2Tag(mp3file)
+}
+
+// VerifyID3v2Tag parses the tag in mp3file and compares it with t.
+func (t *PodcastTags) VerifyID3v2Tag(mp3file string) error {
+	tag, err := id3v2.Open(mp3file, id3v2.Options{Parse: true})
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+	if err := t.Verify(tag); err != nil {
+		return fmt.Errorf("%s: %w", mp3file, err)
+	}
+	return nil
+}
+
+// FFmpegMetadata returns an ffmpeg metadata file (;FFMETADATA1) with
+// the global tags and chapters. Keys are the ones ffmpeg's mp4 muxer
+// maps to iTunes atoms, there is no equivalent of WFED in mp4.
+func (t *PodcastTags) FFmpegMetadata(duration time.Duration) ([]byte, error) {
+	header := []byte(";FFMETADATA1\n")
+	output := append([]byte{}, header...)
+	kvpairs := []struct {
+		key   string
+		value string
+	}{
+		{"title", t.Title},
+		{"album", t.Album},
+		{"artist", t.Artist},
+		{"genre", t.Genre},
+		{"date", t.date()},
+		{"track", t.Track},
+		{"comment", t.Comment},
+		{"language", t.Language},
+		{"description", t.Description},
+		{"synopsis", t.PodcastDescription},
+		{"copyright", t.Copyright},
+		{"show", t.Album},
+		{"episode_id", t.GUID},
+		{"media_type", mp4MediaTypePodcast},
+		{"lyrics", t.Lyrics},
+	}
+	for _, kv := range kvpairs {
+		if len([]rune(kv.value)) > 0 {
+			output = append(output, []byte(kv.key+"="+escapeFFmetadata(kv.value)+"\n")...)
+		}
+	}
+	chaptersTXT, err := id3v24.GetFFmpegChaptersTXT(mp3duration.Info{TimeDuration: duration}, t.Chapters)
+	if err != nil {
+		return nil, err
+	}
+	output = append(output, bytes.Replace(chaptersTXT, header, nil, 1)...)
+	return output, nil
+}
+
+// WriteFFmpegMetadataFile writes FFmpegMetadata to a temporary file
+// (os.CreateTemp) and returns the full path to it.
+func (t *PodcastTags) WriteFFmpegMetadataFile(duration time.Duration) (string, error) {
+	metadata, err := t.FFmpegMetadata(duration)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "*-ffmetadata.txt")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(metadata); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// VerifyFFprobe reads the tags of an mp4/m4a/m4b file via ffprobe and
+// compares the ones ffprobe reliably reports with t.
+func (t *PodcastTags) VerifyFFprobe(filename string) error {
+	probe, err := FFprobe(filename)
+	if err != nil {
+		return fmt.Errorf("unable to probe %s: %w", filename, err)
+	}
+	var mismatches []string
+	fields := []struct {
+		key      string
+		got      string
+		expected string
+	}{
+		{"title", probe.Format.Tags.Title, t.Title},
+		{"album", probe.Format.Tags.Album, t.Album},
+		{"artist", probe.Format.Tags.Artist, t.Artist},
+		{"genre", probe.Format.Tags.Genre, t.Genre},
+		{"copyright", probe.Format.Tags.Copyright, t.Copyright},
+	}
+	for _, f := range fields {
+		if len([]rune(f.expected)) > 0 && f.got != f.expected {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %q, expected %q", f.key, f.got, f.expected))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%s: tag verification failed: %s", filename, strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// escapeFFmetadata escapes the special characters of the ffmetadata
+// format (=, ;, #, \ and newline).
+func escapeFFmetadata(s string) string {
+	rplcr := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\r", "", "\n", "\\\n")
+	return rplcr.Replace(strings.TrimSpace(s))
+}
+
+var (
+	transcriptTimingRE = regexp.MustCompile(`^\d{1,2}:\d{2}(:\d{2})?[.,]\d{3}\s+-->\s+`)
+	transcriptIndexRE  = regexp.MustCompile(`^\d+$`)
+)
+
+// TranscriptToText returns the plain text of a transcript. WebVTT and
+// SubRip cue numbers, timings and headers are removed, plain text
+// transcripts are returned as is (trimmed).
+func TranscriptToText(transcript []byte) string {
+	text := strings.ReplaceAll(string(transcript), "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	isCues := false
+	for _, line := range lines {
+		if transcriptTimingRE.MatchString(strings.TrimSpace(line)) {
+			isCues = true
+			break
+		}
+	}
+	if !isCues {
+		return strings.TrimSpace(text)
+	}
+	var output []string
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "WEBVTT"), strings.HasPrefix(line, "NOTE"):
+			// Skip header or comment block.
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+				i++
+			}
+		case transcriptTimingRE.MatchString(line):
+			continue
+		case transcriptIndexRE.MatchString(line) && i+1 < len(lines) && transcriptTimingRE.MatchString(strings.TrimSpace(lines[i+1])):
+			continue
+		default:
+			output = append(output, line)
+		}
+	}
+	return strings.Join(output, "\n")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	id3v2 "github.com/bogem/id3v2"
+	"github.com/sa6mwa/id3v24"
+	"github.com/sa6mwa/mp3duration"
+)
+
+func testPodcastTags() *PodcastTags {
+	a := &Atom{
+		Atom:      "podcast.rss",
+		Title:     "QZJ",
+		Copyright: "Copyright SA6MWA 2020-2025 All Rights Reserved.",
+	}
+	a.Config.BaseURL = "https://mypod.s3.eu-west-1.amazonaws.com"
+	a.Encoding.Genre = "Podcast"
+	a.Encoding.Language = "SWE"
+	episode := &Episode{
+		UID:         23,
+		Title:       "PACE vs Sambandstablå",
+		PubDate:     ItunesTime{time.Date(2024, 10, 16, 8, 47, 49, 0, time.UTC)},
+		Link:        "https://qzj.se/audio/qzj023-pace-vs-sambandstabla/",
+		Author:      "SA6MWA",
+		Subtitle:    "Sambandsmedel lika mycket värda",
+		Description: "Alla sambandsmedel är lika mycket värda.\n\n73 DE SA6MWA",
+		Output:      "qzj023-pace-vs-sambandstabla.mp3",
+		Chapters: []id3v24.Chapter{
+			{Title: "Intro", Start: "00:00:00.000"},
+			{Title: "PACE", Start: "00:01:30.000"},
+		},
+	}
+	tags, err := NewPodcastTags(a, episode)
+	if err != nil {
+		panic(err)
+	}
+	tags.Lyrics = "Hej och välkommen."
+	return tags
+}
+
+func TestPodcastTagsRoundTrip(t *testing.T) {
+	tags := testPodcastTags()
+	if got, expected := tags.GUID, "https://mypod.s3.eu-west-1.amazonaws.com/qzj023-pace-vs-sambandstabla.mp3"; got != expected {
+		t.Errorf("expected GUID %q, got %q", expected, got)
+	}
+	if got, expected := tags.FeedURL, "https://mypod.s3.eu-west-1.amazonaws.com/podcast.rss"; got != expected {
+		t.Errorf("expected FeedURL %q, got %q", expected, got)
+	}
+
+	tag := id3v2.NewEmptyTag()
+	if err := tags.ApplyTo(tag, mp3duration.Info{TimeDuration: 10 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if _, err := tag.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := id3v2.ParseReader(buf, id3v2.Options{Parse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tags.Verify(parsed); err != nil {
+		t.Error(err)
+	}
+
+	tags.Title = "Another title"
+	tags.FeedURL = "https://example.com/feed.rss"
+	err = tags.Verify(parsed)
+	if err == nil {
+		t.Fatal("expected verification to fail")
+	}
+	for _, id := range []string{"TIT2", "WFED"} {
+		if !strings.Contains(err.Error(), id) {
+			t.Errorf("expected %s in error: %v", id, err)
+		}
+	}
+}
+
+func TestPodcastTagsFFmpegMetadata(t *testing.T) {
+	tags := testPodcastTags()
+	b, err := tags.FFmpegMetadata(10 * time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := string(b)
+	for _, expected := range []string{
+		";FFMETADATA1\n",
+		"title=PACE vs Sambandstablå\n",
+		"date=2024-10-16\n",
+		"media_type=21\n",
+		"synopsis=Alla sambandsmedel är lika mycket värda.\\\n\\\n73 DE SA6MWA\n",
+		"\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=90000\nEND=600000\ntitle=PACE\n",
+	} {
+		if !strings.Contains(metadata, expected) {
+			t.Errorf("expected %q in:\n%s", expected, metadata)
+		}
+	}
+	if strings.Count(metadata, ";FFMETADATA1") != 1 {
+		t.Errorf("expected a single header in:\n%s", metadata)
+	}
+}
+
+func TestTranscriptToText(t *testing.T) {
+	vtt := "WEBVTT\nKind: captions\n\nNOTE a comment\nspanning two lines\n\n1\n00:00:00.000 --> 00:00:02.500\nHej och välkommen.\n\n2\n00:00:02.500 --> 00:00:05.000\nDet här är QZJ.\n"
+	srt := "1\r\n00:00:00,000 --> 00:00:02,500\r\nHej och välkommen.\r\n\r\n2\r\n00:00:02,500 --> 00:00:05,000\r\nDet här är QZJ.\r\n"
+	expected := "Hej och välkommen.\nDet här är QZJ."
+	if got := TranscriptToText([]byte(vtt)); got != expected {
+		t.Errorf("expected: %q\ngot: %q", expected, got)
+	}
+	if got := TranscriptToText([]byte(srt)); got != expected {
+		t.Errorf("expected: %q\ngot: %q", expected, got)
+	}
+	if got := TranscriptToText([]byte("\n  Plain text transcript.\n")); got != "Plain text transcript." {
+		t.Errorf("expected plain text to be trimmed, got %q", got)
+	}
+}
+
+func TestID3Language(t *testing.T) {
+	for lang, expected := range map[string]string{
+		"en":      "eng",
+		"sv":      "swe",
+		"SV-se":   "swe",
+		"en_US":   "eng",
+		"de":      "ger",
+		"SWE":     "swe",
+		"xx":      "und",
+		"":        "und",
+		"svenska": "und",
+	} {
+		tags := &PodcastTags{}
+		tags.Language = lang
+		if got := tags.id3Language(); got != expected {
+			t.Errorf("expected %q for %q, got %q", expected, lang, got)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/alessio/shellescape.v1"
+)
+
+// Templates of the rss feed and of the encoding and pre-processing
+// commands can be replaced by files configured in the templates section
+// of the spec. Every template not configured falls back to the default
+// embedded in mkpod (written out for editing by mkpod templates dump).
+// Templates are parsed and dry-rendered when the spec is loaded.
+
+// TemplateFiles are the file names of the templates in the spec.
+type TemplateFiles struct {
+	RSS          string `yaml:"rss,omitempty"`
+	Lame         string `yaml:"lame,omitempty"`
+	FFmpeg       string `yaml:"ffmpeg,omitempty"`
+	FFmpegToLame string `yaml:"ffmpegToLame,omitempty"`
+	FFmpegM4A    string `yaml:"ffmpegM4A,omitempty"`
+	Preprocess   string `yaml:"preprocess,omitempty"`
+	Retag        string `yaml:"retag,omitempty"`
+}
+
+// templateSource is a configurable template.
+type templateSource struct {
+	// Key in the templates section.
+	Name string
+	// File name used by templates dump.
+	DumpFile string
+	Default  string
+	// The template in use.
+	current *string
+	file    func(t *TemplateFiles) string
+}
+
+// templateSources returns every configurable template.
+func (s *Show) templateSources() []templateSource {
+	return []templateSource{
+		{"rss", "template.rss", defaultRSSTemplate, &s.rssTemplate, func(t *TemplateFiles) string { return t.RSS }},
+		{"lame", "lame.tmpl", defaultLameCommandTemplate, &s.lameCommandTemplate, func(t *TemplateFiles) string { return t.Lame }},
+		{"ffmpeg", "ffmpeg.tmpl", defaultFFmpegCommandTemplate, &s.ffmpegCommandTemplate, func(t *TemplateFiles) string { return t.FFmpeg }},
+		{"ffmpegToLame", "ffmpegToLame.tmpl", defaultFFmpegToAudioCommandTemplate, &s.ffmpegToAudioCommandTemplate, func(t *TemplateFiles) string { return t.FFmpegToLame }},
+		{"ffmpegM4A", "ffmpegM4A.tmpl", defaultFFmpegToM4ACommandTemplate, &s.ffmpegToM4ACommandTemplate, func(t *TemplateFiles) string { return t.FFmpegM4A }},
+		{"preprocess", "preprocess.tmpl", defaultFFmpegPreProcessingCommandTemplate, &s.ffmpegPreProcessingCommandTemplate, func(t *TemplateFiles) string { return t.Preprocess }},
+		{"retag", "retag.tmpl", defaultFFmpegRetagCommandTemplate, &s.ffmpegRetagCommandTemplate, func(t *TemplateFiles) string { return t.Retag }},
+	}
+}
+
+// templateFile returns the file of the template, given with --template
+// (relative to the current directory) or in the templates section
+// (relative to the spec), empty for the default.
+func (s *Show) templateFile(src templateSource) string {
+	if file, found := s.templateFlags[src.Name]; found {
+		return resolvetilde(strings.TrimSpace(file))
+	}
+	if file := strings.TrimSpace(src.file(&s.Atom.Templates)); file != "" {
+		return s.specRelative(file)
+	}
+	return ""
+}
+
+// setTemplateFlags sets the templates given with --template as name=file
+// (e.g lame=templates/lame.tmpl), a file without name is the rss
+// template. They override the templates section without being written
+// back into the spec. The templates are reloaded.
+func (s *Show) setTemplateFlags(values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	var names []string
+	for _, src := range s.templateSources() {
+		names = append(names, src.Name)
+	}
+	if s.templateFlags == nil {
+		s.templateFlags = make(map[string]string)
+	}
+	for _, v := range values {
+		name, file, found := strings.Cut(v, "=")
+		if !found {
+			name, file = "rss", v
+		}
+		name, file = strings.TrimSpace(name), strings.TrimSpace(file)
+		if !slices.Contains(names, name) {
+			return fmt.Errorf("unknown template %q in --template %s, expected one of %s", name, v, strings.Join(names, ", "))
+		}
+		if file == "" {
+			return fmt.Errorf("missing file in --template %s", v)
+		}
+		s.templateFlags[name] = file
+	}
+	return s.loadTemplates()
+}
+
+// templateFlag returns the --template flag of the commands rendering
+// templates.
+func templateFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:    "template",
+		Aliases: []string{"t"},
+		Usage:   "Template file as name=file (rss, lame, ffmpeg, ffmpegToLame, ffmpegM4A, preprocess or retag) overriding the templates section, a file without name= is the rss template, can be repeated",
+	}
+}
+
+// commandFuncMap returns the functions available in the command
+// templates.
+func commandFuncMap() template.FuncMap {
+	return template.FuncMap{
+		"escape": func(s string) string {
+			return shellescape.Quote(s)
+		},
+		"markdown": func(s string) string {
+			return MarkdownToHTML(s)
+		},
+	}
+}
+
+// loadTemplates reads the templates configured in the templates section
+// of the atom (defaults for the rest) and validates them with a dry
+// render. The templates in use are kept if one fails.
+func (s *Show) loadTemplates() error {
+	sources := s.templateSources()
+	loaded := make([]string, len(sources))
+	for i, src := range sources {
+		file := s.templateFile(src)
+		if file == "" {
+			loaded[i] = src.Default
+			continue
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("unable to read %s template in %s: %w", src.Name, s.SpecFile, err)
+		}
+		loaded[i] = string(b)
+	}
+	previous := make([]string, len(sources))
+	for i, src := range sources {
+		previous[i] = *src.current
+		*src.current = loaded[i]
+	}
+	if err := s.validateTemplates(); err != nil {
+		for i, src := range sources {
+			*src.current = previous[i]
+		}
+		return err
+	}
+	return nil
+}
+
+// validateTemplates parses every template and renders it with the atom
+// (and its first episode) without using the output.
+func (s *Show) validateTemplates() error {
+	sample := s.templateSample()
+	for _, src := range s.templateSources() {
+		var t *template.Template
+		var err error
+		if src.Name == "rss" {
+			t, err = s.feedTemplate(s.Atom.ProductionTarget())
+		} else {
+			t, err = template.New(src.Name).Funcs(commandFuncMap()).Parse(*src.current)
+		}
+		if err == nil {
+			err = t.Execute(io.Discard, sample)
+		}
+		if err != nil {
+			if file := s.templateFile(src); file != "" {
+				return fmt.Errorf("%s template %s in %s: %w", src.Name, file, s.SpecFile, err)
+			}
+			return fmt.Errorf("%s template: %w", src.Name, err)
+		}
+	}
+	return nil
+}
+
+// templateSample returns the data templates are dry-rendered with, the
+// first episode of the atom or an example episode if there is none.
+func (s *Show) templateSample() Combined {
+	episode := Episode{
+		UID:    1,
+		Title:  "Example",
+		Input:  "example.wav",
+		Output: "example.mp3",
+	}
+	if len(s.Atom.Episodes) > 0 {
+		episode = s.Atom.Episodes[0]
+	}
+	return Combined{
+		Atom:    &s.Atom,
+		Episode: &episode,
+		PreProcess: &PreProcess{
+			Input:  "example.wav",
+			Prefix: defaultPreProcessingPrefix,
+			Preset: defaultPreset,
+		},
+		MetadataFile: "example.ffmetadata",
+		TempFile:     "example.tmp",
+	}
+}
+
+func templatesDump(c *cli.Context) error {
+	opts := &RunOptions{Force: c.Bool("force")}
+	dir := c.String("dir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var written []templateSource
+	for _, src := range NewShow("", "").templateSources() {
+		file := filepath.Join(dir, src.DumpFile)
+		if _, err := os.Stat(file); err == nil {
+			if !opts.doAction("Overwrite %s?", file) {
+				continue
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := os.WriteFile(file, []byte(src.Default), 0644); err != nil {
+			return err
+		}
+		log.Printf("Wrote default %s template to %s", src.Name, file)
+		written = append(written, src)
+	}
+	if len(written) > 0 {
+		fmt.Println("templates:")
+		for _, src := range written {
+			fmt.Printf("  %s: %s\n", src.Name, filepath.Join(dir, src.DumpFile))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	s := NewShow("", "")
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "lame.tmpl")
+	if err := os.WriteFile(good, []byte(`lame -V2 {{ escape .Episode.Input }} {{ escape .Episode.Output }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.tmpl")
+	if err := os.WriteFile(bad, []byte(`lame {{ .Episode.NoSuchField }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Atom = Atom{Atom: "podcast.rss"}
+	s.Atom.Templates.Lame = good
+	if err := s.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.lameCommandTemplate, "lame -V2") {
+		t.Errorf("expected lame template from %s, got %s", good, s.lameCommandTemplate)
+	}
+	if s.rssTemplate != defaultRSSTemplate || s.ffmpegCommandTemplate != defaultFFmpegCommandTemplate {
+		t.Error("expected embedded defaults for templates not configured")
+	}
+
+	s.Atom.Templates.Lame = bad
+	err := s.loadTemplates()
+	if err == nil || !strings.Contains(err.Error(), "NoSuchField") {
+		t.Fatalf("expected dry render to fail on NoSuchField, got %v", err)
+	}
+	if !strings.HasPrefix(s.lameCommandTemplate, "lame -V2") {
+		t.Error("expected previous templates to be kept when validation fails")
+	}
+
+	s.Atom.Templates.Lame = ""
+	if err := s.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if s.lameCommandTemplate != defaultLameCommandTemplate {
+		t.Error("expected default lame template when not configured")
+	}
+
+	// --template overrides the templates section without changing it.
+	rss := filepath.Join(dir, "template.rss")
+	if err := os.WriteFile(rss, []byte(`{{ with .Atom }}<rss>{{ feedURL }}</rss>{{ end }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.Atom.Templates.Lame = bad
+	if err := s.setTemplateFlags([]string{"lame=" + good, rss}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.lameCommandTemplate, "lame -V2") || !strings.HasPrefix(s.rssTemplate, "{{ with .Atom }}<rss>") {
+		t.Error("expected the lame and rss templates given with --template")
+	}
+	if s.Atom.Templates.Lame != bad || s.Atom.Templates.RSS != "" {
+		t.Errorf("expected templates section to be left as is, got %+v", s.Atom.Templates)
+	}
+	for _, v := range []string{"lam=" + good, "ffmpeg="} {
+		if err := s.setTemplateFlags([]string{v}); err == nil {
+			t.Errorf("expected error for --template %s", v)
+		}
+	}
+
+	// The templates section is relative to the spec, --template to the
+	// current directory.
+	s = NewShow("", filepath.Join(dir, "podspec.yaml"))
+	s.Atom = Atom{Atom: "podcast.rss"}
+	s.Atom.Templates.Lame = "lame.tmpl"
+	t.Chdir(t.TempDir())
+	if err := s.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.lameCommandTemplate, "lame -V2") {
+		t.Errorf("expected lame template relative to the spec, got %s", s.lameCommandTemplate)
+	}
+	if err := s.setTemplateFlags([]string{"lame=lame.tmpl"}); err == nil {
+		t.Error("expected --template lame=lame.tmpl to be relative to the current directory")
+	}
+	t.Chdir(dir)
+	if err := s.setTemplateFlags([]string{"lame=bad.tmpl"}); err == nil || !strings.Contains(err.Error(), "bad.tmpl") {
+		t.Errorf("expected bad.tmpl in the current directory to fail validation, got %v", err)
+	}
+}
+
+func TestTemplatesDump(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "templates")
+	set := flag.NewFlagSet("dump", flag.ContinueOnError)
+	set.String("dir", dir, "")
+	set.Bool("force", true, "")
+	if err := templatesDump(cli.NewContext(cli.NewApp(), set, nil)); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range NewShow("", "").templateSources() {
+		b, err := os.ReadFile(filepath.Join(dir, src.DumpFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != src.Default {
+			t.Errorf("expected %s to contain the default %s template", src.DumpFile, src.Name)
+		}
+	}
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"golang.org/x/term"
+)
+
+// Transfer of files between localStorageDir and the S3 buckets with
+// progress reporting. Files larger than the part size are uploaded in
+// parts. Uploaded parts are recorded in a state file under
+// localStorageDir so that an interrupted upload is resumed by the next
+// run instead of starting over. Failed requests are retried with
+// exponential backoff by the AWS SDK (see NewSession).
+
+const (
+	defaultUploadStateFile string = ".mkpod-uploads.json"
+	defaultPartSizeMiB     int64  = 16
+	minPartSizeMiB         int64  = 5
+	maxParts               int64  = 10000
+	defaultConcurrency     int    = 4
+	defaultMaxRetries      int    = 8
+
+	progressBarWidth    int           = 30
+	progressBarInterval time.Duration = 200 * time.Millisecond
+	progressLogInterval time.Duration = 10 * time.Second
+)
+
+type TransferConfig struct {
+	// Multipart part size in MiB (minimum 5, default 16).
+	PartSize int64 `yaml:"partSize,omitempty"`
+	// Number of parts transferred concurrently (default 4).
+	Concurrency int `yaml:"concurrency,omitempty"`
+	// Number of times a failed request is retried (default 8).
+	MaxRetries int `yaml:"maxRetries,omitempty"`
+}
+
+// PartSizeFor returns the part size in bytes to use for a file of
+// size bytes. The part size is increased if the file would otherwise
+// need more than the 10000 parts allowed by S3.
+func (t TransferConfig) PartSizeFor(size int64) int64 {
+	partSize := t.PartSize
+	if partSize < 1 {
+		partSize = defaultPartSizeMiB
+	}
+	if partSize < minPartSizeMiB {
+		partSize = minPartSizeMiB
+	}
+	partSize *= 1024 * 1024
+	if (size+partSize-1)/partSize > maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+	return partSize
+}
+
+// ConcurrencyOrDefault returns the configured concurrency or the
+// default.
+func (t TransferConfig) ConcurrencyOrDefault() int {
+	if t.Concurrency < 1 {
+		return defaultConcurrency
+	}
+	return t.Concurrency
+}
+
+// MaxRetriesOrDefault returns the configured number of retries or the
+// default.
+func (t TransferConfig) MaxRetriesOrDefault() int {
+	if t.MaxRetries < 1 {
+		return defaultMaxRetries
+	}
+	return t.MaxRetries
+}
+
+// Progress reports the progress of a transfer, as a progress bar if
+// stderr is a terminal or as periodic log lines if it is not.
+type Progress struct {
+	label   string
+	total   int64
+	initial int64
+	done    atomic.Int64
+	start   time.Time
+	tty     bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewProgress returns a progress of total bytes where done bytes have
+// already been transferred (e.g by a previous run).
+func NewProgress(label string, total int64, done int64) *Progress {
+	p := &Progress{
+		label:   label,
+		total:   total,
+		initial: done,
+		tty:     term.IsTerminal(int(os.Stderr.Fd())),
+	}
+	p.done.Store(done)
+	return p
+}
+
+// Add adds n transferred bytes, n is negative when bytes of a failed
+// attempt are discarded.
+func (p *Progress) Add(n int64) {
+	p.done.Add(n)
+}
+
+// Start starts reporting progress until Stop is called.
+func (p *Progress) Start() {
+	p.start = time.Now()
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	interval := progressLogInterval
+	if p.tty {
+		interval = progressBarInterval
+	}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.report()
+			}
+		}
+	}()
+}
+
+// Stop stops reporting progress and reports the final state.
+func (p *Progress) Stop() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+	p.stop = nil
+	p.report()
+	if p.tty {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func (p *Progress) report() {
+	if p.tty {
+		fmt.Fprintf(os.Stderr, "\r%s", p.Bar(progressBarWidth))
+		return
+	}
+	log.Printf("%s: %s", p.label, p.Summary())
+}
+
+// Percent returns the percentage transferred.
+func (p *Progress) Percent() int {
+	if p.total <= 0 {
+		return 100
+	}
+	done := min(max(p.done.Load(), 0), p.total)
+	return int(done * 100 / p.total)
+}
+
+// Summary returns percentage, bytes transferred and rate.
+func (p *Progress) Summary() string {
+	done := min(max(p.done.Load(), 0), p.total)
+	summary := fmt.Sprintf("%3d%% %s of %s", p.Percent(), humanBytes(done), humanBytes(p.total))
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 && done > p.initial {
+		summary += fmt.Sprintf(" (%s/s)", humanBytes(int64(float64(done-p.initial)/elapsed)))
+	}
+	return summary
+}
+
+// Bar returns a progress bar width characters wide followed by the
+// summary and label.
+func (p *Progress) Bar(width int) string {
+	filled := p.Percent() * width / 100
+	return fmt.Sprintf("[%s%s] %s %s", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), p.Summary(), p.label)
+}
+
+// progressReadCloser counts bytes read from the request body as they
+// are sent.
+type progressReadCloser struct {
+	io.ReadCloser
+	progress *Progress
+	sent     *atomic.Int64
+}
+
+func (r *progressReadCloser) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.sent.Add(int64(n))
+	r.progress.Add(int64(n))
+	return n, err
+}
+
+// withSendProgress returns a request option adding the bytes of the
+// request body to p as they are sent. Bytes sent by a failed attempt
+// are subtracted when the request is retried.
+func withSendProgress(p *Progress) request.Option {
+	return func(r *request.Request) {
+		sent := &atomic.Int64{}
+		r.Handlers.Send.PushFront(func(r *request.Request) {
+			p.Add(-sent.Swap(0))
+			if r.HTTPRequest.Body != nil && r.HTTPRequest.Body != http.NoBody {
+				r.HTTPRequest.Body = &progressReadCloser{
+					ReadCloser: r.HTTPRequest.Body,
+					progress:   p,
+					sent:       sent,
+				}
+			}
+		})
+	}
+}
+
+// progressWriterAt counts bytes written by the downloader.
+type progressWriterAt struct {
+	io.WriterAt
+	progress *Progress
+}
+
+func (w *progressWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	n, err := w.WriterAt.WriteAt(b, off)
+	w.progress.Add(int64(n))
+	return n, err
+}
+
+// MultipartUpload is an upload in progress, keyed by bucket/key in the
+// upload state. It is only resumed if the local file is unchanged.
+type MultipartUpload struct {
+	Bucket    string           `json:"bucket"`
+	Key       string           `json:"key"`
+	UploadID  string           `json:"uploadId"`
+	File      string           `json:"file"`
+	Size      int64            `json:"size"`
+	SHA256    string           `json:"sha256"`
+	PartSize  int64            `json:"partSize"`
+	Parts     map[int64]string `json:"parts"`
+	StartedAt time.Time        `json:"startedAt"`
+}
+
+// Matches returns true if the upload can be resumed for file with the
+// given hashes and part size.
+func (u *MultipartUpload) Matches(file string, local ManifestFile, partSize int64) bool {
+	return u.File == file && u.Size == local.Size && u.SHA256 == local.SHA256 && u.PartSize == partSize
+}
+
+// UploadState is the set of multipart uploads not yet completed.
+type UploadState struct {
+	Uploads map[string]*MultipartUpload `json:"uploads"`
+	path    string
+	mu      sync.Mutex
+}
+
+// LoadUploadState reads the state from file or returns an empty one if
+// file does not exist.
+func LoadUploadState(file string) (*UploadState, error) {
+	state := &UploadState{
+		Uploads: make(map[string]*MultipartUpload),
+		path:    file,
+	}
+	if err := loadJSON(file, state); err != nil {
+		return nil, err
+	}
+	if state.Uploads == nil {
+		state.Uploads = make(map[string]*MultipartUpload)
+	}
+	return state, nil
+}
+
+// Save writes the state to the file it was loaded from.
+func (u *UploadState) Save() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return saveJSON(u.path, u)
+}
+
+// uploadState returns the upload state stored in localStorageDir,
+// loading it on first use.
+func (s *AwsHandler) uploadState() (*UploadState, error) {
+	if s.Uploads == nil {
+		file := absPath(path.Join(s.Atom.LocalStorageDirExpanded(), defaultUploadStateFile))
+		localState.Lock()
+		defer localState.Unlock()
+		state, ok := localState.uploads[file]
+		if !ok {
+			var err error
+			if state, err = LoadUploadState(file); err != nil {
+				return nil, err
+			}
+			localState.uploads[file] = state
+		}
+		s.Uploads = state
+	}
+	return s.Uploads, nil
+}
+
+// upload returns the upload of id in the state.
+func (u *UploadState) upload(id string) (*MultipartUpload, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload, ok := u.Uploads[id]
+	return upload, ok
+}
+
+// ObjectProperties are the properties set on an uploaded object.
+type ObjectProperties struct {
+	ContentType string
+	Policy      UploadPolicy
+	SHA256      string
+}
+
+// uploadFile uploads file as bucket/key showing progress. Files larger
+// than the part size are uploaded (or resumed) in parts.
+func (s *AwsHandler) uploadFile(bucket string, key string, file string, local ManifestFile, props ObjectProperties) error {
+	partSize := s.Atom.Config.Transfer.PartSizeFor(local.Size)
+	if local.Size > partSize {
+		return s.uploadMultipart(bucket, key, file, local, partSize, props)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	progress := NewProgress("s3://"+path.Join(bucket, key), local.Size, 0)
+	progress.Start()
+	_, err = s.S3.PutObjectWithContext(aws.BackgroundContext(), &s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		Body:                 f,
+		ContentType:          optionalString(props.ContentType),
+		CacheControl:         optionalString(props.Policy.CacheControl),
+		ContentDisposition:   optionalString(props.Policy.ContentDisposition),
+		StorageClass:         optionalString(props.Policy.StorageClass),
+		ACL:                  optionalString(props.Policy.ACL),
+		ServerSideEncryption: optionalString(props.Policy.ServerSideEncryption),
+		Metadata:             props.Policy.ObjectMetadata(props.SHA256),
+	}, withSendProgress(progress))
+	progress.Stop()
+	return err
+}
+
+// uploadMultipart uploads file in parts of partSize, resuming a
+// previous upload of the same file if one is recorded in the upload
+// state. If the upload fails with an error that is not transient the
+// multipart upload is aborted, otherwise it is kept to be resumed by
+// the next run.
+func (s *AwsHandler) uploadMultipart(bucket string, key string, file string, local ManifestFile, partSize int64, props ObjectProperties) error {
+	state, err := s.uploadState()
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	id := path.Join(bucket, key)
+
+	upload, err := s.resumableUpload(state, id, abs, local, partSize)
+	if err != nil {
+		return err
+	}
+	if upload == nil {
+		result, err := s.S3.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket:               aws.String(bucket),
+			Key:                  aws.String(key),
+			ContentType:          optionalString(props.ContentType),
+			CacheControl:         optionalString(props.Policy.CacheControl),
+			ContentDisposition:   optionalString(props.Policy.ContentDisposition),
+			StorageClass:         optionalString(props.Policy.StorageClass),
+			ACL:                  optionalString(props.Policy.ACL),
+			ServerSideEncryption: optionalString(props.Policy.ServerSideEncryption),
+			Metadata:             props.Policy.ObjectMetadata(props.SHA256),
+		})
+		if err != nil {
+			return err
+		}
+		upload = &MultipartUpload{
+			Bucket:    bucket,
+			Key:       key,
+			UploadID:  aws.StringValue(result.UploadId),
+			File:      abs,
+			Size:      local.Size,
+			SHA256:    local.SHA256,
+			PartSize:  partSize,
+			Parts:     make(map[int64]string),
+			StartedAt: time.Now().UTC(),
+		}
+		state.mu.Lock()
+		state.Uploads[id] = upload
+		state.mu.Unlock()
+		if err := state.Save(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.uploadParts(upload, state); err != nil {
+		if transientError(err) {
+			log.Printf("Upload of %s to s3://%s was interrupted, run again to resume (%d of %d parts uploaded)", file, id, len(upload.Parts), partCount(upload.Size, upload.PartSize))
+			return err
+		}
+		return errors.Join(err, s.abortUpload(state, id))
+	}
+
+	var parts []*s3.CompletedPart
+	for number, etag := range upload.Parts {
+		parts = append(parts, &s3.CompletedPart{
+			PartNumber: aws.Int64(number),
+			ETag:       aws.String(etag),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+	})
+	if _, err := s.S3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(upload.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		if transientError(err) {
+			return err
+		}
+		return errors.Join(err, s.abortUpload(state, id))
+	}
+	state.mu.Lock()
+	delete(state.Uploads, id)
+	state.mu.Unlock()
+	return state.Save()
+}
+
+// resumableUpload returns the upload of id recorded in state if it can
+// be resumed, with the parts not found in the bucket removed. An
+// upload that can not be resumed is aborted and nil is returned.
+func (s *AwsHandler) resumableUpload(state *UploadState, id string, file string, local ManifestFile, partSize int64) (*MultipartUpload, error) {
+	upload, ok := state.upload(id)
+	if !ok {
+		return nil, nil
+	}
+	if !upload.Matches(file, local, partSize) {
+		log.Printf("Local file or part size has changed since upload to s3://%s started, starting over", id)
+		return nil, s.abortUpload(state, id)
+	}
+	remoteParts := make(map[int64]string)
+	err := s.S3.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(upload.Bucket),
+		Key:      aws.String(upload.Key),
+		UploadId: aws.String(upload.UploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			remoteParts[aws.Int64Value(part.PartNumber)] = aws.StringValue(part.ETag)
+		}
+		return true
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchUpload {
+			log.Printf("Upload to s3://%s no longer exists, starting over", id)
+			state.mu.Lock()
+			delete(state.Uploads, id)
+			state.mu.Unlock()
+			return nil, state.Save()
+		}
+		return nil, err
+	}
+	state.mu.Lock()
+	for number, etag := range upload.Parts {
+		if remoteParts[number] != etag {
+			delete(upload.Parts, number)
+		}
+	}
+	state.mu.Unlock()
+	log.Printf("Resuming upload of %s to s3://%s started %s (%d of %d parts uploaded)", file, id, upload.StartedAt.Format(time.RFC1123Z), len(upload.Parts), partCount(upload.Size, upload.PartSize))
+	return upload, nil
+}
+
+// uploadParts uploads the parts of upload not already uploaded using
+// the configured number of concurrent requests. Every completed part
+// is saved to state.
+func (s *AwsHandler) uploadParts(upload *MultipartUpload, state *UploadState) error {
+	f, err := os.Open(upload.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var uploaded int64
+	var pending []int64
+	for number := int64(1); number <= partCount(upload.Size, upload.PartSize); number++ {
+		if _, ok := upload.Parts[number]; ok {
+			uploaded += partLength(upload.Size, upload.PartSize, number)
+		} else {
+			pending = append(pending, number)
+		}
+	}
+
+	progress := NewProgress("s3://"+path.Join(upload.Bucket, upload.Key), upload.Size, uploaded)
+	progress.Start()
+	defer progress.Stop()
+
+	numbers := make(chan int64)
+	errs := make(chan error, len(pending))
+	var wg sync.WaitGroup
+	for i := 0; i < s.Atom.Config.Transfer.ConcurrencyOrDefault(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range numbers {
+				offset := (number - 1) * upload.PartSize
+				result, err := s.S3.UploadPartWithContext(aws.BackgroundContext(), &s3.UploadPartInput{
+					Bucket:     aws.String(upload.Bucket),
+					Key:        aws.String(upload.Key),
+					UploadId:   aws.String(upload.UploadID),
+					PartNumber: aws.Int64(number),
+					Body:       io.NewSectionReader(f, offset, partLength(upload.Size, upload.PartSize, number)),
+				}, withSendProgress(progress))
+				if err != nil {
+					errs <- fmt.Errorf("part %d: %w", number, err)
+					continue
+				}
+				state.mu.Lock()
+				upload.Parts[number] = aws.StringValue(result.ETag)
+				state.mu.Unlock()
+				if err := state.Save(); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, number := range pending {
+		if len(errs) > 0 {
+			break
+		}
+		numbers <- number
+	}
+	close(numbers)
+	wg.Wait()
+	close(errs)
+	// Return the first error, it is the one deciding whether the upload
+	// can be resumed.
+	return <-errs
+}
+
+// abortUpload aborts the multipart upload of id and removes it from
+// state.
+func (s *AwsHandler) abortUpload(state *UploadState, id string) error {
+	upload, ok := state.upload(id)
+	if !ok {
+		return nil
+	}
+	log.Printf("Aborting multipart upload to s3://%s", id)
+	_, err := s.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(upload.Bucket),
+		Key:      aws.String(upload.Key),
+		UploadId: aws.String(upload.UploadID),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != s3.ErrCodeNoSuchUpload {
+			return err
+		}
+	}
+	state.mu.Lock()
+	delete(state.Uploads, id)
+	state.mu.Unlock()
+	return state.Save()
+}
+
+// downloadFile downloads bucket/key of size bytes into f showing
+// progress.
+func (s *AwsHandler) downloadFile(f *os.File, bucket string, key string, size int64) (int64, error) {
+	progress := NewProgress("s3://"+path.Join(bucket, key), size, 0)
+	progress.Start()
+	defer progress.Stop()
+	downloader := s3manager.NewDownloader(s.Session, func(d *s3manager.Downloader) {
+		d.PartSize = s.Atom.Config.Transfer.PartSizeFor(size)
+		d.Concurrency = s.Atom.Config.Transfer.ConcurrencyOrDefault()
+	})
+	return downloader.Download(&progressWriterAt{WriterAt: f, progress: progress}, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+}
+
+// IncompleteUpload is a multipart upload found in a bucket.
+type IncompleteUpload struct {
+	Bucket    string
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// IncompleteUploads returns the multipart uploads in bucket that can
+// not be resumed, i.e those not recorded in the upload state or where
+// the local file has changed since the upload was started.
+func (s *AwsHandler) IncompleteUploads(bucket string) ([]IncompleteUpload, error) {
+	state, err := s.uploadState()
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.manifest()
+	if err != nil {
+		return nil, err
+	}
+	var incomplete []IncompleteUpload
+	err = s.S3.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, u := range page.Uploads {
+			key := aws.StringValue(u.Key)
+			uploadID := aws.StringValue(u.UploadId)
+			if upload, ok := state.upload(path.Join(bucket, key)); ok && upload.UploadID == uploadID {
+				if local, err := m.FileHashes(upload.File); err == nil && upload.Matches(upload.File, local, upload.PartSize) {
+					continue
+				}
+			}
+			incomplete = append(incomplete, IncompleteUpload{
+				Bucket:    bucket,
+				Key:       key,
+				UploadID:  uploadID,
+				Initiated: aws.TimeValue(u.Initiated),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return incomplete, m.Save()
+}
+
+// AbortIncompleteUpload aborts u and removes it from the upload state.
+func (s *AwsHandler) AbortIncompleteUpload(u IncompleteUpload) error {
+	state, err := s.uploadState()
+	if err != nil {
+		return err
+	}
+	if upload, ok := state.upload(path.Join(u.Bucket, u.Key)); ok && upload.UploadID == u.UploadID {
+		return s.abortUpload(state, path.Join(u.Bucket, u.Key))
+	}
+	_, err = s.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.Bucket),
+		Key:      aws.String(u.Key),
+		UploadId: aws.String(u.UploadID),
+	})
+	return err
+}
+
+// transientError returns true if err is an AWS error that was still
+// retryable when the SDK gave up, e.g a lost connection. Uploads failing
+// with such an error are kept to be resumed.
+func transientError(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	return request.IsErrorRetryable(awsErr) || request.IsErrorThrottle(awsErr)
+}
+
+// partCount returns the number of parts of partSize needed for size
+// bytes.
+func partCount(size int64, partSize int64) int64 {
+	return (size + partSize - 1) / partSize
+}
+
+// partLength returns the length of part number (starting at 1).
+func partLength(size int64, partSize int64, number int64) int64 {
+	return min(partSize, size-(number-1)*partSize)
+}
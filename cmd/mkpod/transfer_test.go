@@ -0,0 +1,104 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPartSizeFor(t *testing.T) {
+	const MiB = 1024 * 1024
+	for _, tc := range []struct {
+		config   TransferConfig
+		size     int64
+		expected int64
+	}{
+		{TransferConfig{}, 100 * MiB, defaultPartSizeMiB * MiB},
+		{TransferConfig{PartSize: 1}, 100 * MiB, minPartSizeMiB * MiB},
+		{TransferConfig{PartSize: 64}, 100 * MiB, 64 * MiB},
+		// 200 GiB in 16 MiB parts would need 12800 parts.
+		{TransferConfig{}, 200 * 1024 * MiB, (200*1024*MiB + maxParts - 1) / maxParts},
+	} {
+		got := tc.config.PartSizeFor(tc.size)
+		if got != tc.expected {
+			t.Errorf("%+v size %d: expected part size %d, got %d", tc.config, tc.size, tc.expected, got)
+		}
+		if partCount(tc.size, got) > maxParts {
+			t.Errorf("%+v size %d: %d parts exceeds %d", tc.config, tc.size, partCount(tc.size, got), maxParts)
+		}
+	}
+}
+
+func TestPartLength(t *testing.T) {
+	size, partSize := int64(25), int64(10)
+	if n := partCount(size, partSize); n != 3 {
+		t.Fatalf("expected 3 parts, got %d", n)
+	}
+	var total int64
+	for number, expected := range []int64{10, 10, 5} {
+		got := partLength(size, partSize, int64(number+1))
+		if got != expected {
+			t.Errorf("part %d: expected length %d, got %d", number+1, expected, got)
+		}
+		total += got
+	}
+	if total != size {
+		t.Errorf("expected parts to add up to %d, got %d", size, total)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	p := NewProgress("s3://mypodbucket/QZJ-E16.mp4", 2048, 512)
+	if p.Percent() != 25 {
+		t.Errorf("expected 25%%, got %d%%", p.Percent())
+	}
+	p.Add(2048)
+	if p.Percent() != 100 {
+		t.Errorf("expected progress to be capped at 100%%, got %d%%", p.Percent())
+	}
+	p.Add(-1024)
+	bar := p.Bar(10)
+	if !strings.HasPrefix(bar, "[=======   ]  75% 1.5 KiB of 2.0 KiB") || !strings.HasSuffix(bar, "s3://mypodbucket/QZJ-E16.mp4") {
+		t.Errorf("unexpected progress bar %q", bar)
+	}
+}
+
+func TestUploadState(t *testing.T) {
+	file := filepath.Join(t.TempDir(), defaultUploadStateFile)
+	state, err := LoadUploadState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := ManifestFile{Size: 25, SHA256: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"}
+	state.Uploads["mypodbucket/QZJ-E16.mp4"] = &MultipartUpload{
+		Bucket:   "mypodbucket",
+		Key:      "QZJ-E16.mp4",
+		UploadID: "upload-id",
+		File:     "/tmp/QZJ-E16.mp4",
+		Size:     local.Size,
+		SHA256:   local.SHA256,
+		PartSize: 10,
+		Parts:    map[int64]string{1: `"etag1"`, 2: `"etag2"`},
+	}
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadUploadState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, ok := loaded.Uploads["mypodbucket/QZJ-E16.mp4"]
+	if !ok {
+		t.Fatal("expected upload to be loaded")
+	}
+	if len(upload.Parts) != 2 || upload.Parts[2] != `"etag2"` {
+		t.Errorf("unexpected parts %v", upload.Parts)
+	}
+	if !upload.Matches("/tmp/QZJ-E16.mp4", local, 10) {
+		t.Error("expected upload to match unchanged file")
+	}
+	local.SHA256 = "changed"
+	if upload.Matches("/tmp/QZJ-E16.mp4", local, 10) {
+		t.Error("expected upload not to match changed file")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
@@ -16,6 +17,8 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -33,21 +36,42 @@ type Templates struct {
 	FFmpegToLame        *template.Template
 	FFmpegM4A           *template.Template
 	FFmpegPreProcessing *template.Template
+	FFmpegRetag         *template.Template
 }
 
 type AwsHandler struct {
-	Session *session.Session
-	S3      *s3.S3
+	// Atom of the show the handler is for.
+	Atom     *Atom
+	Session  *session.Session
+	S3       *s3.S3
+	Manifest *Manifest
+	Restores *RestoreState
+	Uploads  *UploadState
+	// Options of the run of the show the handler is for.
+	opts *RunOptions
 }
 
 // Initiate a new AWS session based on properties in private.yaml config file
 // (loadConfig() is required before calling this function).
 func (s *AwsHandler) NewSession() {
+	// Failed requests (including each part of a multipart transfer) are
+	// retried with exponential backoff.
+	config := request.WithRetryer(&aws.Config{
+		Region: aws.String(s.Atom.Config.Aws.Region),
+	}, client.DefaultRetryer{
+		NumMaxRetries:    s.Atom.Config.Transfer.MaxRetriesOrDefault(),
+		MinRetryDelay:    time.Second,
+		MaxRetryDelay:    30 * time.Second,
+		MinThrottleDelay: time.Second,
+		MaxThrottleDelay: 30 * time.Second,
+	})
+	if endpoint := strings.TrimSpace(s.Atom.Config.Aws.Endpoint); endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
 	s.Session = session.Must(session.NewSessionWithOptions(session.Options{
-		Profile: atom.Config.Aws.Profile,
-		Config: aws.Config{
-			Region: aws.String(atom.Config.Aws.Region),
-		},
+		Profile: s.Atom.Config.Aws.Profile,
+		Config:  *config,
 	}))
 	s.S3 = s3.New(s.Session)
 }
@@ -58,144 +82,171 @@ func (s *AwsHandler) Diff(bucket string, key string, file string) error {
 	if err != nil {
 		return err
 	}
-	downloader := s3manager.NewDownloader(s.Session)
-	buf := aws.NewWriteAtBuffer([]byte{})
-	n, err := downloader.Download(buf, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	remoteContent, err := s.Get(bucket, key)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			switch awsErr.Code() {
-			case "NotFound", "NoSuchKey":
-				log.Printf("Skipping diff of %s: s3://%s: %v", file, path.Join(bucket, key), err)
-				return nil
-			default:
-				return err
-			}
-		} else {
-			return err
+		if isNotFound(err) {
+			log.Printf("Skipping diff of %s: s3://%s: %v", file, path.Join(bucket, key), err)
+			return nil
 		}
+		return err
 	}
-	log.Printf("Downloaded %d bytes from s3://%s into buffer", n, path.Join(bucket, key))
+	log.Printf("Downloaded %d bytes from s3://%s into buffer", len(remoteContent), path.Join(bucket, key))
 
 	log.Printf("Diff between %s and s3://%s follows...", file, path.Join(bucket, key))
-	edits := myers.ComputeEdits(span.URIFromPath("s3://"+path.Join(bucket, key)), string(buf.Bytes()), string(fileContent))
-	diff := fmt.Sprint(gotextdiff.ToUnified("s3://"+path.Join(bucket, key), file, string(buf.Bytes()), edits))
+	edits := myers.ComputeEdits(span.URIFromPath("s3://"+path.Join(bucket, key)), string(remoteContent), string(fileContent))
+	diff := fmt.Sprint(gotextdiff.ToUnified("s3://"+path.Join(bucket, key), file, string(remoteContent), edits))
 	fmt.Println(diff)
 
 	return nil
 }
 
-// Upload file as key to S3 bucket.
-func (s *AwsHandler) Upload(bucket string, key string, contentType string, file string) error {
-	log.Printf("Uploading %s to s3://%s", file, path.Join(bucket, key))
-	f, err := os.Open(file)
-	if err != nil {
+// Get returns the content of bucket/key.
+func (s *AwsHandler) Get(bucket string, key string) ([]byte, error) {
+	downloader := s3manager.NewDownloader(s.Session)
+	buf := aws.NewWriteAtBuffer([]byte{})
+	if _, err := downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Upload file as key to S3 bucket applying the upload policy of kind.
+// The upload is skipped if the object already exists with identical
+// content and content type. If only the properties set by the policy
+// differ, the object is copied onto itself with the new properties
+// instead of being uploaded again. The SHA-256 of file is stored as
+// object metadata.
+func (s *AwsHandler) Upload(kind ObjectKind, bucket string, key string, contentType string, file string) error {
+	policy := s.Atom.Config.UploadPolicy(kind)
+	identical, local, remote, err := s.IsIdentical(bucket, key, file)
+	if err != nil && !isNotFound(err) {
 		return err
 	}
-	defer f.Close()
-	uploader := s3manager.NewUploader(s.Session)
-	result, err := uploader.Upload(&s3manager.UploadInput{
-		Bucket:      aws.String(bucket),
-		Key:         aws.String(key),
-		ContentType: aws.String(contentType),
-		Body:        f,
-	})
-	if err != nil {
+	if identical && remote.ContentType == contentType {
+		diffs := policy.Differences(remote)
+		if len(diffs) == 0 {
+			log.Printf("Will not upload %s as content of s3://%s is identical", file, path.Join(bucket, key))
+			return nil
+		}
+		log.Printf("Content of s3://%s is identical, but %s", path.Join(bucket, key), strings.Join(diffs, ", "))
+		return s.ApplyPolicy(policy, bucket, key, contentType, local.SHA256)
+	}
+	log.Printf("Uploading %s to s3://%s", file, path.Join(bucket, key))
+	if err := s.uploadFile(bucket, key, file, local, ObjectProperties{
+		ContentType: contentType,
+		Policy:      policy,
+		SHA256:      local.SHA256,
+	}); err != nil {
 		return err
 	}
-	log.Printf("Uploaded %s", aws.StringValue(&result.Location))
-	return nil
+	log.Printf("Uploaded s3://%s", path.Join(bucket, key))
+	return s.recordSync(bucket, key, file)
+}
+
+// ApplyPolicy replaces the properties of an existing object by copying
+// it onto itself (server-side) with the properties of policy.
+func (s *AwsHandler) ApplyPolicy(policy UploadPolicy, bucket string, key string, contentType string, sha256sum string) error {
+	log.Printf("Updating properties of s3://%s", path.Join(bucket, key))
+	_, err := s.S3.CopyObject(&s3.CopyObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		CopySource:           aws.String(url.PathEscape(path.Join(bucket, key))),
+		MetadataDirective:    aws.String(s3.MetadataDirectiveReplace),
+		ContentType:          optionalString(contentType),
+		CacheControl:         optionalString(policy.CacheControl),
+		ContentDisposition:   optionalString(policy.ContentDisposition),
+		StorageClass:         optionalString(policy.StorageClass),
+		ACL:                  optionalString(policy.ACL),
+		ServerSideEncryption: optionalString(policy.ServerSideEncryption),
+		Metadata:             policy.ObjectMetadata(sha256sum),
+	})
+	return err
 }
 
 // Download key from S3 bucket and store key as file under localStorageDir
 // property in private.yaml (running loadConfig() prior to calling this function
-// is required).
+// is required). If the local file exists and has the same content as
+// the object, nothing is downloaded.
 func (s *AwsHandler) Download(bucket string, key string) error {
-	log.Printf("Downloading s3://%s to %s", path.Join(bucket, key), path.Join(atom.LocalStorageDirExpanded(), key))
-	downloader := s3manager.NewDownloader(s.Session)
-	completePath := path.Join(atom.LocalStorageDirExpanded(), key)
+	return s.DownloadTo(bucket, key, key)
+}
+
+// DownloadTo is Download where the object is stored as name under
+// localStorageDir (e.g a staged object without the staging prefix).
+func (s *AwsHandler) DownloadTo(bucket string, key string, name string) error {
+	completePath := path.Join(s.Atom.LocalStorageDirExpanded(), name)
 	dirPath := path.Dir(completePath)
 	err := os.MkdirAll(dirPath, 0755)
 	if err != nil {
 		return err
 	}
-	var f *os.File
-	fi, err := os.Stat(completePath)
+	var remote *RemoteObject
+	_, err = os.Stat(completePath)
 	if err != nil {
-		// does file exist? if not, create it
-		if errors.Is(err, fs.ErrNotExist) {
-			f, err = os.Create(completePath)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-		} else {
-			// error is something else
+		if !errors.Is(err, fs.ErrNotExist) {
 			return err
 		}
 	} else {
-		// No error, could stat file
-		// Get content length of file in S3 bucket and compare size to file already
-		// on disk, do not download if they match.
-		size, err := awsHandler.GetSize(bucket, key)
+		// No error, could stat file. Compare content hash of the local
+		// file with the object in the bucket, do not download if they
+		// match.
+		var identical bool
+		var local ManifestFile
+		identical, local, remote, err = s.IsIdentical(bucket, key, completePath)
 		if err != nil {
-			if awsErr, ok := err.(awserr.Error); ok {
-				switch awsErr.Code() {
-				case "NotFound", "NoSuchKey":
-					log.Printf("s3://%s does not exist, will use local file %s only", path.Join(bucket, key), completePath)
-
-					// Upload local file to bucket with key?
-					if doAction("Upload %s to s3://%s?", completePath, path.Join(bucket, key)) {
-						// Upload...
-						uf, err := os.Open(completePath)
-						if err != nil {
-							return err
-						}
-						defer uf.Close()
-						log.Printf("Uploading %s to s3://%s", completePath, path.Join(bucket, key))
-						uploader := s3manager.NewUploader(s.Session)
-						uRes, err := uploader.Upload(&s3manager.UploadInput{
-							Bucket:       aws.String(bucket),
-							Key:          aws.String(key),
-							Body:         uf,
-							StorageClass: aws.String("GLACIER_IR"),
-						})
-						if err != nil {
-							return err
-						}
-						log.Printf("Successfully uploaded to %s", uRes.Location)
-					}
-					return nil
-				default:
+			if !isNotFound(err) {
+				return err
+			}
+			log.Printf("s3://%s does not exist, will use local file %s only", path.Join(bucket, key), completePath)
+
+			// Upload local file to bucket with key?
+			if s.opts.doAction("Upload %s to s3://%s?", completePath, path.Join(bucket, key)) {
+				log.Printf("Uploading %s to s3://%s", completePath, path.Join(bucket, key))
+				if err := s.uploadFile(bucket, key, completePath, local, ObjectProperties{
+					Policy: s.Atom.Config.UploadPolicy(ObjectKindMasters),
+					SHA256: local.SHA256,
+				}); err != nil {
 					return err
 				}
-			} else {
-				return err
+				log.Printf("Successfully uploaded to s3://%s", path.Join(bucket, key))
+				return s.recordSync(bucket, key, completePath)
 			}
+			return nil
 		}
-		if size != fi.Size() {
-			// size does not match, download file (truncate file and fall through)
-			f, err = os.Create(completePath)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-		} else {
-			log.Printf("Will not download %s as local file size and content length of s3://%s match", completePath, path.Join(bucket, key))
+		if identical {
+			log.Printf("Will not download %s as content of local file and s3://%s is identical", completePath, path.Join(bucket, key))
 			return nil
 		}
 	}
-	n, err := downloader.Download(f, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	if remote == nil {
+		remote, err = s.Head(bucket, key)
+		if err != nil {
+			return err
+		}
+	}
+	// Archived objects (GLACIER, DEEP_ARCHIVE) have to be restored
+	// before they can be downloaded.
+	if err := s.EnsureRestored(bucket, key, remote); err != nil {
+		return err
+	}
+	log.Printf("Downloading s3://%s to %s", path.Join(bucket, key), completePath)
+	f, err := os.Create(completePath)
+	if err != nil {
+		return err
+	}
+	n, err := s.downloadFile(f, bucket, key, remote.Size)
 	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
 		return err
 	}
 	log.Printf("Downloaded %d bytes from s3://%s to %s", n, path.Join(bucket, key), completePath)
-	return nil
+	return s.recordSync(bucket, key, completePath)
 }
 
 func (s *AwsHandler) GetSize(bucket string, key string) (int64, error) {
@@ -209,6 +260,51 @@ func (s *AwsHandler) GetSize(bucket string, key string) (int64, error) {
 	return aws.Int64Value(result.ContentLength), nil
 }
 
+// ObjectSummary is an object as listed by List.
+type ObjectSummary struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	StorageClass string    `json:"storageClass"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// List returns all objects in bucket keyed by object key.
+func (s *AwsHandler) List(bucket string) (map[string]ObjectSummary, error) {
+	objects := make(map[string]ObjectSummary)
+	err := s.S3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			key := aws.StringValue(o.Key)
+			objects[key] = ObjectSummary{
+				Key:          key,
+				Size:         aws.Int64Value(o.Size),
+				ETag:         strings.Trim(aws.StringValue(o.ETag), `"`),
+				StorageClass: aws.StringValue(o.StorageClass),
+				LastModified: aws.TimeValue(o.LastModified),
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// isNotFound returns true if err is an AWS error saying the bucket
+// key does not exist.
+func isNotFound(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "NotFound", "NoSuchKey":
+			return true
+		}
+	}
+	return false
+}
+
 type ItunesTime struct {
 	time.Time
 }
@@ -338,6 +434,29 @@ type Config struct {
 	DefaultPodImage string    `yaml:"defaultPodImage"`
 	Aws             AwsConfig `yaml:"aws"`
 	LocalStorageDir string    `yaml:"localStorageDir"`
+	// Glob patterns of keys and local files never removed by prune.
+	PruneKeep []string `yaml:"pruneKeep,omitempty"`
+	// Object properties per kind of uploaded object.
+	UploadPolicies UploadPolicies `yaml:"uploadPolicies,omitempty"`
+	// Retrieval options for masters archived in GLACIER or DEEP_ARCHIVE.
+	Restore RestoreConfig `yaml:"restore,omitempty"`
+	// Part size, concurrency and retries of uploads and downloads.
+	Transfer TransferConfig `yaml:"transfer,omitempty"`
+	// Staging bucket and/or prefix for review before promote.
+	Staging StagingConfig `yaml:"staging,omitempty"`
+	// WebSub hubs and Podping endpoint notified when the feed changes.
+	Notify NotifyConfig `yaml:"notify,omitempty"`
+	// URLs or commands run on episode.encoded, episode.uploaded,
+	// feed.rendered and feed.published.
+	Hooks []Hook `yaml:"hooks,omitempty"`
+	// Filenames of the JSON Feed and Atom feed rendered alongside the
+	// rss feed.
+	Feeds FeedsConfig `yaml:"feeds,omitempty"`
+	// Static website rendered by the site command.
+	Site SiteConfig `yaml:"site,omitempty"`
+	// Number of rotating backups kept when the spec is re-written, 3 if
+	// 0, none if negative.
+	SpecBackups int `yaml:"specBackups,omitempty"`
 }
 
 func (c *Config) LocalStorageDirExpanded() string {
@@ -348,6 +467,9 @@ type AwsConfig struct {
 	Profile string  `yaml:"profile"`
 	Region  string  `yaml:"region"`
 	Buckets Buckets `yaml:"buckets"`
+	// Endpoint of an S3 compatible service (e.g MinIO), addressed
+	// path-style, AWS if empty.
+	Endpoint string `yaml:"endpoint,omitempty"`
 }
 
 type Buckets struct {
@@ -386,7 +508,16 @@ type Atom struct {
 		Genre           string `yaml:"genre"`
 		Language        string `yaml:"language"`
 	} `yaml:"encoding"`
-	Episodes []Episode `yaml:"episodes"`
+	// Files replacing the embedded rss and command templates.
+	Templates TemplateFiles `yaml:"templates,omitempty"`
+	// Directory (relative to the spec) of episode files and globs of
+	// episode files included in addition to episodes.
+	EpisodesDir string   `yaml:"episodesDir,omitempty"`
+	Include     []string `yaml:"include,omitempty"`
+	// Deployment targets (e.g staging and production) by name, config
+	// overriding the config above when selected with --env.
+	Environments map[string]Config `yaml:"environments,omitempty"`
+	Episodes     []Episode         `yaml:"episodes"`
 }
 
 type Category struct {
@@ -436,6 +567,7 @@ type Combined struct {
 	Episode      *Episode
 	PreProcess   *PreProcess
 	MetadataFile string
+	TempFile     string
 }
 
 func (a *Atom) LocalStorageDirExpanded() string {
@@ -448,6 +580,88 @@ func (a *Atom) FFmpegPathExpanded() string {
 	return resolvetilde(a.Encoding.FFmpegPath)
 }
 
+// FeedURL returns the public URL of the rendered atom.
+func (a *Atom) FeedURL() string {
+	return a.Config.BaseURL + "/" + a.Atom
+}
+
+// EpisodeGUID returns the guid of episode as rendered in the feed.
+func (a *Atom) EpisodeGUID(episode *Episode) string {
+	if strings.TrimSpace(episode.GUID) != "" {
+		return episode.GUID
+	}
+	return a.Config.BaseURL + "/" + episode.Output
+}
+
+// ReferencedOutputKeys returns every key in the output bucket that is
+// referenced by the atom; the atom itself, the alternate feeds, the
+// podcast image (if served from baseURL), the default episode image,
+// the pages of the site (if configured) and the output file, image,
+// transcript and chapters file of each episode.
+func (a *Atom) ReferencedOutputKeys() map[string]bool {
+	keys := map[string]bool{a.Atom: true}
+	if key, found := strings.CutPrefix(a.Config.Image, a.Config.BaseURL+"/"); found && a.Config.BaseURL != "" {
+		keys[key] = true
+	}
+	if strings.TrimSpace(a.Config.DefaultPodImage) != "" {
+		keys[a.Config.DefaultPodImage] = true
+	}
+	// Staged objects (and the staging feed) are referenced when staging
+	// is a prefix in the output bucket.
+	staging := a.StagingTarget()
+	stagingInOutput := a.Config.StagingEnabled() && staging.Bucket == a.Config.Aws.Buckets.Output
+	for _, feed := range a.AlternateFeeds() {
+		keys[feed.Name] = true
+	}
+	if stagingInOutput {
+		keys[staging.Key(a.Atom)] = true
+		for _, feed := range a.AlternateFeeds() {
+			keys[staging.Key(feed.Name)] = true
+		}
+	}
+	if a.Config.SiteEnabled() {
+		for _, key := range a.SiteKeys() {
+			keys[key] = true
+		}
+	}
+	for _, e := range a.Episodes {
+		for _, key := range []string{e.Output, e.Image, e.Transcript, e.ChaptersFile()} {
+			if strings.TrimSpace(key) != "" {
+				keys[key] = true
+				if e.Staged && stagingInOutput {
+					keys[staging.Key(key)] = true
+				}
+			}
+		}
+	}
+	return keys
+}
+
+// ReferencedLocalFiles returns every file under localStorageDir
+// (relative to it) that is referenced by the atom; the cover and
+// default episode image, the manifest and the input, output, image,
+// transcript and chapters file of each episode.
+func (a *Atom) ReferencedLocalFiles() map[string]bool {
+	files := map[string]bool{
+		defaultManifestFile:     true,
+		defaultRestoreStateFile: true,
+		defaultUploadStateFile:  true,
+	}
+	for _, f := range []string{a.Encoding.Coverfront, a.Config.DefaultPodImage} {
+		if strings.TrimSpace(f) != "" {
+			files[path.Clean(f)] = true
+		}
+	}
+	for _, e := range a.Episodes {
+		for _, f := range []string{e.Input, e.Output, e.Image, e.Transcript, e.ChaptersFile()} {
+			if strings.TrimSpace(f) != "" {
+				files[path.Clean(f)] = true
+			}
+		}
+	}
+	return files
+}
+
 // Returns index of episode in Episodes slice based on UID or -1 if UID does not
 // exist.
 func (a *Atom) ContainsEpisode(uid int64) int {
@@ -480,6 +694,7 @@ type Defaults struct {
 type Episode struct {
 	UID              int64            `yaml:"uid"`
 	Title            string           `yaml:"title"`
+	Season           int              `yaml:"season,omitempty"`
 	PubDate          ItunesTime       `yaml:"pubDate"`
 	Link             string           `yaml:"link"`
 	Duration         ItunesDuration   `yaml:"duration"`
@@ -495,6 +710,14 @@ type Episode struct {
 	Format           string           `yaml:"format,omitempty"`
 	EncodingLanguage string           `yaml:"encodingLanguage,omitempty"`
 	Chapters         []id3v24.Chapter `yaml:"chapters,omitempty"`
+	Transcript       string           `yaml:"transcript,omitempty"`
+	// Output and image are in staging, not yet promoted to production.
+	Staged bool `yaml:"staged,omitempty"`
+	// Guid of an episode imported from another feed, the URL of the
+	// output file if empty.
+	GUID string `yaml:"guid,omitempty"`
+	// File the episode was included from, nil if it is in the spec.
+	source *EpisodeSource
 }
 
 type FFprobeDuration struct {
@@ -532,6 +755,11 @@ type FFprobeJSON struct {
 			Title            string `json:"title"`
 			Artist           string `json:"artist"`
 			Album            string `json:"album"`
+			Genre            string `json:"genre"`
+			Date             string `json:"date"`
+			Track            string `json:"track"`
+			Comment          string `json:"comment"`
+			Copyright        string `json:"copyright"`
 			Encoder          string `json:"encoder"`
 			ITunSMPB         string `json:"iTunSMPB"`
 		} `json:"tags"`
@@ -575,9 +803,13 @@ type Rss struct {
 			Title string `xml:"title"`
 			Link  string `xml:"link"`
 		} `xml:"image"`
-		Category struct {
+		Keywords string `xml:"keywords"`
+		Category []struct {
 			Text     string `xml:",chardata"`
 			AttrText string `xml:"text,attr"`
+			Category []struct {
+				AttrText string `xml:"text,attr"`
+			} `xml:"category"`
 		} `xml:"category"`
 		Item []struct {
 			Text string `xml:",chardata"`
@@ -588,13 +820,16 @@ type Rss struct {
 			Title       string `xml:"title"`
 			PubDate     string `xml:"pubDate"`
 			Link        string `xml:"link"`
+			Episode     string `xml:"episode"`
 			Duration    string `xml:"duration"`
 			Author      string `xml:"author"`
 			Explicit    string `xml:"explicit"`
 			Summary     string `xml:"summary"`
 			Subtitle    string `xml:"subtitle"`
 			Description string `xml:"description"`
-			Enclosure   struct {
+			// content:encoded
+			Encoded   string `xml:"encoded"`
+			Enclosure struct {
 				Text   string `xml:",chardata"`
 				Type   string `xml:"type,attr"`
 				URL    string `xml:"url,attr"`
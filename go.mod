@@ -6,12 +6,14 @@ require (
 	github.com/AlecAivazis/survey/v2 v2.3.7
 	github.com/alfg/mp4 v0.0.0-20210728035756-55ea58c08aeb
 	github.com/aws/aws-sdk-go v1.55.7
+	github.com/bogem/id3v2 v1.2.0
 	github.com/gabriel-vasile/mimetype v1.4.9
 	github.com/gomarkdown/markdown v0.0.0-20250311123330-531bef5e742b
 	github.com/hexops/gotextdiff v1.0.3
 	github.com/sa6mwa/id3v24 v0.4.0
 	github.com/sa6mwa/mp3duration v0.0.0-20221104103912-0716b1a5de6e
 	github.com/urfave/cli/v2 v2.27.6
+	golang.org/x/net v0.39.0
 	golang.org/x/term v0.32.0
 	gopkg.in/alessio/shellescape.v1 v1.0.0-20170105083845-52074bc9df61
 	gopkg.in/yaml.v2 v2.4.0
@@ -20,7 +22,6 @@ require (
 
 require (
 	github.com/alessio/shellescape v1.4.2 // indirect
-	github.com/bogem/id3v2 v1.2.0 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.7 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
@@ -31,7 +32,6 @@ require (
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/tcolgate/mp3 v0.0.0-20170426193717-e79c5a46d300 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
-	golang.org/x/net v0.39.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.25.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect